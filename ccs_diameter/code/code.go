@@ -8,6 +8,15 @@ const (
 	ABMF_CreditControl  = 272
 )
 
+// Result-Code AVP values for credit control, RFC 4006 9.1
+const (
+	EndUserServiceDenied       = 4010
+	CreditControlNotApplicable = 4011
+	CreditLimitReached         = 4012
+	UserUnknown                = 5030
+	RatingFailed               = 5031
+)
+
 const (
 	BeginTime = iota + 7000
 	ActualTime
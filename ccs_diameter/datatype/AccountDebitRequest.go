@@ -25,4 +25,8 @@ type AccountDebitRequest struct {
 	MultipleServicesIndicator     MultipleServicesIndicator      `avp:"Multiple-Services-Indicator"`
 	ProxyInfo                     diam_datatype.Grouped          `avp:"Proxy-Info"`
 	MultipleServicesCreditControl *MultipleServicesCreditControl `avp:"Multiple-Services-Credit-Control"`
+	ImpactOnCounter               []*ImpactOnCounter             `avp:"ImpactonCounter"`
+	// Further identities of the subscriber, e.g. its GPSI, marshaled as further Subscription-Id. Unmarshaled, it
+	// holds every Subscription-Id of the request, SubscriptionId first.
+	SubscriptionIds []*SubscriptionId `avp:"Subscription-Id"`
 }
@@ -0,0 +1,11 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type Counter struct {
+	CounterId         diam_datatype.UTF8String `avp:"CounterID"`
+	CounterValue      diam_datatype.Unsigned64 `avp:"CounterValue"`
+	CounterExpiryDate *diam_datatype.Time      `avp:"CounterExpiryDate"`
+}
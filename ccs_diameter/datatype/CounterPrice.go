@@ -0,0 +1,10 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type CounterPrice struct {
+	CounterId diam_datatype.UTF8String `avp:"CounterID"`
+	Price     *CCMoney                 `avp:"Price"`
+}
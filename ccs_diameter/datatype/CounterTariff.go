@@ -0,0 +1,10 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type CounterTariff struct {
+	CounterId   diam_datatype.UTF8String `avp:"CounterID"`
+	CounterTier []*CounterTier           `avp:"CounterTier"`
+}
@@ -0,0 +1,10 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type CounterTier struct {
+	CounterThreshold diam_datatype.Unsigned64 `avp:"CounterThreshold"`
+	RateElement      *RateElement             `avp:"Rate-Element"`
+}
@@ -0,0 +1,12 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type ImpactOnCounter struct {
+	CounterId          diam_datatype.UTF8String `avp:"CounterID"`
+	CounterValueChange diam_datatype.Integer64  `avp:"CounterValueChange"`
+	CounterValue       diam_datatype.Unsigned64 `avp:"CounterValue"`
+	CounterExpiryDate  *diam_datatype.Time      `avp:"CounterExpiryDate"`
+}
@@ -7,5 +7,5 @@ import (
 type NextMonetaryTariff struct {
 	CurrencyCode diam_datatype.Unsigned32 `avp:"Currency-Code"`
 	ScaleFactor  *ScaleFactor             `avp:"Scale-Factor"`
-	RateElement  *RateElement             `avp:"Rate-Element"`
+	RateElement  []*RateElement           `avp:"Rate-Element"`
 }
@@ -4,11 +4,12 @@ import (
 	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
 )
 
+// The zero value is no sub type, a rating request without RequestSubType is rejected
 const (
-	REQ_SUBTYPE_AOC     RequestSubType = 0
 	REQ_SUBTYPE_RESERVE RequestSubType = 1
 	REQ_SUBTYPE_DEBIT   RequestSubType = 2
 	REQ_SUBTYPE_RELEASE RequestSubType = 3
+	REQ_SUBTYPE_AOC     RequestSubType = 4
 )
 
 type RequestSubType diam_datatype.Enumerated
@@ -0,0 +1,9 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type RequestedCounters struct {
+	CounterId []diam_datatype.UTF8String `avp:"CounterID"`
+}
@@ -0,0 +1,10 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type ReservedBalance struct {
+	UnitValue        *UnitValue               `avp:"Unit-Value"`
+	ReservationCount diam_datatype.Unsigned32 `avp:"Reservation-Count"`
+}
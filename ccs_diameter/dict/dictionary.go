@@ -24,6 +24,7 @@ const (
 		</request>
 		<answer>
 			<rule avp="Session-Id" required="true" max="1"/>
+			<rule avp="Result-Code" required="false" max="1"/>
 			<rule avp="Origin-Host" required="true" max="1"/>
 			<rule avp="Origin-Realm" required="true" max="1"/>
 			<rule avp="Vendor-Specific-Application-Id" required="false" max="1"/>
@@ -43,6 +44,7 @@ const (
 		<avp name="Service-Rating" code="7002">
 			<data type="Grouped">
 				<rule avp="Service-Identifier" required="true" max="1"/>
+				<rule avp="CC-Unit-Type" required="false" max="1"/>
 				<rule avp="DestinationID" required="false" max="1"/>
 				<rule avp="ServiceInformation" required="false" max="1"/>
 				<rule avp="Extension" required="false" max="1"/>
@@ -54,14 +56,14 @@ const (
 				<rule avp="ExpiryTime" required="false" max="1"/>
 				<rule avp="ValidUnits" required="false" max="1"/>
 				<rule avp="MonetaryTariffAfterValidUnits" required="false" max="1"/>
-				<rule avp="Counter" required="false" max="1"/>
+				<rule avp="Counter" required="false"/>
 				<rule avp="BasicPriceTimeStamp" required="false" max="1"/>
 				<rule avp="BasicPrice" required="false" max="1"/>
-				<rule avp="CounterPrice" required="false" max="1"/>
-				<rule avp="CounterTariff" required="false" max="1"/>
+				<rule avp="CounterPrice" required="false"/>
+				<rule avp="CounterTariff" required="false"/>
 				<rule avp="RequestedCounters" required="false" max="1"/>
 				<rule avp="RequestSubType" required="false" max="1"/>
-				<rule avp="ImpactonCounter" required="false" max="1"/>
+				<rule avp="ImpactonCounter" required="false"/>
 				<rule avp="RequestedUnits" required="false" max="1"/>
 				<rule avp="ConsumedUnits" required="false" max="1"/>
 				<rule avp="ConsumedUnitsAfterTariffSwitch" required="false" max="1"/>
@@ -83,7 +85,10 @@ const (
 		</avp>
 
 		<avp name="Price" code="7005">
-			<data type="Unsigned32"/>
+			<data type="Grouped">
+				<rule avp="Unit-Value" required="true" max="1"/>
+				<rule avp="Currency-Code" required="false" max="1"/>
+			</data>
 		</avp>
 
 		<avp name="BillingInfo" code="7006">
@@ -98,7 +103,7 @@ const (
 			<data type="Grouped">
 				<rule avp="Currency-Code" required="false" max="1"/>
 				<rule avp="Scale-Factor" required="false" max="1"/>
-				<rule avp="Rate-Element" required="false" max="1"/>
+				<rule avp="Rate-Element" required="false"/>
 			</data>
 		</avp>
 
@@ -106,7 +111,7 @@ const (
 			<data type="Grouped">
 				<rule avp="Currency-Code" required="false" max="1"/>
 				<rule avp="Scale-Factor" required="false" max="1"/>
-				<rule avp="Rate-Element" required="false" max="1"/>
+				<rule avp="Rate-Element" required="false"/>
 			</data>
 		</avp>
 
@@ -122,16 +127,16 @@ const (
 			<data type="Grouped">
 				<rule avp="Currency-Code" required="false" max="1"/>
 				<rule avp="Scale-Factor" required="false" max="1"/>
-				<rule avp="Rate-Element" required="false" max="1"/>
+				<rule avp="Rate-Element" required="false"/>
 			</data>
 		</avp>
 
 		<avp name="RequestSubType" code="7013">
 			<data type="Enumerated">
-				<item code="0" name="REQ_SUBTYPE_AOC"/>
 				<item code="1" name="REQ_SUBTYPE_RESERVE"/>
 				<item code="2" name="REQ_SUBTYPE_DEBIT"/>
 				<item code="3" name="REQ_SUBTYPE_RELEASE"/>
+				<item code="4" name="REQ_SUBTYPE_AOC"/>
 			</data>
 		</avp>
 
@@ -144,7 +149,10 @@ const (
 		</avp>
 
 		<avp name="MonetaryQuota" code="7016">
-			<data type="Unsigned32"/>
+			<data type="Grouped">
+				<rule avp="Unit-Value" required="true" max="1"/>
+				<rule avp="Currency-Code" required="false" max="1"/>
+			</data>
 		</avp>
 
 		<avp name="RequestedUnits" code="7017">
@@ -160,7 +168,12 @@ const (
 		</avp>
 
 		<avp name="ImpactonCounter" code="7020">
-			<data type="Grouped"/>
+			<data type="Grouped">
+				<rule avp="CounterID" required="true" max="1"/>
+				<rule avp="CounterValueChange" required="true" max="1"/>
+				<rule avp="CounterValue" required="false" max="1"/>
+				<rule avp="CounterExpiryDate" required="false" max="1"/>
+			</data>
 		</avp>
 
 		<avp name="AllowedUnits" code="7021">
@@ -168,15 +181,23 @@ const (
 		</avp>
 
 		<avp name="RequestedCounters" code="7022">
-			<data type="Grouped"/>
+			<data type="Grouped">
+				<rule avp="CounterID" required="false"/>
+			</data>
 		</avp>
 
 		<avp name="CounterTariff" code="7023">
-			<data type="Grouped"/>
+			<data type="Grouped">
+				<rule avp="CounterID" required="true" max="1"/>
+				<rule avp="CounterTier" required="false"/>
+			</data>
 		</avp>
 
 		<avp name="CounterPrice" code="7024">
-			<data type="Grouped"/>
+			<data type="Grouped">
+				<rule avp="CounterID" required="true" max="1"/>
+				<rule avp="Price" required="true" max="1"/>
+			</data>
 		</avp>
 
 		<avp name="BasicPriceTimeStamp" code="7025">
@@ -184,7 +205,38 @@ const (
 		</avp>
 
 		<avp name="Counter" code="7026">
-			<data type="Grouped"/>
+			<data type="Grouped">
+				<rule avp="CounterID" required="true" max="1"/>
+				<rule avp="CounterValue" required="true" max="1"/>
+				<rule avp="CounterExpiryDate" required="false" max="1"/>
+			</data>
+		</avp>
+
+		<avp name="CounterID" code="7031">
+			<data type="UTF8String"/>
+		</avp>
+
+		<avp name="CounterValue" code="7032">
+			<data type="Unsigned64"/>
+		</avp>
+
+		<avp name="CounterExpiryDate" code="7033">
+			<data type="Time"/>
+		</avp>
+
+		<avp name="CounterValueChange" code="7034">
+			<data type="Integer64"/>
+		</avp>
+
+		<avp name="CounterTier" code="7035">
+			<data type="Grouped">
+				<rule avp="CounterThreshold" required="false" max="1"/>
+				<rule avp="Rate-Element" required="true" max="1"/>
+			</data>
+		</avp>
+
+		<avp name="CounterThreshold" code="7036">
+			<data type="Unsigned64"/>
 		</avp>
 
 		<avp name="Vendor-Specific-Application-Id" code="7027">
@@ -319,7 +371,7 @@ const (
 				<rule avp="Destination-Host" required="false" max="1"/>
 				<rule avp="User-Name" required="false" max="1"/>
 				<rule avp="Event-Timestamp" required="false" max="1"/>
-				<rule avp="Subscription-Id" required="false" max="1"/>
+				<rule avp="Subscription-Id" required="false"/>
 				<rule avp="Termination-Cause" required="false" max="1"/>
 				<rule avp="Service-Identifier" required="false" max="1"/>
 				<rule avp="Requested-Action" required="false" max="1"/>
@@ -327,6 +379,7 @@ const (
 				<rule avp="Multiple-Services-Credit-Control" required="false" max="1"/>
 				<rule avp="Proxy-Info" required="false" max="1"/>
 				<rule avp="Service-Information" required="false" max="1"/>
+				<rule avp="ImpactonCounter" required="false"/>
 			</request>
 			<answer>
 				<!-- http://tools.ietf.org/html/rfc4006#section-3.2 -->
@@ -388,6 +441,7 @@ const (
 				<item code="1" name="INITIAL_REQUEST"/>
 				<item code="2" name="UPDATE_REQUEST"/>
 				<item code="3" name="TERMINATION_REQUEST"/>
+				<item code="4" name="EVENT_REQUEST"/>
 			</data>
 		</avp>
 
@@ -776,12 +830,24 @@ const (
 
 		<avp name="AB-Response" code="7028">
 			<data type="Grouped">
-				<rule avp="Acct-Balance" required="true" max="1"/>
-				<rule avp="Counter" required="true" max="1"/>
+				<rule avp="Acct-Balance" required="false"/>
+				<rule avp="Counter" required="false"/>
+				<rule avp="Reserved-Balance" required="false" max="1"/>
+			</data>
+		</avp>
+
+		<avp name="Reserved-Balance" code="7037">
+			<data type="Grouped">
+				<rule avp="Unit-Value" required="true" max="1"/>
+				<rule avp="Reservation-Count" required="true" max="1"/>
 			</data>
 		</avp>
 
-		<avp name="Acct-Balance" code="7028">
+		<avp name="Reservation-Count" code="7038">
+			<data type="Unsigned32"/>
+		</avp>
+
+		<avp name="Acct-Balance" code="7030">
 			<data type="Grouped">
 				<rule avp="Acct-Balance-Id" required="true" max="1"/>
 				<rule avp="Unit-Value" required="true" max="1"/>
@@ -793,7 +859,36 @@ const (
 		</avp>
 
 		<avp name="Counter" code="7026">
-			<data type="Grouped"/>
+			<data type="Grouped">
+				<rule avp="CounterID" required="true" max="1"/>
+				<rule avp="CounterValue" required="true" max="1"/>
+				<rule avp="CounterExpiryDate" required="false" max="1"/>
+			</data>
+		</avp>
+
+		<avp name="CounterID" code="7031">
+			<data type="UTF8String"/>
+		</avp>
+
+		<avp name="CounterValue" code="7032">
+			<data type="Unsigned64"/>
+		</avp>
+
+		<avp name="CounterExpiryDate" code="7033">
+			<data type="Time"/>
+		</avp>
+
+		<avp name="CounterValueChange" code="7034">
+			<data type="Integer64"/>
+		</avp>
+
+		<avp name="ImpactonCounter" code="7020">
+			<data type="Grouped">
+				<rule avp="CounterID" required="true" max="1"/>
+				<rule avp="CounterValueChange" required="true" max="1"/>
+				<rule avp="CounterValue" required="false" max="1"/>
+				<rule avp="CounterExpiryDate" required="false" max="1"/>
+			</data>
 		</avp>
 	</application>
 </diameter>
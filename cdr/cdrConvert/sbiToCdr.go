@@ -35,7 +35,7 @@ func MultiUnitUsageToCdr(
 }
 
 // TODO
-// Only convert Local Sequence Number, Uplink, Downlink, Total Volumn, Service Specific Units currently.
+// Only convert Local Sequence Number, Time, Uplink, Downlink, Total Volumn, Service Specific Units currently.
 func UsedUnitContainerToCdr(
 	usedUnitContainerList []models.ChfConvergedChargingUsedUnitContainer,
 ) []cdrType.UsedUnitContainer {
@@ -58,6 +58,11 @@ func UsedUnitContainerToCdr(
 			},
 			ServiceSpecificUnits: &serviceSpecificUnits,
 		}
+		if usedUnitContainer.Time != 0 {
+			cdrUsedUnitContainer.Time = &cdrType.CallDuration{
+				Value: int64(usedUnitContainer.Time),
+			}
+		}
 		cdrUsedUnitContainerList = append(cdrUsedUnitContainerList, cdrUsedUnitContainer)
 	}
 
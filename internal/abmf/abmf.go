@@ -53,13 +53,13 @@ func SendAccountDebitRequest(
 	select {
 	case m := <-ue.AcctChan:
 		var cca charging_datatype.AccountDebitResponse
-		if errMarshal := m.Unmarshal(&cca); err != nil {
+		if errMarshal := m.Unmarshal(&cca); errMarshal != nil {
 			return nil, fmt.Errorf("failed to parse message from %v", errMarshal)
 		}
 
 		return &cca, nil
 	case <-time.After(5 * time.Second):
-		return nil, fmt.Errorf("timeout: no rate answer received")
+		return nil, fmt.Errorf("timeout: no account answer received")
 	}
 }
 
@@ -0,0 +1,432 @@
+package account
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/free5gc/chf/internal/money"
+)
+
+// An update conflicting with others is attempted again at most this many times
+const maxUpdateAttempts = 64
+
+// The members of a shared account who reserved within this window share its balances evenly
+const fairShareWindow = 10 * time.Minute
+
+// Unit types of the buckets
+const (
+	UnitTypeMoney  = "money"
+	UnitTypeOctets = "octets"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrBarred            = errors.New("account is barred")
+	ErrConflict          = errors.New("account updated concurrently")
+)
+
+// Bucket is a balance of the account, e.g. the main money balance, bonus money or a free data bundle.
+// The buckets applying to a rating group are consumed by increasing priority, then by the earliest expiry.
+type Bucket struct {
+	// Identifies the bucket in the Acct-Balance AVP
+	BucketId uint64
+	Name     string
+	UnitType string
+	// Amount in the currency of the account for money, number of octets for octets
+	Balance money.Money
+	// The bucket applies from ValidFrom until ValidUntil, without bound if zero
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	// Rating groups the bucket applies to, every rating group if empty
+	RatingGroups []uint32
+	Priority     int
+}
+
+// AppliesTo tells if the bucket can be consumed by the rating group at now
+func (b *Bucket) AppliesTo(ratingGroup uint32, now time.Time) bool {
+	if !b.ValidFrom.IsZero() && now.Before(b.ValidFrom) {
+		return false
+	}
+	if !b.ValidUntil.IsZero() && !now.Before(b.ValidUntil) {
+		return false
+	}
+	if len(b.RatingGroups) == 0 {
+		return true
+	}
+	for _, rg := range b.RatingGroups {
+		if rg == ratingGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Member is a subscriber drawing from a shared account, identified by its SUPI or its GPSI, e.g. "msisdn-..."
+type Member struct {
+	UeId string
+	// Sub-limit of the member for each unit type, the member draws without limit from the unit types without one
+	Limits map[string]money.Money
+	// Amounts drawn by the member for each unit type, counted against its sub-limits until the operator resets them
+	Used map[string]money.Money
+	// Last time the member reserved from the account
+	LastReservation time.Time
+}
+
+// Account holds the buckets of a subscriber, or of a family or an enterprise whose members share them.
+// It is read for a rating group and its version is incremented by each update.
+type Account struct {
+	// The subscriber the account is read for, a member of a shared account
+	UeId string
+	// Identifies a shared account, empty for the account of a single subscriber
+	AccountId    string
+	Members      []Member
+	RatingGroup  uint32
+	Buckets      []Bucket
+	CurrencyCode uint32
+	Barred       bool
+	// Reservations of the charging sessions of every rating group
+	Reservations []Reservation
+	Version      int64
+
+	// Read from the charging data of the rating group rather than from an account with buckets
+	chargingData bool
+}
+
+// Units are the amounts requested from, debited from or refunded to an account
+type Units struct {
+	Money  money.Money
+	Octets uint64
+}
+
+// Balance is the sum of the buckets of the unit type the rating group of the account can consume at now
+func (a *Account) Balance(unitType string, now time.Time) money.Money {
+	var balance money.Money
+	for _, i := range a.consumptionOrder(unitType, now) {
+		balance = balance.Add(a.Buckets[i].Balance)
+	}
+	return balance
+}
+
+// Available is the balance of the unit type the subscriber the account is read for can still draw at now,
+// within its sub-limit if the account is shared
+func (a *Account) Available(unitType string, now time.Time) money.Money {
+	balance := a.Balance(unitType, now)
+	if allowance, limited := a.allowance(unitType); limited && allowance.Cmp(balance) < 0 {
+		return allowance
+	}
+	return balance
+}
+
+// member is the member of a shared account the account is read for, nil for the account of a single subscriber
+func (a *Account) member() *Member {
+	if a.AccountId == "" {
+		return nil
+	}
+	for i := range a.Members {
+		if a.Members[i].UeId == a.UeId {
+			return &a.Members[i]
+		}
+	}
+	return nil
+}
+
+// allowance is what is left of the sub-limit of the member for the unit type, limited is false without sub-limit
+func (a *Account) allowance(unitType string) (allowance money.Money, limited bool) {
+	member := a.member()
+	if member == nil {
+		return money.Money{}, false
+	}
+	limit, limited := member.Limits[unitType]
+	if !limited {
+		return money.Money{}, false
+	}
+	if allowance = limit.Sub(member.Used[unitType]); allowance.Sign() < 0 {
+		allowance = money.Money{}
+	}
+	return allowance, true
+}
+
+// draw counts the amount drawn by the member against its sub-limit, a negative amount gives it back
+func (a *Account) draw(unitType string, amount money.Money) {
+	member := a.member()
+	if member == nil || amount.IsZero() {
+		return
+	}
+	used := member.Used[unitType].Add(amount)
+	if used.Sign() < 0 {
+		used = money.Money{}
+	}
+	if member.Used == nil {
+		member.Used = make(map[string]money.Money)
+	}
+	member.Used[unitType] = used
+}
+
+// fairShare is the part of the balance of the unit type a member of a shared account can reserve at once:
+// the balance is split evenly between the member and the others who reserved recently. The share of money
+// is rounded down to 10^-4, the share of octets to whole octets.
+func (a *Account) fairShare(unitType string, now time.Time) (share money.Money, shared bool) {
+	member := a.member()
+	if member == nil {
+		return money.Money{}, false
+	}
+	drawing := uint64(1)
+	for i := range a.Members {
+		other := &a.Members[i]
+		if other != member && !other.LastReservation.IsZero() && now.Sub(other.LastReservation) < fairShareWindow {
+			drawing++
+		}
+	}
+	balance := a.Balance(unitType, now)
+	if drawing == 1 {
+		return balance, true
+	}
+	step := money.FromInt(1)
+	if unitType == UnitTypeMoney {
+		step = money.New(1, -4)
+	}
+	return step.Mul(balance.Units(step.Mul(drawing))), true
+}
+
+// reservable caps the amount requested at the sub-limit and at the fair share of the member
+func (a *Account) reservable(unitType string, requested money.Money, now time.Time) money.Money {
+	if allowance, limited := a.allowance(unitType); limited && allowance.Cmp(requested) < 0 {
+		requested = allowance
+	}
+	if share, shared := a.fairShare(unitType, now); shared && share.Cmp(requested) < 0 {
+		requested = share
+	}
+	return requested
+}
+
+// consumptionOrder returns the indexes of the buckets of the unit type applying at now, in consumption order
+func (a *Account) consumptionOrder(unitType string, now time.Time) []int {
+	var order []int
+	for i := range a.Buckets {
+		if a.Buckets[i].UnitType == unitType && a.Buckets[i].AppliesTo(a.RatingGroup, now) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		first, second := &a.Buckets[order[i]], &a.Buckets[order[j]]
+		if first.Priority != second.Priority {
+			return first.Priority < second.Priority
+		}
+		if first.ValidUntil.IsZero() || second.ValidUntil.IsZero() {
+			return !first.ValidUntil.IsZero() && second.ValidUntil.IsZero()
+		}
+		return first.ValidUntil.Before(second.ValidUntil)
+	})
+	return order
+}
+
+// consume takes up to amount from the positive balances of the buckets in consumption order and returns
+// the amount taken. If overdraw is set, the amount the buckets cannot cover is taken from the last bucket.
+func (a *Account) consume(unitType string, amount money.Money, now time.Time, overdraw bool) money.Money {
+	order := a.consumptionOrder(unitType, now)
+	left := amount
+	for _, i := range order {
+		if left.Sign() <= 0 {
+			break
+		}
+		bucket := &a.Buckets[i]
+		if bucket.Balance.Sign() <= 0 {
+			continue
+		}
+		taken := left
+		if taken.Cmp(bucket.Balance) > 0 {
+			taken = bucket.Balance
+		}
+		bucket.Balance = bucket.Balance.Sub(taken)
+		left = left.Sub(taken)
+	}
+	if overdraw && left.Sign() > 0 && len(order) != 0 {
+		last := &a.Buckets[order[len(order)-1]]
+		last.Balance = last.Balance.Sub(left)
+		left = money.Money{}
+	}
+	return amount.Sub(left)
+}
+
+// credit adds the amount to the first bucket in consumption order, so that it is consumed again first
+func (a *Account) credit(unitType string, amount money.Money, now time.Time) {
+	if amount.IsZero() {
+		return
+	}
+	if order := a.consumptionOrder(unitType, now); len(order) != 0 {
+		a.Buckets[order[0]].Balance = a.Buckets[order[0]].Balance.Add(amount)
+	}
+}
+
+func (a *Account) clone() *Account {
+	cloned := *a
+	cloned.Buckets = make([]Bucket, len(a.Buckets))
+	copy(cloned.Buckets, a.Buckets)
+	cloned.Reservations = make([]Reservation, len(a.Reservations))
+	copy(cloned.Reservations, a.Reservations)
+	cloned.Members = make([]Member, len(a.Members))
+	for i, member := range a.Members {
+		cloned.Members[i] = member
+		cloned.Members[i].Used = make(map[string]money.Money, len(member.Used))
+		for unitType, used := range member.Used {
+			cloned.Members[i].Used[unitType] = used
+		}
+	}
+	return &cloned
+}
+
+// unchanged tells if the balances of the buckets, the draws of the members and the reservations are the same
+// in both accounts
+func (a *Account) unchanged(o *Account) bool {
+	if len(a.Reservations) != len(o.Reservations) {
+		return false
+	}
+	for i := range a.Reservations {
+		first, second := &a.Reservations[i], &o.Reservations[i]
+		if first.SessionId != second.SessionId || first.RatingGroup != second.RatingGroup ||
+			first.Money.Cmp(second.Money) != 0 || first.Octets != second.Octets ||
+			!first.ExpiresAt.Equal(second.ExpiresAt) || first.Expired != second.Expired {
+			return false
+		}
+	}
+	for i := range a.Buckets {
+		if a.Buckets[i].Balance.Cmp(o.Buckets[i].Balance) != 0 {
+			return false
+		}
+	}
+	for i := range a.Members {
+		first, second := &a.Members[i], &o.Members[i]
+		if !first.LastReservation.Equal(second.LastReservation) || len(first.Used) != len(second.Used) {
+			return false
+		}
+		for unitType, used := range first.Used {
+			if used.Cmp(second.Used[unitType]) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Store persists the accounts
+type Store interface {
+	// Get returns the account of the subscriber read for the rating group, nil if the subscriber has no account
+	// for the rating group. The subscriber is identified by its SUPI first, then e.g. by its GPSI: its own
+	// account is the account of its SUPI, otherwise it draws from the shared account it is a member of.
+	Get(ueIds []string, ratingGroup uint32) (*Account, error)
+	// CompareAndSwap sets the buckets, the members and the reservations of the account as updated and increments
+	// its version if the stored version is still the version of the account, it returns false and leaves the
+	// account unchanged otherwise
+	CompareAndSwap(account, updated *Account) (bool, error)
+	// ExpiredReservations returns the reservations of every account that expired at now and are still to be
+	// returned, along with the returned ones whose retention ended
+	ExpiredReservations(now time.Time, retention time.Duration) ([]Reservation, error)
+	// HasAccount tells if the subscriber still has an account of its own or is a member of a shared account
+	HasAccount(ueId string) (bool, error)
+}
+
+// Change changes the balances of the buckets of the account, the account is left unchanged if it returns an error
+type Change func(account *Account) error
+
+// Update applies the change atomically: the change is computed from the stored account and only written if no
+// other update happened meanwhile, otherwise it is computed again from the account as updated by the others.
+// It returns the account before and after the update, both are the stored account if the change fails.
+func Update(store Store, ueIds []string, ratingGroup uint32, change Change) (previous, updated *Account, err error) {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		previous, err = store.Get(ueIds, ratingGroup)
+		if err != nil || previous == nil {
+			return previous, previous, err
+		}
+
+		updated = previous.clone()
+		if errChange := change(updated); errChange != nil {
+			return previous, previous, errChange
+		}
+		if updated.unchanged(previous) {
+			return previous, previous, nil
+		}
+
+		swapped, errSwap := store.CompareAndSwap(previous, updated)
+		if errSwap != nil {
+			return previous, previous, errSwap
+		}
+		if swapped {
+			updated.Version++
+			return previous, updated, nil
+		}
+	}
+	return previous, previous, ErrConflict
+}
+
+// Reserve grants the units requested from the buckets, only the remaining balances if they are lower.
+// A member of a shared account is granted at most what is left of its sub-limit and its fair share.
+func Reserve(requested Units, now time.Time, granted *Units) Change {
+	return func(account *Account) error {
+		if account.Barred {
+			return ErrBarred
+		}
+		octets := money.FromInt(int64(requested.Octets))
+		granted.Money = account.consume(UnitTypeMoney, account.reservable(UnitTypeMoney, requested.Money, now),
+			now, false)
+		grantedOctets := account.consume(UnitTypeOctets, account.reservable(UnitTypeOctets, octets, now), now, false)
+		granted.Octets = uint64(grantedOctets.Int64())
+
+		account.draw(UnitTypeMoney, granted.Money)
+		account.draw(UnitTypeOctets, grantedOctets)
+		if member := account.member(); member != nil && (!requested.Money.IsZero() || requested.Octets != 0) {
+			member.LastReservation = now
+		}
+		return nil
+	}
+}
+
+// Debit debits the whole units at once or nothing if the balances, or the sub-limits of a member of a shared
+// account, are insufficient
+func Debit(requested Units, now time.Time) Change {
+	return func(account *Account) error {
+		if account.Barred {
+			return ErrBarred
+		}
+		octets := money.FromInt(int64(requested.Octets))
+		if requested.Money.Cmp(account.Available(UnitTypeMoney, now)) > 0 ||
+			octets.Cmp(account.Available(UnitTypeOctets, now)) > 0 {
+			return ErrInsufficientFunds
+		}
+		account.consume(UnitTypeMoney, requested.Money, now, false)
+		account.consume(UnitTypeOctets, octets, now, false)
+		account.draw(UnitTypeMoney, requested.Money)
+		account.draw(UnitTypeOctets, octets)
+		return nil
+	}
+}
+
+// Charge debits the units used whatever the balances and the sub-limits, e.g. the usage reported at the end
+// of a session, the money the buckets cannot cover is owed on the last bucket
+func Charge(used Units, now time.Time) Change {
+	return func(account *Account) error {
+		if account.Barred {
+			return ErrBarred
+		}
+		if account.consume(UnitTypeMoney, used.Money, now, true).Cmp(used.Money) < 0 {
+			// No money bucket applies to the rating group
+			return ErrInsufficientFunds
+		}
+		octets := account.consume(UnitTypeOctets, money.FromInt(int64(used.Octets)), now, false)
+		account.draw(UnitTypeMoney, used.Money)
+		account.draw(UnitTypeOctets, octets)
+		return nil
+	}
+}
+
+// Refund credits the units back to the account, even a barred one, and gives them back to the sub-limits
+func Refund(refunded Units, now time.Time) Change {
+	return func(account *Account) error {
+		octets := money.FromInt(int64(refunded.Octets))
+		account.credit(UnitTypeMoney, refunded.Money, now)
+		account.credit(UnitTypeOctets, octets, now)
+		account.draw(UnitTypeMoney, refunded.Money.Neg())
+		account.draw(UnitTypeOctets, octets.Neg())
+		return nil
+	}
+}
@@ -0,0 +1,453 @@
+package account
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/internal/money"
+)
+
+const (
+	ueId        = "imsi-208930000000001"
+	ratingGroup = 1
+)
+
+var (
+	ueIds = []string{ueId}
+	now   = time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC)
+)
+
+// mainAccount has a single money bucket applying to every rating group
+func mainAccount(balance int64, barred bool) Account {
+	return Account{
+		UeId:    ueId,
+		Buckets: []Bucket{{BucketId: 1, Name: "main", UnitType: UnitTypeMoney, Balance: money.FromInt(balance)}},
+		Barred:  barred,
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	var granted Units
+	testCases := []struct {
+		name    string
+		barred  bool
+		change  Change
+		balance string
+		version int64
+		err     error
+	}{
+		{name: "reserve", change: Reserve(Units{Money: money.New(30, 0)}, now, &granted), balance: "70", version: 1},
+		{
+			name:    "reserve above the balance",
+			change:  Reserve(Units{Money: money.New(130, 0)}, now, &granted),
+			balance: "0",
+			version: 1,
+		},
+		{name: "debit", change: Debit(Units{Money: money.New(100, 0)}, now), balance: "0", version: 1},
+		{
+			name:    "debit above the balance",
+			change:  Debit(Units{Money: money.New(101, 0)}, now),
+			balance: "100",
+			err:     ErrInsufficientFunds,
+		},
+		{
+			name:    "charge above the balance",
+			change:  Charge(Units{Money: money.New(101, 0)}, now),
+			balance: "-1",
+			version: 1,
+		},
+		{name: "refund", change: Refund(Units{Money: money.New(5, -1)}, now), balance: "100.5", version: 1},
+		{
+			name:    "debit barred",
+			barred:  true,
+			change:  Debit(Units{Money: money.New(1, 0)}, now),
+			balance: "100",
+			err:     ErrBarred,
+		},
+		{
+			name:    "refund barred",
+			barred:  true,
+			change:  Refund(Units{Money: money.New(1, 0)}, now),
+			balance: "101",
+			version: 1,
+		},
+		{name: "nothing changed", change: Reserve(Units{}, now, &granted), balance: "100"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			store.Put(mainAccount(100, tc.barred))
+
+			previous, updated, err := Update(store, ueIds, ratingGroup, tc.change)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, "100", previous.Balance(UnitTypeMoney, now).String())
+			require.Equal(t, tc.balance, updated.Balance(UnitTypeMoney, now).String())
+			stored, err := store.Get(ueIds, ratingGroup)
+			require.NoError(t, err)
+			require.Equal(t, tc.balance, stored.Balance(UnitTypeMoney, now).String())
+			require.Equal(t, tc.version, stored.Version)
+		})
+	}
+}
+
+func TestUpdateUnknownAccount(t *testing.T) {
+	previous, updated, err := Update(NewMemoryStore(), ueIds, ratingGroup, Refund(Units{Money: money.New(1, 0)}, now))
+	require.NoError(t, err)
+	require.Nil(t, previous)
+	require.Nil(t, updated)
+}
+
+func TestBuckets(t *testing.T) {
+	monthEnd := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	buckets := []Bucket{
+		{BucketId: 1, Name: "main", UnitType: UnitTypeMoney, Balance: money.FromInt(10), Priority: 2},
+		{BucketId: 2, Name: "bonus", UnitType: UnitTypeMoney, Balance: money.FromInt(5), Priority: 1},
+		// Bonus of the video rating group only
+		{BucketId: 3, Name: "video", UnitType: UnitTypeMoney, Balance: money.FromInt(3), RatingGroups: []uint32{2}},
+		{BucketId: 4, Name: "expired", UnitType: UnitTypeMoney, Balance: money.FromInt(7), ValidUntil: now},
+		{BucketId: 5, Name: "next month", UnitType: UnitTypeMoney, Balance: money.FromInt(7), ValidFrom: monthEnd},
+		{BucketId: 6, Name: "data", UnitType: UnitTypeOctets, Balance: money.FromInt(1000), ValidUntil: monthEnd},
+		{
+			BucketId:   7,
+			Name:       "rollover data",
+			UnitType:   UnitTypeOctets,
+			Balance:    money.FromInt(500),
+			ValidUntil: now.Add(time.Hour),
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		ratingGroup uint32
+		change      func(granted *Units) Change
+		granted     Units
+		balances    []string
+		err         error
+	}{
+		{
+			name:        "bonus money first",
+			ratingGroup: 1,
+			change:      func(granted *Units) Change { return Reserve(Units{Money: money.FromInt(8)}, now, granted) },
+			granted:     Units{Money: money.FromInt(8)},
+			balances:    []string{"7", "0", "3", "7", "7", "1000", "500"},
+		},
+		{
+			name:        "bucket of the rating group",
+			ratingGroup: 2,
+			change:      func(granted *Units) Change { return Reserve(Units{Money: money.FromInt(4)}, now, granted) },
+			granted:     Units{Money: money.FromInt(4)},
+			balances:    []string{"10", "4", "0", "7", "7", "1000", "500"},
+		},
+		{
+			name:        "balance of the valid buckets only",
+			ratingGroup: 1,
+			change:      func(granted *Units) Change { return Reserve(Units{Money: money.FromInt(20)}, now, granted) },
+			granted:     Units{Money: money.FromInt(15)},
+			balances:    []string{"0", "0", "3", "7", "7", "1000", "500"},
+		},
+		{
+			name:        "earliest expiring data first",
+			ratingGroup: 1,
+			change:      func(granted *Units) Change { return Reserve(Units{Octets: 600}, now, granted) },
+			granted:     Units{Octets: 600},
+			balances:    []string{"10", "5", "3", "7", "7", "900", "0"},
+		},
+		{
+			name:        "debit of money and octets",
+			ratingGroup: 1,
+			change:      func(*Units) Change { return Debit(Units{Money: money.FromInt(6), Octets: 100}, now) },
+			balances:    []string{"9", "0", "3", "7", "7", "1000", "400"},
+		},
+		{
+			name:        "debit above the octets",
+			ratingGroup: 1,
+			change:      func(*Units) Change { return Debit(Units{Octets: 1501}, now) },
+			balances:    []string{"10", "5", "3", "7", "7", "1000", "500"},
+			err:         ErrInsufficientFunds,
+		},
+		{
+			name:        "charge overdraws the last bucket",
+			ratingGroup: 1,
+			change:      func(*Units) Change { return Charge(Units{Money: money.FromInt(20)}, now) },
+			balances:    []string{"-5", "0", "3", "7", "7", "1000", "500"},
+		},
+		{
+			name:        "refund to the first bucket",
+			ratingGroup: 1,
+			change:      func(*Units) Change { return Refund(Units{Money: money.FromInt(2), Octets: 10}, now) },
+			balances:    []string{"10", "7", "3", "7", "7", "1000", "510"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			store.Put(Account{UeId: ueId, Buckets: buckets})
+
+			var granted Units
+			_, _, err := Update(store, ueIds, tc.ratingGroup, tc.change(&granted))
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.granted.Money.String(), granted.Money.String())
+			require.Equal(t, tc.granted.Octets, granted.Octets)
+			stored, err := store.Get(ueIds, tc.ratingGroup)
+			require.NoError(t, err)
+			var balances []string
+			for _, bucket := range stored.Buckets {
+				balances = append(balances, bucket.Balance.String())
+			}
+			require.Equal(t, tc.balances, balances)
+		})
+	}
+}
+
+// conflictingStore updates the account behind the back of the first compare and swap
+type conflictingStore struct {
+	*MemoryStore
+	conflicts int
+}
+
+func (s *conflictingStore) CompareAndSwap(account, updated *Account) (bool, error) {
+	if s.conflicts > 0 {
+		s.conflicts--
+		concurrent := account.clone()
+		concurrent.consume(UnitTypeMoney, money.New(30, 0), now, false)
+		if _, err := s.MemoryStore.CompareAndSwap(account, concurrent); err != nil {
+			return false, err
+		}
+	}
+	return s.MemoryStore.CompareAndSwap(account, updated)
+}
+
+func TestUpdateConflict(t *testing.T) {
+	store := &conflictingStore{MemoryStore: NewMemoryStore(), conflicts: 1}
+	store.Put(mainAccount(100, false))
+
+	// The debit is checked again against the balance left by the concurrent update
+	_, _, err := Update(store, ueIds, ratingGroup, Debit(Units{Money: money.New(80, 0)}, now))
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+
+	var granted Units
+	store.conflicts = 1
+	previous, updated, err := Update(store, ueIds, ratingGroup, Reserve(Units{Money: money.New(30, 0)}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "40", previous.Balance(UnitTypeMoney, now).String())
+	require.Equal(t, "10", updated.Balance(UnitTypeMoney, now).String())
+	require.Equal(t, "30", granted.Money.String())
+	require.Equal(t, int64(3), updated.Version)
+
+	store.conflicts = maxUpdateAttempts
+	_, _, err = Update(store, ueIds, ratingGroup, Refund(Units{Money: money.New(1, 0)}, now))
+	require.ErrorIs(t, err, ErrConflict)
+}
+
+// Many sessions reserve, report and refund on one account at once: no update is lost and the account
+// is never overdrawn by the reservations
+func TestUpdateConcurrentSessions(t *testing.T) {
+	const (
+		sessions = 32
+		requests = 50
+	)
+	store := NewMemoryStore()
+	store.Put(Account{
+		UeId: ueId,
+		Buckets: []Bucket{
+			{BucketId: 1, Name: "main", UnitType: UnitTypeMoney, Balance: money.FromInt(900)},
+			{BucketId: 2, Name: "bonus", UnitType: UnitTypeMoney, Balance: money.FromInt(100), Priority: -1},
+		},
+	})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reserved, refunded money.Money
+	updates := int64(0)
+	for session := 0; session < sessions; session++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := 0; request < requests; request++ {
+				var granted Units
+				_, updated, err := Update(store, ueIds, ratingGroup, Reserve(Units{Money: money.New(1, 0)}, now, &granted))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if balance := updated.Balance(UnitTypeMoney, now); balance.Sign() < 0 {
+					t.Errorf("account overdrawn: %s", balance)
+				}
+				// Half of the granted units are used, the rest is refunded
+				refund := money.Money{}
+				if !granted.Money.IsZero() {
+					refund = granted.Money.Sub(money.New(5, -1))
+				}
+				if _, _, err = Update(store, ueIds, ratingGroup, Refund(Units{Money: refund}, now)); err != nil {
+					t.Error(err)
+					return
+				}
+
+				mu.Lock()
+				reserved = reserved.Add(granted.Money)
+				refunded = refunded.Add(refund)
+				if !granted.Money.IsZero() {
+					updates++
+				}
+				if !refund.IsZero() {
+					updates++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stored, err := store.Get(ueIds, ratingGroup)
+	require.NoError(t, err)
+	require.Equal(t, "800", reserved.Sub(refunded).String())
+	require.Equal(t, "200", stored.Balance(UnitTypeMoney, now).String())
+	require.Equal(t, updates, stored.Version)
+}
+
+// familyAccount is shared by a parent without sub-limit and a child limited to 20 and 1000 octets,
+// the child is identified by its GPSI
+func familyAccount() Account {
+	return Account{
+		AccountId: "family-1",
+		Members: []Member{
+			{UeId: "imsi-208930000000001"},
+			{
+				UeId:   "msisdn-886912345678",
+				Limits: map[string]money.Money{UnitTypeMoney: money.FromInt(20), UnitTypeOctets: money.FromInt(1000)},
+			},
+		},
+		Buckets: []Bucket{
+			{BucketId: 1, Name: "main", UnitType: UnitTypeMoney, Balance: money.FromInt(100)},
+			{BucketId: 2, Name: "data", UnitType: UnitTypeOctets, Balance: money.FromInt(5000)},
+		},
+	}
+}
+
+func TestSharedAccountMembers(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(familyAccount())
+
+	parent := []string{"imsi-208930000000001"}
+	child := []string{"imsi-208930000000002", "msisdn-886912345678"}
+	stranger := []string{"imsi-208930000000003", "msisdn-886900000000"}
+
+	stored, err := store.Get(child, ratingGroup)
+	require.NoError(t, err)
+	require.Equal(t, "msisdn-886912345678", stored.UeId)
+	require.Equal(t, "100", stored.Balance(UnitTypeMoney, now).String())
+	require.Equal(t, "20", stored.Available(UnitTypeMoney, now).String())
+	stored, err = store.Get(stranger, ratingGroup)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// The own account of a subscriber is drawn from rather than the shared account
+	store.Put(mainAccount(7, false))
+	stored, err = store.Get(parent, ratingGroup)
+	require.NoError(t, err)
+	require.Empty(t, stored.AccountId)
+	require.Equal(t, "7", stored.Available(UnitTypeMoney, now).String())
+
+	// The sub-limit of the child caps its reservations and debits
+	var granted Units
+	_, updated, err := Update(store, child, ratingGroup,
+		Reserve(Units{Money: money.FromInt(15), Octets: 1200}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "15", granted.Money.String())
+	require.Equal(t, uint64(1000), granted.Octets)
+	require.Equal(t, "5", updated.Available(UnitTypeMoney, now).String())
+	require.Equal(t, "0", updated.Available(UnitTypeOctets, now).String())
+
+	_, _, err = Update(store, child, ratingGroup, Debit(Units{Money: money.FromInt(6)}, now))
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+
+	// The usage reported is charged beyond the sub-limit, a refund gives the sub-limit back
+	_, updated, err = Update(store, child, ratingGroup, Charge(Units{Money: money.FromInt(10)}, now))
+	require.NoError(t, err)
+	require.Equal(t, "0", updated.Available(UnitTypeMoney, now).String())
+	require.Equal(t, "25", updated.Members[1].Used[UnitTypeMoney].String())
+	_, updated, err = Update(store, child, ratingGroup, Refund(Units{Money: money.FromInt(8), Octets: 400}, now))
+	require.NoError(t, err)
+	require.Equal(t, "3", updated.Available(UnitTypeMoney, now).String())
+	require.Equal(t, "400", updated.Available(UnitTypeOctets, now).String())
+	require.Equal(t, "83", updated.Balance(UnitTypeMoney, now).String())
+}
+
+func TestSharedAccountFairShare(t *testing.T) {
+	store := NewMemoryStore()
+	shared := familyAccount()
+	shared.Members = append(shared.Members, Member{UeId: "imsi-208930000000004"})
+	store.Put(shared)
+
+	parent := []string{"imsi-208930000000001"}
+	child := []string{"msisdn-886912345678"}
+	sibling := []string{"imsi-208930000000004"}
+
+	// Alone, the parent can reserve the whole balance
+	var granted Units
+	_, _, err := Update(store, parent, ratingGroup, Reserve(Units{Money: money.FromInt(40)}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "40", granted.Money.String())
+
+	// The balance left is shared with the parent reserving meanwhile
+	_, _, err = Update(store, sibling, ratingGroup, Reserve(Units{Money: money.FromInt(50)}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "30", granted.Money.String())
+
+	// Then between three members, rounded down
+	_, _, err = Update(store, child, ratingGroup, Reserve(Units{Money: money.FromInt(15)}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "10", granted.Money.String())
+	_, _, err = Update(store, sibling, ratingGroup, Reserve(Units{Money: money.FromInt(15)}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "6.6666", granted.Money.String())
+
+	// The members who did not reserve recently are not waited for
+	_, _, err = Update(store, sibling, ratingGroup,
+		Reserve(Units{Money: money.FromInt(15)}, now.Add(fairShareWindow), &granted))
+	require.NoError(t, err)
+	require.Equal(t, "13.3334", granted.Money.String())
+}
+
+// Members reserve concurrently from a shared account: its balance and the sub-limit of the child hold
+func TestSharedAccountConcurrentMembers(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(familyAccount())
+
+	members := [][]string{{"imsi-208930000000001"}, {"msisdn-886912345678"}}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := make([]money.Money, len(members))
+	for session := 0; session < 16; session++ {
+		wg.Add(1)
+		go func(member int) {
+			defer wg.Done()
+			for request := 0; request < 20; request++ {
+				var granted Units
+				_, _, err := Update(store, members[member], ratingGroup,
+					Reserve(Units{Money: money.FromInt(1)}, now, &granted))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				reserved[member] = reserved[member].Add(granted.Money)
+				mu.Unlock()
+			}
+		}(session % len(members))
+	}
+	wg.Wait()
+
+	stored, err := store.Get(members[1], ratingGroup)
+	require.NoError(t, err)
+	require.Equal(t, "20", reserved[1].String())
+	require.Equal(t, "20", stored.Members[1].Used[UnitTypeMoney].String())
+	spent := money.FromInt(100).Sub(stored.Balance(UnitTypeMoney, now))
+	require.Equal(t, reserved[0].Add(reserved[1]).String(), spent.String())
+	require.GreaterOrEqual(t, stored.Balance(UnitTypeMoney, now).Sign(), 0)
+}
@@ -0,0 +1,98 @@
+package account
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the accounts in memory, e.g. for tests
+type MemoryStore struct {
+	mu sync.Mutex
+	// Keyed by the SUPI of the subscriber, or by the identifier of a shared account
+	accounts map[string]*Account
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts: make(map[string]*Account),
+	}
+}
+
+// Put creates or replaces the account of the subscriber, or the shared account
+func (s *MemoryStore) Put(account Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[keyOf(&account)] = account.clone()
+}
+
+func (s *MemoryStore) Get(ueIds []string, ratingGroup uint32) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(ueIds) == 0 {
+		return nil, nil
+	}
+	if stored, ok := s.accounts[ueIds[0]]; ok && stored.AccountId == "" {
+		account := stored.clone()
+		account.RatingGroup = ratingGroup
+		return account, nil
+	}
+	for _, stored := range s.accounts {
+		if stored.AccountId == "" {
+			continue
+		}
+		if ueId := memberOf(stored, ueIds); ueId != "" {
+			account := stored.clone()
+			account.UeId = ueId
+			account.RatingGroup = ratingGroup
+			return account, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) CompareAndSwap(account, updated *Account) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.accounts[keyOf(account)]
+	if !ok || stored.Version != account.Version {
+		return false, nil
+	}
+	swapped := updated.clone()
+	stored.Buckets = swapped.Buckets
+	stored.Members = swapped.Members
+	stored.Reservations = swapped.Reservations
+	stored.Version++
+	return true, nil
+}
+
+func keyOf(account *Account) string {
+	if account.AccountId != "" {
+		return account.AccountId
+	}
+	return account.UeId
+}
+
+func (s *MemoryStore) ExpiredReservations(now time.Time, retention time.Duration) ([]Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []Reservation
+	for _, stored := range s.accounts {
+		for _, reservation := range stored.Reservations {
+			if reservation.expiredAt(now, retention) {
+				expired = append(expired, reservation)
+			}
+		}
+	}
+	return expired, nil
+}
+
+func (s *MemoryStore) HasAccount(ueId string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stored := range s.accounts {
+		if stored.UeId == ueId || memberOf(stored, []string{ueId}) != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,420 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/util/mongoapi"
+)
+
+const (
+	// Accounts with buckets, one document per subscriber or per shared account
+	accountsColl = "chf.accounts"
+	// The charging data of a rating group of a subscriber without account holds a single money balance, its quota
+	chargingDatasColl = "policyData.ues.chargingData"
+)
+
+type accountDocument struct {
+	// The account of a subscriber has its ueId, a shared account has an accountId and its members
+	UeId         string           `bson:"ueId,omitempty"`
+	AccountId    string           `bson:"accountId,omitempty"`
+	Members      []memberDocument `bson:"members,omitempty"`
+	CurrencyCode uint32           `bson:"currencyCode"`
+	Barred       bool             `bson:"barred"`
+	Version      int64            `bson:"version"`
+	Buckets      []bucketDocument `bson:"buckets"`
+	// Of the accounts and of the charging data
+	Reservations []reservationDocument `bson:"reservations,omitempty"`
+}
+
+type reservationDocument struct {
+	SessionId   string   `bson:"sessionId"`
+	RatingGroup uint32   `bson:"ratingGroup"`
+	UeIds       []string `bson:"ueIds"`
+	// Exact decimal amount, e.g. "10.5"
+	Money     string    `bson:"money"`
+	Octets    uint64    `bson:"octets"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	Expired   bool      `bson:"expired"`
+}
+
+type memberDocument struct {
+	UeId string `bson:"ueId"`
+	// Exact decimal amounts of each unit type, e.g. {"money": "10.5", "octets": "1000000"}
+	Limits          map[string]string `bson:"limits,omitempty"`
+	Used            map[string]string `bson:"used,omitempty"`
+	LastReservation time.Time         `bson:"lastReservation,omitempty"`
+}
+
+type bucketDocument struct {
+	BucketId uint64 `bson:"bucketId"`
+	Name     string `bson:"name"`
+	UnitType string `bson:"unitType"`
+	// Exact decimal amount, e.g. "10.5"
+	Balance      string    `bson:"balance"`
+	ValidFrom    time.Time `bson:"validFrom,omitempty"`
+	ValidUntil   time.Time `bson:"validUntil,omitempty"`
+	RatingGroups []uint32  `bson:"ratingGroups,omitempty"`
+	Priority     int       `bson:"priority"`
+}
+
+// MongoStore persists the buckets of the accounts as exact decimal strings along with the version of the account
+type MongoStore struct {
+	dbName string
+}
+
+// NewMongoStore stores the accounts in the database of the client connected by mongoapi.SetMongoDB
+func NewMongoStore(dbName string) *MongoStore {
+	return &MongoStore{dbName: dbName}
+}
+
+func (s *MongoStore) Get(ueIds []string, ratingGroup uint32) (*Account, error) {
+	if len(ueIds) == 0 {
+		return nil, nil
+	}
+	queryStrength := 2
+	document, err := mongoapi.RestfulAPIGetOne(accountsColl, bson.M{"ueId": ueIds[0]}, queryStrength)
+	if err != nil {
+		return nil, err
+	}
+	if document == nil {
+		document, err = mongoapi.RestfulAPIGetOne(accountsColl,
+			bson.M{"members.ueId": bson.M{"$in": ueIds}}, queryStrength)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if document != nil {
+		return accountOf(document, ueIds, ratingGroup)
+	}
+	return s.chargingDataAccount(ueIds[0], ratingGroup)
+}
+
+func accountOf(document map[string]interface{}, ueIds []string, ratingGroup uint32) (*Account, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	stored := accountDocument{}
+	if err = bson.Unmarshal(raw, &stored); err != nil {
+		return nil, err
+	}
+
+	account := &Account{
+		UeId:         stored.UeId,
+		AccountId:    stored.AccountId,
+		RatingGroup:  ratingGroup,
+		CurrencyCode: stored.CurrencyCode,
+		Barred:       stored.Barred,
+		Version:      stored.Version,
+	}
+	if account.CurrencyCode == 0 {
+		account.CurrencyCode = money.DefaultCurrencyCode
+	}
+	for _, member := range stored.Members {
+		limits, errLimits := amountsOf(member.Limits)
+		if errLimits != nil {
+			return nil, fmt.Errorf("member %s: invalid limit: %w", member.UeId, errLimits)
+		}
+		used, errUsed := amountsOf(member.Used)
+		if errUsed != nil {
+			return nil, fmt.Errorf("member %s: invalid usage: %w", member.UeId, errUsed)
+		}
+		account.Members = append(account.Members, Member{
+			UeId:            member.UeId,
+			Limits:          limits,
+			Used:            used,
+			LastReservation: member.LastReservation,
+		})
+	}
+	if account.AccountId != "" {
+		account.UeId = memberOf(account, ueIds)
+	}
+	if account.Reservations, err = reservationsOf(stored.Reservations); err != nil {
+		return nil, err
+	}
+	for _, bucket := range stored.Buckets {
+		balance, errBalance := money.Parse(bucket.Balance)
+		if errBalance != nil {
+			return nil, fmt.Errorf("bucket %d: invalid balance: %w", bucket.BucketId, errBalance)
+		}
+		if bucket.UnitType != UnitTypeMoney && bucket.UnitType != UnitTypeOctets {
+			return nil, fmt.Errorf("bucket %d: unknown unit type %q", bucket.BucketId, bucket.UnitType)
+		}
+		account.Buckets = append(account.Buckets, Bucket{
+			BucketId:     bucket.BucketId,
+			Name:         bucket.Name,
+			UnitType:     bucket.UnitType,
+			Balance:      balance,
+			ValidFrom:    bucket.ValidFrom,
+			ValidUntil:   bucket.ValidUntil,
+			RatingGroups: bucket.RatingGroups,
+			Priority:     bucket.Priority,
+		})
+	}
+	return account, nil
+}
+
+// The charging data of the rating group is an account of one money bucket, identified by the rating group
+func (s *MongoStore) chargingDataAccount(ueId string, ratingGroup uint32) (*Account, error) {
+	queryStrength := 2
+	chargingData, err := mongoapi.RestfulAPIGetOne(chargingDatasColl,
+		bson.M{"ueId": ueId, "ratingGroup": ratingGroup}, queryStrength)
+	if err != nil || chargingData == nil {
+		return nil, err
+	}
+	return chargingDataAccountOf(chargingData, ueId, ratingGroup)
+}
+
+// The charging data written before the versioning has no version, which is version 0
+func chargingDataAccountOf(chargingData map[string]interface{}, ueId string, ratingGroup uint32) (*Account, error) {
+	quotaStr, _ := chargingData["quota"].(string)
+	quota, err := money.Parse(quotaStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota: %w", err)
+	}
+	account := &Account{
+		UeId:        ueId,
+		RatingGroup: ratingGroup,
+		Buckets: []Bucket{
+			{BucketId: uint64(ratingGroup), Name: "quota", UnitType: UnitTypeMoney, Balance: quota},
+		},
+		CurrencyCode: uint32(int64Of(chargingData["currencyCode"])),
+		Version:      int64Of(chargingData["version"]),
+		chargingData: true,
+	}
+	if storedUeId, ok := chargingData["ueId"].(string); ok {
+		account.UeId = storedUeId
+	}
+	if account.CurrencyCode == 0 {
+		account.CurrencyCode = money.DefaultCurrencyCode
+	}
+	account.Barred, _ = chargingData["barred"].(bool)
+	if account.Reservations, err = reservationsOfDocument(chargingData); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func reservationsOfDocument(chargingData map[string]interface{}) ([]Reservation, error) {
+	raw, err := bson.Marshal(chargingData)
+	if err != nil {
+		return nil, err
+	}
+	stored := accountDocument{}
+	if err = bson.Unmarshal(raw, &stored); err != nil {
+		return nil, err
+	}
+	return reservationsOf(stored.Reservations)
+}
+
+// ExpiredReservations looks for the reservations to sweep in the accounts and in the charging data
+func (s *MongoStore) ExpiredReservations(now time.Time, retention time.Duration) ([]Reservation, error) {
+	filter := bson.M{"reservations": bson.M{"$elemMatch": bson.M{"$or": bson.A{
+		bson.M{"expired": false, "expiresAt": bson.M{"$lte": now}},
+		bson.M{"expired": true, "expiresAt": bson.M{"$lte": now.Add(-retention)}},
+	}}}}
+	var expired []Reservation
+	for _, collName := range []string{accountsColl, chargingDatasColl} {
+		documents, err := mongoapi.RestfulAPIGetMany(collName, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range documents {
+			reservations, errReservations := reservationsOfDocument(document)
+			if errReservations != nil {
+				return nil, errReservations
+			}
+			for _, reservation := range reservations {
+				if reservation.expiredAt(now, retention) {
+					expired = append(expired, reservation)
+				}
+			}
+		}
+	}
+	return expired, nil
+}
+
+// HasAccount looks for an account of the subscriber or a shared account it is a member of, then for charging data
+// of the subscriber without account
+func (s *MongoStore) HasAccount(ueId string) (bool, error) {
+	filters := map[string]bson.M{
+		accountsColl:      {"$or": bson.A{bson.M{"ueId": ueId}, bson.M{"members.ueId": ueId}}},
+		chargingDatasColl: {"ueId": ueId},
+	}
+	for _, collName := range []string{accountsColl, chargingDatasColl} {
+		document, err := mongoapi.RestfulAPIGetOne(collName, filters[collName])
+		if err != nil {
+			return false, err
+		}
+		if document != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// The buckets and the reservations are only set if the document is still at the version read, the documents
+// created before the versioning have no version, which is version 0
+func (s *MongoStore) CompareAndSwap(account, updated *Account) (bool, error) {
+	collName, filter, set, err := compareAndSwapOf(account, updated)
+	if err != nil {
+		return false, err
+	}
+
+	collection := mongoapi.Client.Database(s.dbName).Collection(collName)
+	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": set})
+	if err != nil {
+		return false, fmt.Errorf("update account err: %+v", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// compareAndSwapOf is the collection of the account, the filter matching it at the version read and the fields
+// it sets
+func compareAndSwapOf(account, updated *Account) (collName string, filter, set bson.M, err error) {
+	collName = accountsColl
+	filter = bson.M{"ueId": account.UeId, "version": account.Version}
+	set = bson.M{
+		"buckets":      bucketDocumentsOf(updated.Buckets),
+		"reservations": reservationDocumentsOf(updated.Reservations),
+		"version":      account.Version + 1,
+	}
+	switch {
+	case account.AccountId != "":
+		filter = bson.M{"accountId": account.AccountId, "version": account.Version}
+		set["members"] = memberDocumentsOf(updated.Members)
+	case account.chargingData:
+		if len(updated.Buckets) != 1 {
+			return "", nil, nil, fmt.Errorf("charging data account of %d buckets", len(updated.Buckets))
+		}
+		collName = chargingDatasColl
+		filter["ratingGroup"] = account.RatingGroup
+		set = bson.M{
+			"quota":        updated.Buckets[0].Balance.String(),
+			"reservations": reservationDocumentsOf(updated.Reservations),
+			"version":      account.Version + 1,
+		}
+	}
+	if account.Version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	return collName, filter, set, nil
+}
+
+func bucketDocumentsOf(buckets []Bucket) []bucketDocument {
+	documents := make([]bucketDocument, 0, len(buckets))
+	for _, bucket := range buckets {
+		documents = append(documents, bucketDocument{
+			BucketId:     bucket.BucketId,
+			Name:         bucket.Name,
+			UnitType:     bucket.UnitType,
+			Balance:      bucket.Balance.String(),
+			ValidFrom:    bucket.ValidFrom,
+			ValidUntil:   bucket.ValidUntil,
+			RatingGroups: bucket.RatingGroups,
+			Priority:     bucket.Priority,
+		})
+	}
+	return documents
+}
+
+func reservationsOf(documents []reservationDocument) ([]Reservation, error) {
+	var reservations []Reservation
+	for _, reservation := range documents {
+		held, err := money.Parse(reservation.Money)
+		if err != nil {
+			return nil, fmt.Errorf("reservation %s: invalid money: %w", reservation.SessionId, err)
+		}
+		reservations = append(reservations, Reservation{
+			SessionId:   reservation.SessionId,
+			RatingGroup: reservation.RatingGroup,
+			UeIds:       reservation.UeIds,
+			Money:       held,
+			Octets:      reservation.Octets,
+			ExpiresAt:   reservation.ExpiresAt,
+			Expired:     reservation.Expired,
+		})
+	}
+	return reservations, nil
+}
+
+func reservationDocumentsOf(reservations []Reservation) []reservationDocument {
+	documents := make([]reservationDocument, 0, len(reservations))
+	for _, reservation := range reservations {
+		documents = append(documents, reservationDocument{
+			SessionId:   reservation.SessionId,
+			RatingGroup: reservation.RatingGroup,
+			UeIds:       reservation.UeIds,
+			Money:       reservation.Money.String(),
+			Octets:      reservation.Octets,
+			ExpiresAt:   reservation.ExpiresAt,
+			Expired:     reservation.Expired,
+		})
+	}
+	return documents
+}
+
+// memberOf is the first identity of the subscriber that is a member of the shared account
+func memberOf(account *Account, ueIds []string) string {
+	for _, ueId := range ueIds {
+		for i := range account.Members {
+			if account.Members[i].UeId == ueId {
+				return ueId
+			}
+		}
+	}
+	return ""
+}
+
+func memberDocumentsOf(members []Member) []memberDocument {
+	documents := make([]memberDocument, 0, len(members))
+	for _, member := range members {
+		documents = append(documents, memberDocument{
+			UeId:            member.UeId,
+			Limits:          amountStringsOf(member.Limits),
+			Used:            amountStringsOf(member.Used),
+			LastReservation: member.LastReservation,
+		})
+	}
+	return documents
+}
+
+func amountsOf(amounts map[string]string) (map[string]money.Money, error) {
+	parsed := make(map[string]money.Money, len(amounts))
+	for unitType, amount := range amounts {
+		value, err := money.Parse(amount)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", unitType, err)
+		}
+		parsed[unitType] = value
+	}
+	return parsed, nil
+}
+
+func amountStringsOf(amounts map[string]money.Money) map[string]string {
+	if len(amounts) == 0 {
+		return nil
+	}
+	strs := make(map[string]string, len(amounts))
+	for unitType, amount := range amounts {
+		strs[unitType] = amount.String()
+	}
+	return strs
+}
+
+func int64Of(value interface{}) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestChargingDataAccountOf(t *testing.T) {
+	// The charging data written before the versioning and the reservation ledger
+	account, err := chargingDataAccountOf(map[string]interface{}{
+		"ueId":        ueId,
+		"ratingGroup": int32(ratingGroup),
+		"quota":       "10.5",
+	}, ueId, ratingGroup)
+	require.NoError(t, err)
+	require.True(t, account.chargingData)
+	require.Equal(t, int64(0), account.Version)
+	require.Equal(t, uint32(money.DefaultCurrencyCode), account.CurrencyCode)
+	require.Empty(t, account.Reservations)
+	require.Equal(t, "10.5", account.Balance(UnitTypeMoney, now).String())
+
+	_, err = chargingDataAccountOf(map[string]interface{}{"quota": "ten"}, ueId, ratingGroup)
+	require.Error(t, err)
+}
+
+func TestCompareAndSwapOf(t *testing.T) {
+	updated := mainAccount(70, false)
+	updated.Reservations = []Reservation{
+		{SessionId: "1;chargingData-1", RatingGroup: ratingGroup, UeIds: ueIds, Money: money.FromInt(30)},
+	}
+	reservations := reservationDocumentsOf(updated.Reservations)
+
+	testCases := []struct {
+		name     string
+		account  Account
+		collName string
+		filter   bson.M
+		set      bson.M
+	}{
+		{
+			name:     "account",
+			account:  Account{UeId: ueId, Version: 3},
+			collName: accountsColl,
+			filter:   bson.M{"ueId": ueId, "version": int64(3)},
+			set: bson.M{
+				"buckets":      bucketDocumentsOf(updated.Buckets),
+				"reservations": reservations,
+				"version":      int64(4),
+			},
+		},
+		{
+			name:     "account before versioning",
+			account:  Account{UeId: ueId},
+			collName: accountsColl,
+			filter:   bson.M{"ueId": ueId, "version": bson.M{"$in": bson.A{0, nil}}},
+			set: bson.M{
+				"buckets":      bucketDocumentsOf(updated.Buckets),
+				"reservations": reservations,
+				"version":      int64(1),
+			},
+		},
+		{
+			name:     "shared account",
+			account:  Account{AccountId: "family-1", UeId: ueId, Version: 2},
+			collName: accountsColl,
+			filter:   bson.M{"accountId": "family-1", "version": int64(2)},
+			set: bson.M{
+				"buckets":      bucketDocumentsOf(updated.Buckets),
+				"members":      memberDocumentsOf(updated.Members),
+				"reservations": reservations,
+				"version":      int64(3),
+			},
+		},
+		{
+			name:     "charging data before versioning",
+			account:  Account{UeId: ueId, RatingGroup: ratingGroup, chargingData: true},
+			collName: chargingDatasColl,
+			filter: bson.M{
+				"ueId": ueId, "ratingGroup": uint32(ratingGroup), "version": bson.M{"$in": bson.A{0, nil}},
+			},
+			set: bson.M{
+				"quota":        "70",
+				"reservations": reservations,
+				"version":      int64(1),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			collName, filter, set, err := compareAndSwapOf(&tc.account, &updated)
+			require.NoError(t, err)
+			require.Equal(t, tc.collName, collName)
+			require.Equal(t, tc.filter, filter)
+			require.Equal(t, tc.set, set)
+		})
+	}
+
+	// The charging data holds a single money balance
+	updated.Buckets = append(updated.Buckets, Bucket{BucketId: 2, UnitType: UnitTypeOctets})
+	_, _, _, err := compareAndSwapOf(&Account{UeId: ueId, chargingData: true}, &updated)
+	require.Error(t, err)
+}
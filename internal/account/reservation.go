@@ -0,0 +1,216 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/free5gc/chf/internal/money"
+)
+
+// Reservation is the part of the balances a charging session holds for a rating group: it is taken from the
+// buckets when reserved and returned to them if the session neither commits nor releases it before it expires
+type Reservation struct {
+	// Diameter Session-Id of the charging session
+	SessionId   string
+	RatingGroup uint32
+	// Identities of the subscriber holding the reservation, its SUPI first
+	UeIds []string
+	// Money held, negative once the session used more than it reserved
+	Money     money.Money
+	Octets    uint64
+	ExpiresAt time.Time
+	// Returned to the buckets by the sweeper, Money and Octets are the units returned. The reservation is kept
+	// until the retention ends so that a late request of the session is still settled.
+	Expired bool
+}
+
+// expiredAt tells if the sweeper has to return the reservation to its account, or to forget it once its
+// retention ended
+func (r *Reservation) expiredAt(now time.Time, retention time.Duration) bool {
+	if r.Expired {
+		return !now.Before(r.ExpiresAt.Add(retention))
+	}
+	return !now.Before(r.ExpiresAt)
+}
+
+// Session identifies the reservation of a charging session for the rating group the account is read for
+type Session struct {
+	Id    string
+	UeIds []string
+	// The reservation expires when the session makes no request for this long
+	TTL time.Duration
+}
+
+// reservation returns the reservation of the session, nil if the session holds none
+func (a *Account) reservation(sessionId string) *Reservation {
+	for i := range a.Reservations {
+		if a.Reservations[i].SessionId == sessionId && a.Reservations[i].RatingGroup == a.RatingGroup {
+			return &a.Reservations[i]
+		}
+	}
+	return nil
+}
+
+func (a *Account) removeReservation(sessionId string) {
+	for i := range a.Reservations {
+		if a.Reservations[i].SessionId == sessionId && a.Reservations[i].RatingGroup == a.RatingGroup {
+			a.Reservations = append(a.Reservations[:i], a.Reservations[i+1:]...)
+			return
+		}
+	}
+}
+
+// Unspent is the money balance of the rating group of the account along with the money its sessions hold for it.
+// Reserving or returning a reservation leaves it unchanged, it only decreases as money is committed or debited.
+func (a *Account) Unspent(now time.Time) money.Money {
+	reserved, _ := a.Reserved()
+	return a.Balance(UnitTypeMoney, now).Add(reserved)
+}
+
+// Reserved is the money the sessions hold for the rating group of the account, and the number of these sessions
+func (a *Account) Reserved() (money.Money, int) {
+	var reserved money.Money
+	var sessions int
+	for _, reservation := range a.Reservations {
+		if reservation.RatingGroup == a.RatingGroup && !reservation.Expired && reservation.Money.Sign() > 0 {
+			reserved = reserved.Add(reservation.Money)
+			sessions++
+		}
+	}
+	return reserved, sessions
+}
+
+// revive takes back the units the sweeper returned for an expired reservation, the session is still using them
+func (a *Account) revive(reservation *Reservation, now time.Time) {
+	if !reservation.Expired {
+		return
+	}
+	if reservation.Money.Sign() > 0 {
+		a.consume(UnitTypeMoney, reservation.Money, now, true)
+		a.draw(UnitTypeMoney, reservation.Money)
+	}
+	octets := a.consume(UnitTypeOctets, money.FromInt(int64(reservation.Octets)), now, false)
+	a.draw(UnitTypeOctets, octets)
+	reservation.Expired = false
+}
+
+// Reserve commits the units the session used since its last reservation and reserves the units requested,
+// the reservation expires TTL after now
+func (s Session) Reserve(used, requested Units, now time.Time, granted *Units) Change {
+	reserve := Reserve(requested, now, granted)
+	return func(account *Account) error {
+		if reservation := account.reservation(s.Id); reservation != nil {
+			account.revive(reservation, now)
+		}
+		if err := reserve(account); err != nil {
+			return err
+		}
+
+		reservation := account.reservation(s.Id)
+		if reservation == nil {
+			account.Reservations = append(account.Reservations, Reservation{
+				SessionId:   s.Id,
+				RatingGroup: account.RatingGroup,
+			})
+			reservation = &account.Reservations[len(account.Reservations)-1]
+		}
+		reservation.UeIds = s.UeIds
+		reservation.Money = reservation.Money.Sub(used.Money).Add(granted.Money)
+		reservation.Octets -= min(used.Octets, reservation.Octets)
+		reservation.Octets += granted.Octets
+		reservation.ExpiresAt = now.Add(s.TTL)
+		if reservation.Money.Sign() <= 0 && reservation.Octets == 0 {
+			// Nothing is left to return to the account
+			account.removeReservation(s.Id)
+		}
+		return nil
+	}
+}
+
+// Commit charges the units the session used beyond its reservation, the money reserved is used up while the
+// octets left are still held until they are released
+func (s Session) Commit(used Units, now time.Time) Change {
+	charge := Charge(used, now)
+	return func(account *Account) error {
+		reservation := account.reservation(s.Id)
+		if reservation != nil {
+			account.revive(reservation, now)
+		}
+		if err := charge(account); err != nil || reservation == nil {
+			return err
+		}
+		reservation.Money = money.Money{}
+		if reservation.Octets == 0 {
+			account.removeReservation(s.Id)
+		}
+		return nil
+	}
+}
+
+// Release refunds the units the session did not use and ends its reservation. At most the units held are
+// refunded, nothing is for a session without reservation: its units were used up or already returned.
+func (s Session) Release(refunded Units, now time.Time) Change {
+	return func(account *Account) error {
+		reservation := account.reservation(s.Id)
+		if reservation == nil {
+			return nil
+		}
+		account.revive(reservation, now)
+		if reservation.Money.Sign() <= 0 {
+			refunded.Money = money.Money{}
+		} else if refunded.Money.Cmp(reservation.Money) > 0 {
+			refunded.Money = reservation.Money
+		}
+		refunded.Octets = min(refunded.Octets, reservation.Octets)
+		account.removeReservation(s.Id)
+		return Refund(refunded, now)(account)
+	}
+}
+
+// Expire returns the units of the reservation of the session to the account once it expired, and forgets it
+// after the retention
+func Expire(sessionId string, now time.Time, retention time.Duration) Change {
+	return func(account *Account) error {
+		reservation := account.reservation(sessionId)
+		if reservation == nil || !reservation.expiredAt(now, retention) {
+			return nil
+		}
+		if reservation.Expired {
+			account.removeReservation(sessionId)
+			return nil
+		}
+
+		refunded := Units{Octets: reservation.Octets}
+		if reservation.Money.Sign() > 0 {
+			refunded.Money = reservation.Money
+		} else {
+			reservation.Money = money.Money{}
+		}
+		reservation.Expired = true
+		return Refund(refunded, now)(account)
+	}
+}
+
+// Sweep returns the expired reservations to their accounts and forgets the returned ones whose retention ended,
+// it returns the reservations it returned
+func Sweep(store Store, now time.Time, retention time.Duration) ([]Reservation, error) {
+	expired, err := store.ExpiredReservations(now, retention)
+	if err != nil {
+		return nil, err
+	}
+	var returned []Reservation
+	var errs []error
+	for _, reservation := range expired {
+		_, _, errExpire := Update(store, reservation.UeIds, reservation.RatingGroup,
+			Expire(reservation.SessionId, now, retention))
+		if errExpire != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", reservation.SessionId, errExpire))
+			continue
+		}
+		if !reservation.Expired {
+			returned = append(returned, reservation)
+		}
+	}
+	return returned, errors.Join(errs...)
+}
@@ -0,0 +1,185 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestSessionReservation(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(mainAccount(100, false))
+	session := Session{Id: "1;chargingData-1", UeIds: ueIds, TTL: time.Hour}
+
+	var granted Units
+	_, updated, err := Update(store, ueIds, ratingGroup, session.Reserve(Units{}, Units{Money: money.FromInt(30)}, now,
+		&granted))
+	require.NoError(t, err)
+	require.Equal(t, "30", granted.Money.String())
+	require.Equal(t, "70", updated.Balance(UnitTypeMoney, now).String())
+	require.Len(t, updated.Reservations, 1)
+	require.Equal(t, "30", updated.Reservations[0].Money.String())
+	require.Equal(t, now.Add(time.Hour), updated.Reservations[0].ExpiresAt)
+
+	// The usage since the last reservation is no longer held and the next request postpones the expiry
+	later := now.Add(10 * time.Minute)
+	_, updated, err = Update(store, ueIds, ratingGroup,
+		session.Reserve(Units{Money: money.FromInt(20)}, Units{Money: money.FromInt(10)}, later, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "60", updated.Balance(UnitTypeMoney, later).String())
+	require.Equal(t, "20", updated.Reservations[0].Money.String())
+	require.Equal(t, later.Add(time.Hour), updated.Reservations[0].ExpiresAt)
+
+	// The reservation of another rating group of the session is held apart
+	_, updated, err = Update(store, ueIds, ratingGroup+1,
+		session.Reserve(Units{}, Units{Money: money.FromInt(5)}, later, &granted))
+	require.NoError(t, err)
+	require.Len(t, updated.Reservations, 2)
+
+	// At most the units held are refunded
+	_, updated, err = Update(store, ueIds, ratingGroup, session.Release(Units{Money: money.FromInt(25)}, later))
+	require.NoError(t, err)
+	require.Equal(t, "75", updated.Balance(UnitTypeMoney, later).String())
+	require.Len(t, updated.Reservations, 1)
+	require.Equal(t, uint32(ratingGroup+1), updated.Reservations[0].RatingGroup)
+
+	// The money reserved is used up by the commit, the usage beyond it is charged
+	_, updated, err = Update(store, ueIds, ratingGroup+1, session.Commit(Units{Money: money.FromInt(2)}, later))
+	require.NoError(t, err)
+	require.Equal(t, "73", updated.Balance(UnitTypeMoney, later).String())
+	require.Empty(t, updated.Reservations)
+
+	// Nothing is refunded to a session without reservation
+	_, updated, err = Update(store, ueIds, ratingGroup, session.Release(Units{Money: money.FromInt(5)}, later))
+	require.NoError(t, err)
+	require.Equal(t, "73", updated.Balance(UnitTypeMoney, later).String())
+}
+
+func TestSessionReservationOctets(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(Account{UeId: ueId, Buckets: []Bucket{
+		{BucketId: 1, Name: "main", UnitType: UnitTypeMoney, Balance: money.FromInt(100)},
+		{BucketId: 2, Name: "data", UnitType: UnitTypeOctets, Balance: money.FromInt(1000)},
+	}})
+	session := Session{Id: "1;chargingData-1", UeIds: ueIds, TTL: time.Hour}
+
+	var granted Units
+	_, _, err := Update(store, ueIds, ratingGroup, session.Reserve(Units{}, Units{Octets: 600}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, uint64(600), granted.Octets)
+
+	// The octets left are still held after the commit until they are released
+	_, updated, err := Update(store, ueIds, ratingGroup, session.Commit(Units{Money: money.FromInt(1)}, now))
+	require.NoError(t, err)
+	require.Len(t, updated.Reservations, 1)
+	require.Equal(t, uint64(600), updated.Reservations[0].Octets)
+	require.True(t, updated.Reservations[0].Money.IsZero())
+
+	_, updated, err = Update(store, ueIds, ratingGroup, session.Release(Units{Octets: 800}, now))
+	require.NoError(t, err)
+	require.Equal(t, "1000", updated.Balance(UnitTypeOctets, now).String())
+	require.Equal(t, "99", updated.Balance(UnitTypeMoney, now).String())
+	require.Empty(t, updated.Reservations)
+}
+
+func TestSweepReservations(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(mainAccount(100, false))
+	abandoned := Session{Id: "1;chargingData-1", UeIds: ueIds, TTL: time.Hour}
+	late := Session{Id: "1;chargingData-2", UeIds: ueIds, TTL: time.Hour}
+
+	var granted Units
+	for _, session := range []Session{abandoned, late} {
+		_, _, err := Update(store, ueIds, ratingGroup,
+			session.Reserve(Units{}, Units{Money: money.FromInt(30)}, now, &granted))
+		require.NoError(t, err)
+	}
+
+	returned, err := Sweep(store, now.Add(30*time.Minute), time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, returned)
+
+	// Both sessions went silent for the TTL, their reservations are returned to the balance
+	expiry := now.Add(time.Hour)
+	returned, err = Sweep(store, expiry, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, returned, 2)
+	stored, err := store.Get(ueIds, ratingGroup)
+	require.NoError(t, err)
+	require.Equal(t, "100", stored.Balance(UnitTypeMoney, expiry).String())
+	require.True(t, stored.Reservations[0].Expired)
+
+	// A late request of a session is settled against its returned reservation: the usage is taken back
+	_, updated, err := Update(store, ueIds, ratingGroup, late.Release(Units{Money: money.FromInt(10)}, expiry))
+	require.NoError(t, err)
+	require.Equal(t, "80", updated.Balance(UnitTypeMoney, expiry).String())
+	require.Len(t, updated.Reservations, 1)
+
+	// The returned reservation is forgotten once its retention ended
+	returned, err = Sweep(store, expiry.Add(30*time.Minute), time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, returned)
+	expired, err := store.ExpiredReservations(expiry.Add(time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	returned, err = Sweep(store, expiry.Add(time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, returned)
+	stored, err = store.Get(ueIds, ratingGroup)
+	require.NoError(t, err)
+	require.Empty(t, stored.Reservations)
+	require.Equal(t, "80", stored.Balance(UnitTypeMoney, expiry).String())
+}
+
+func TestUnspent(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(mainAccount(100, false))
+	session := Session{Id: "1;chargingData-1", UeIds: ueIds, TTL: time.Hour}
+
+	// Reserving only moves money from the balance to the session
+	var granted Units
+	previous, updated, err := Update(store, ueIds, ratingGroup,
+		session.Reserve(Units{}, Units{Money: money.FromInt(30)}, now, &granted))
+	require.NoError(t, err)
+	require.Equal(t, "100", previous.Unspent(now).String())
+	require.Equal(t, "100", updated.Unspent(now).String())
+	reserved, sessions := updated.Reserved()
+	require.Equal(t, "30", reserved.String())
+	require.Equal(t, 1, sessions)
+
+	// The money used is spent, the money left is refunded
+	_, updated, err = Update(store, ueIds, ratingGroup, session.Release(Units{Money: money.FromInt(20)}, now))
+	require.NoError(t, err)
+	require.Equal(t, "90", updated.Unspent(now).String())
+
+	// A reservation returned by the sweeper is not held anymore
+	_, _, err = Update(store, ueIds, ratingGroup, session.Reserve(Units{}, Units{Money: money.FromInt(30)}, now, &granted))
+	require.NoError(t, err)
+	_, err = Sweep(store, now.Add(time.Hour), time.Hour)
+	require.NoError(t, err)
+	stored, err := store.Get(ueIds, ratingGroup)
+	require.NoError(t, err)
+	require.Equal(t, "90", stored.Unspent(now).String())
+	reserved, sessions = stored.Reserved()
+	require.True(t, reserved.IsZero())
+	require.Equal(t, 0, sessions)
+}
+
+func TestHasAccount(t *testing.T) {
+	store := NewMemoryStore()
+	store.Put(mainAccount(100, false))
+	store.Put(familyAccount())
+
+	for ueId, exists := range map[string]bool{
+		ueId:                   true,
+		"msisdn-886912345678":  true,
+		"imsi-208930000000099": false,
+	} {
+		found, err := store.HasAccount(ueId)
+		require.NoError(t, err)
+		require.Equal(t, exists, found, ueId)
+	}
+}
@@ -0,0 +1,212 @@
+package context
+
+import (
+	"time"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+// Quota management of a rating group as indicated by the NF consumer in its usage reports
+type QuotaManagementState int
+
+const (
+	QuotaManagementOnline QuotaManagementState = iota
+	QuotaManagementOffline
+	QuotaManagementSuspended
+)
+
+func (s QuotaManagementState) String() string {
+	switch s {
+	case QuotaManagementOnline:
+		return "online"
+	case QuotaManagementOffline:
+		return "offline"
+	case QuotaManagementSuspended:
+		return "suspended"
+	}
+	return "unknown"
+}
+
+// ChargingSession keeps the quota state of one charging data resource (ChargingDataRef),
+// so that concurrent sessions of the same UE using the same rating group do not share reservations.
+type ChargingSession struct {
+	ChargingDataRef string
+	NotifyUri       string
+	RatingGroups    []int32
+	// Created by Nchf_OfflineOnlyCharging, never rated nor debited
+	OfflineOnly bool
+
+	// ABMF
+	ReservedQuota map[int32]money.Money
+	// Octets granted from the data buckets of the account, the usage they cover is not priced. A rating group
+	// is only present while the account grants it octets.
+	ReservedOctets map[int32]uint64
+	// Quota and octets the reservation of the ABMF holds since the last reservation of the rating group, the
+	// usage since is reported with the next reservation. A rating group is only present while it is held.
+	HeldQuota      map[int32]money.Money
+	HeldOctets     map[int32]uint64
+	UnitCost       map[int32]map[charging_datatype.CCUnitType]money.Money
+	AcctRequestNum map[int32]uint32
+	// Currency of the tariff of each rating group, the reservations are made in it
+	CurrencyCode map[int32]uint32
+
+	// Recent consumption of each rating group, used to size the reservations
+	ConsumptionRate map[int32]float64
+	LastUsageReport map[int32]time.Time
+
+	// Rating
+	RatingType map[int32]charging_datatype.RequestSubType
+	// Tariff applying after the tariff switch, the time of the switch and the time the tariffs were rated at
+	NextUnitCost map[int32]map[charging_datatype.CCUnitType]money.Money
+	TariffSwitch map[int32]time.Time
+	RatingTime   map[int32]time.Time
+	// Counters the rating function prices the rating group with
+	RequestedCounters map[int32][]string
+
+	// Quota management state and the usage reported while quota management was suspended
+	QuotaManagement map[int32]QuotaManagementState
+	SuspendedUsage  map[int32]map[charging_datatype.CCUnitType]uint32
+
+	// Last processed request, its response or rejection is replayed for retransmissions
+	LastInvocationSequenceNumber int32
+	LastResponse                 *models.ChfConvergedChargingChargingDataResponse
+	LastProblem                  *models.ProblemDetails
+	// Time of the last request on the session, idle sessions are aborted
+	LastActivity time.Time
+	// A release failed to settle some rating groups, their usage is buffered and a retried release only settles it
+	Releasing bool
+}
+
+func NewChargingSession(chargingDataRef string) *ChargingSession {
+	return &ChargingSession{
+		ChargingDataRef:   chargingDataRef,
+		ReservedQuota:     make(map[int32]money.Money),
+		ReservedOctets:    make(map[int32]uint64),
+		HeldQuota:         make(map[int32]money.Money),
+		HeldOctets:        make(map[int32]uint64),
+		UnitCost:          make(map[int32]map[charging_datatype.CCUnitType]money.Money),
+		AcctRequestNum:    make(map[int32]uint32),
+		CurrencyCode:      make(map[int32]uint32),
+		ConsumptionRate:   make(map[int32]float64),
+		LastUsageReport:   make(map[int32]time.Time),
+		RatingType:        make(map[int32]charging_datatype.RequestSubType),
+		NextUnitCost:      make(map[int32]map[charging_datatype.CCUnitType]money.Money),
+		TariffSwitch:      make(map[int32]time.Time),
+		RatingTime:        make(map[int32]time.Time),
+		RequestedCounters: make(map[int32][]string),
+		QuotaManagement:   make(map[int32]QuotaManagementState),
+		SuspendedUsage:    make(map[int32]map[charging_datatype.CCUnitType]uint32),
+		LastActivity:      time.Now(),
+	}
+}
+
+func (s *ChargingSession) FindRatingGroup(ratingGroup int32) bool {
+	for _, rg := range s.RatingGroups {
+		if rg == ratingGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Register the rating group to the session; new rating groups start in reserve mode
+func (s *ChargingSession) AddRatingGroup(ratingGroup int32) {
+	if s.FindRatingGroup(ratingGroup) {
+		return
+	}
+	s.RatingGroups = append(s.RatingGroups, ratingGroup)
+	s.RatingType[ratingGroup] = charging_datatype.REQ_SUBTYPE_RESERVE
+}
+
+// Move the rating group to the given quota management state and return the previous one
+func (s *ChargingSession) SetQuotaManagement(ratingGroup int32, state QuotaManagementState) QuotaManagementState {
+	previous := s.QuotaManagement[ratingGroup]
+	s.QuotaManagement[ratingGroup] = state
+	return previous
+}
+
+func (s *ChargingSession) BufferSuspendedUsage(
+	ratingGroup int32, unitType charging_datatype.CCUnitType, units uint32,
+) {
+	if units == 0 {
+		return
+	}
+	if s.SuspendedUsage[ratingGroup] == nil {
+		s.SuspendedUsage[ratingGroup] = make(map[charging_datatype.CCUnitType]uint32)
+	}
+	s.SuspendedUsage[ratingGroup][unitType] += units
+}
+
+// Remove and return the usage buffered for the rating group
+func (s *ChargingSession) TakeSuspendedUsage(ratingGroup int32) map[charging_datatype.CCUnitType]uint32 {
+	usage := s.SuspendedUsage[ratingGroup]
+	delete(s.SuspendedUsage, ratingGroup)
+	return usage
+}
+
+// Currency of the tariff of the rating group, the default currency until the rating group is rated
+func (s *ChargingSession) Currency(ratingGroup int32) uint32 {
+	if currencyCode, ok := s.CurrencyCode[ratingGroup]; ok {
+		return currencyCode
+	}
+	return money.DefaultCurrencyCode
+}
+
+// Forget the tariffs and counters the rating group was rated with
+func (s *ChargingSession) ClearRating(ratingGroup int32) {
+	delete(s.UnitCost, ratingGroup)
+	delete(s.CurrencyCode, ratingGroup)
+	delete(s.NextUnitCost, ratingGroup)
+	delete(s.TariffSwitch, ratingGroup)
+	delete(s.RatingTime, ratingGroup)
+	delete(s.RequestedCounters, ratingGroup)
+}
+
+// Update the consumption rate of the rating group with the quota consumed since its last usage report
+func (s *ChargingSession) UpdateConsumptionRate(ratingGroup int32, consumedQuota money.Money, now time.Time) {
+	last, ok := s.LastUsageReport[ratingGroup]
+	s.LastUsageReport[ratingGroup] = now
+	if !ok {
+		return
+	}
+
+	elapsed := now.Sub(last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := consumedQuota.Float64() / elapsed
+	// Smooth the rate so that a single burst does not size the next reservation
+	if previous, ok := s.ConsumptionRate[ratingGroup]; ok {
+		rate = (previous + rate) / 2
+	}
+	s.ConsumptionRate[ratingGroup] = rate
+}
+
+// Allocate the charging session of the UE, the caller shall hold CULock
+func (ue *ChfUe) NewChargingSession(chargingDataRef string) *ChargingSession {
+	session := NewChargingSession(chargingDataRef)
+	ue.ChargingSessions[chargingDataRef] = session
+	return session
+}
+
+func (ue *ChfUe) ChargingSessionFind(chargingDataRef string) (*ChargingSession, bool) {
+	session, ok := ue.ChargingSessions[chargingDataRef]
+	return session, ok
+}
+
+// Sessions of the UE without request since the deadline, the caller shall hold CULock
+func (ue *ChfUe) IdleChargingSessions(deadline time.Time) []*ChargingSession {
+	var sessions []*ChargingSession
+	for _, session := range ue.ChargingSessions {
+		if session.LastActivity.Before(deadline) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+func (ue *ChfUe) DeleteChargingSession(chargingDataRef string) {
+	delete(ue.ChargingSessions, chargingDataRef)
+}
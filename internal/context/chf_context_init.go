@@ -11,6 +11,9 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/policycounter"
+	"github.com/free5gc/chf/internal/reservation"
+	"github.com/free5gc/chf/internal/tariffcache"
 	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/util/idgenerator"
@@ -29,6 +32,9 @@ func InitChfContext(context *CHFContext) {
 	context.NrfUri = configuration.NrfUri
 	context.NrfCertPem = configuration.NrfCertPem
 	context.UriScheme = models.UriScheme(configuration.Sbi.Scheme)
+	context.ReservationPolicy = reservation.NewPolicy(configuration.ReservationPolicy, configuration.ReserveQuotaRatio)
+	context.PolicyCounters = policycounter.NewEvaluator()
+	context.TariffCache = tariffcache.New()
 	context.RatingSessionIdGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
 	context.AccountSessionIdGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
 	context.RegisterIPv4 = factory.ChfSbiDefaultIPv4 // default localhost
@@ -91,32 +97,48 @@ func InitChfContext(context *CHFContext) {
 	AddNfServices(&context.NfService, config, context)
 }
 
+// Versions of the services in their resource URIs
+var nfServiceApiVersions = map[models.ServiceName]string{
+	models.ServiceName_NCHF_CONVERGEDCHARGING:    "v3",
+	models.ServiceName_NCHF_OFFLINEONLYCHARGING:  "v1",
+	models.ServiceName_NCHF_SPENDINGLIMITCONTROL: "v1",
+}
+
+// Register every service of the service name list to the NRF profile
 func AddNfServices(
 	serviceMap *map[models.ServiceName]models.NrfNfManagementNfService, config *factory.Config, context *CHFContext,
 ) {
-	var nfService models.NrfNfManagementNfService
-	var ipEndPoints []models.IpEndPoint
-	var nfServiceVersions []models.NfServiceVersion
 	services := *serviceMap
 
-	nfService.ServiceInstanceId = context.NfId
-	nfService.ServiceName = models.ServiceName_NCHF_CONVERGEDCHARGING
-	nfService.ApiPrefix = context.Url
-	var ipEndPoint models.IpEndPoint
-	ipEndPoint.Ipv4Address = context.RegisterIPv4
-	ipEndPoint.Port = int32(context.SBIPort)
-	ipEndPoint.Transport = models.NrfNfManagementTransportProtocol_TCP
-	ipEndPoints = append(ipEndPoints, ipEndPoint)
-
-	var nfServiceVersion models.NfServiceVersion
-	nfServiceVersion.ApiFullVersion = config.Info.Version
-	nfServiceVersion.ApiVersionInUri = "v1"
-	nfServiceVersions = append(nfServiceVersions, nfServiceVersion)
-
-	nfService.Scheme = context.UriScheme
-	nfService.NfServiceStatus = models.NfServiceStatus_REGISTERED
-
-	nfService.IpEndPoints = ipEndPoints
-	nfService.Versions = nfServiceVersions
-	services[models.ServiceName_NCHF_CONVERGEDCHARGING] = nfService
+	for index, serviceName := range config.Configuration.ServiceNameList {
+		name := models.ServiceName(serviceName)
+		var nfService models.NrfNfManagementNfService
+		var ipEndPoints []models.IpEndPoint
+		var nfServiceVersions []models.NfServiceVersion
+
+		// The first service keeps the instance ID it was always registered with
+		nfService.ServiceInstanceId = context.NfId
+		if index != 0 {
+			nfService.ServiceInstanceId = context.NfId + "-" + strconv.Itoa(index)
+		}
+		nfService.ServiceName = name
+		nfService.ApiPrefix = context.Url
+		var ipEndPoint models.IpEndPoint
+		ipEndPoint.Ipv4Address = context.RegisterIPv4
+		ipEndPoint.Port = int32(context.SBIPort)
+		ipEndPoint.Transport = models.NrfNfManagementTransportProtocol_TCP
+		ipEndPoints = append(ipEndPoints, ipEndPoint)
+
+		var nfServiceVersion models.NfServiceVersion
+		nfServiceVersion.ApiFullVersion = config.Info.Version
+		nfServiceVersion.ApiVersionInUri = nfServiceApiVersions[name]
+		nfServiceVersions = append(nfServiceVersions, nfServiceVersion)
+
+		nfService.Scheme = context.UriScheme
+		nfService.NfServiceStatus = models.NfServiceStatus_REGISTERED
+
+		nfService.IpEndPoints = ipEndPoints
+		nfService.Versions = nfServiceVersions
+		services[name] = nfService
+	}
 }
@@ -9,6 +9,9 @@ import (
 	"github.com/fiorix/go-diameter/diam/sm"
 
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/policycounter"
+	"github.com/free5gc/chf/internal/reservation"
+	"github.com/free5gc/chf/internal/tariffcache"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/openapi/oauth"
 	"github.com/free5gc/util/idgenerator"
@@ -45,6 +48,14 @@ type CHFContext struct {
 	RatingCfg *sm.Settings
 	AbmfCfg   *sm.Settings
 
+	ReservationPolicy reservation.Policy
+
+	// Nchf_SpendingLimitControl subscriptions keyed by subscription id
+	SpendingLimitSubscriptions sync.Map
+	PolicyCounters             *policycounter.Evaluator
+	// Tariffs answered by the rating function, reused while they are valid
+	TariffCache *tariffcache.Cache
+
 	RatingSessionIdGenerator  *idgenerator.IDGenerator
 	AccountSessionIdGenerator *idgenerator.IDGenerator
 	sync.Mutex
@@ -73,18 +84,31 @@ func (context *CHFContext) NewCHFUe(supi string) (*ChfUe, error) {
 	if ue, ok := context.ChfUeFindBySupi(supi); ok {
 		return ue, nil
 	}
-	if strings.HasPrefix(supi, "imsi-") {
-		ue := ChfUe{}
-		ue.init()
-
-		if supi != "" {
-			context.AddChfUeToUePool(&ue, supi)
-		}
+	ue, err := context.NewTransientChfUe(supi)
+	if err != nil {
+		return nil, err
+	}
+	context.AddChfUeToUePool(ue, supi)
+	return ue, nil
+}
 
-		return &ue, nil
-	} else {
+// NewTransientChfUe is a context of the UE for a single enquiry, e.g. of an UE without charging session. It is not
+// added to the UE pool and shall be released by ReleaseTransientChfUe.
+func (context *CHFContext) NewTransientChfUe(supi string) (*ChfUe, error) {
+	if !strings.HasPrefix(supi, "imsi-") {
 		return nil, fmt.Errorf(" add Ue context fail ")
 	}
+	ue := ChfUe{
+		Supi: supi,
+	}
+	ue.init()
+	return &ue, nil
+}
+
+// ReleaseTransientChfUe frees the session IDs of the transient context of the UE
+func (context *CHFContext) ReleaseTransientChfUe(ue *ChfUe) {
+	context.RatingSessionIdGenerator.FreeID(int64(ue.RateSessionId))
+	context.AccountSessionIdGenerator.FreeID(int64(ue.AcctSessionId))
 }
 
 func (context *CHFContext) ChfUeFindBySupi(supi string) (*ChfUe, bool) {
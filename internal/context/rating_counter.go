@@ -0,0 +1,67 @@
+package context
+
+import (
+	"time"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/ratingcounter"
+)
+
+// Restore the rating counters of the UE, e.g. as persisted or as answered by the ABMF
+func (ue *ChfUe) RestoreRatingCounters(counters []ratingcounter.Counter) {
+	ue.RatingCounters = make(map[string]*ratingcounter.Counter)
+	for i := range counters {
+		counter := counters[i]
+		ue.RatingCounters[counter.CounterId] = &counter
+	}
+	ue.RatingCountersLoaded = true
+}
+
+// Counter AVPs of the requested rating counters, the unknown ones are omitted and count from 0
+func (ue *ChfUe) RatingCounterAvps(counterIds []string, now time.Time) []*charging_datatype.Counter {
+	var avps []*charging_datatype.Counter
+	for _, counterId := range counterIds {
+		if counter, ok := ue.RatingCounters[counterId]; ok {
+			avps = append(avps, counter.Avp(now))
+		}
+	}
+	return avps
+}
+
+// Apply the counter impacts answered by the rating function, they are kept until the ABMF persists them
+func (ue *ChfUe) ApplyCounterImpacts(impacts []*charging_datatype.ImpactOnCounter, now time.Time) {
+	for _, impact := range impacts {
+		if impact.CounterValueChange == 0 {
+			continue
+		}
+		counterId := string(impact.CounterId)
+		counter, ok := ue.RatingCounters[counterId]
+		if !ok {
+			counter = &ratingcounter.Counter{UeId: ue.Supi, CounterId: counterId}
+			ue.RatingCounters[counterId] = counter
+		}
+		counter.ApplyImpact(impact, now)
+
+		merged := false
+		for _, pending := range ue.PendingCounterImpacts {
+			if pending.CounterId == impact.CounterId {
+				pending.CounterValueChange += impact.CounterValueChange
+				pending.CounterValue = impact.CounterValue
+				pending.CounterExpiryDate = impact.CounterExpiryDate
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			pendingImpact := *impact
+			ue.PendingCounterImpacts = append(ue.PendingCounterImpacts, &pendingImpact)
+		}
+	}
+}
+
+// Remove and return the counter impacts not persisted yet
+func (ue *ChfUe) TakeCounterImpacts() []*charging_datatype.ImpactOnCounter {
+	impacts := ue.PendingCounterImpacts
+	ue.PendingCounterImpacts = nil
+	return impacts
+}
@@ -0,0 +1,39 @@
+package context
+
+import (
+	"github.com/free5gc/openapi/models"
+)
+
+// SpendingLimitSubscription is a subscription of a PCF to the policy counter status of a subscriber
+type SpendingLimitSubscription struct {
+	SubscriptionId string                      `bson:"subscriptionId"`
+	Context        models.SpendingLimitContext `bson:"context"`
+}
+
+func (c *CHFContext) AddSpendingLimitSubscription(subscription *SpendingLimitSubscription) {
+	c.SpendingLimitSubscriptions.Store(subscription.SubscriptionId, subscription)
+}
+
+func (c *CHFContext) SpendingLimitSubscriptionFind(subscriptionId string) (*SpendingLimitSubscription, bool) {
+	if value, ok := c.SpendingLimitSubscriptions.Load(subscriptionId); ok {
+		return value.(*SpendingLimitSubscription), true
+	}
+	return nil, false
+}
+
+func (c *CHFContext) DeleteSpendingLimitSubscription(subscriptionId string) {
+	c.SpendingLimitSubscriptions.Delete(subscriptionId)
+}
+
+// Subscriptions to the policy counters of the subscriber
+func (c *CHFContext) SpendingLimitSubscriptionsOf(supi string) []*SpendingLimitSubscription {
+	var subscriptions []*SpendingLimitSubscription
+	c.SpendingLimitSubscriptions.Range(func(key, value interface{}) bool {
+		subscription := value.(*SpendingLimitSubscription)
+		if subscription.Context.Supi == supi {
+			subscriptions = append(subscriptions, subscription)
+		}
+		return true
+	})
+	return subscriptions
+}
@@ -12,51 +12,56 @@ import (
 
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	"github.com/free5gc/chf/cdr/cdrType"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/internal/ratingcounter"
 	"github.com/free5gc/chf/pkg/factory"
 )
 
 type ChfUe struct {
-	Supi         string
-	RatingGroups []int32
+	Supi string
+	// GPSI served to the UE as reported by the SMF, a shared account may identify its members by their GPSI
+	Gpsi string
 
-	QuotaValidityTime    int32
-	VolumeLimit          int32
-	VolumeLimitPDU       int32
-	VolumeThresholdRate  float32
-	NotifyUri            string
-	RecordSequenceNumber int64
+	QuotaValidityTime   int32
+	VolumeLimit         int32
+	VolumeLimitPDU      int32
+	VolumeThresholdRate float32
+	TimeThresholdRate   float32
+	// Reporting thresholds of offline charged rating groups
+	OfflineVolumeThreshold int32
+	OfflineTimeThreshold   int32
+	RecordSequenceNumber   int64
+
+	// Quota state of each charging session, keyed by ChargingDataRef
+	ChargingSessions map[string]*ChargingSession
 
 	// ABMF
-	ReservedQuota  map[int32]int64
-	UnitCost       map[int32]uint32
-	AcctRequestNum map[int32]uint32
-	AbmfClient     *sm.Client
-	AbmfMux        *sm.StateMachine
-	AcctChan       chan *diam.Message
-	AcctSessionId  uint32
+	// Remaining balance of each rating group last answered by the ABMF
+	RemainingBalance map[int32]money.Money
+	// Low balance thresholds from the highest to the lowest, and the number of them crossed by each rating group
+	LowBalanceThresholds []int64
+	LowBalanceLevel      map[int32]int
+	AbmfClient           *sm.Client
+	AbmfMux              *sm.StateMachine
+	AcctChan             chan *diam.Message
+	AcctSessionId        uint32
 
 	// Rating
-	RatingClient  *sm.Client
-	RatingMux     *sm.StateMachine
-	RatingChan    chan *diam.Message
-	RatingType    map[int32]charging_datatype.RequestSubType
-	RateSessionId uint32
-	Records       []*cdrType.CHFRecord
+	// Rating counters of the tiered tariffs, with the counter impacts not persisted by the ABMF yet
+	RatingCounters        map[string]*ratingcounter.Counter
+	RatingCountersLoaded  bool
+	PendingCounterImpacts []*charging_datatype.ImpactOnCounter
+	RatingClient          *sm.Client
+	RatingMux             *sm.StateMachine
+	RatingChan            chan *diam.Message
+	RateSessionId         uint32
+	Records               []*cdrType.CHFRecord
 
 	// lock
 	Cdr    map[string]*cdrType.CHFRecord
 	CULock sync.Mutex
 }
 
-func (ue *ChfUe) FindRatingGroup(ratingGroup int32) bool {
-	for _, rg := range ue.RatingGroups {
-		if rg == ratingGroup {
-			return true
-		}
-	}
-	return false
-}
-
 func (ue *ChfUe) init() {
 	config := factory.ChfConfig
 	ue.Records = []*cdrType.CHFRecord{}
@@ -66,18 +71,21 @@ func (ue *ChfUe) init() {
 	ue.VolumeLimitPDU = config.Configuration.VolumeLimitPDU
 	ue.QuotaValidityTime = config.Configuration.QuotaValidityTime
 	ue.VolumeThresholdRate = config.Configuration.VolumeThresholdRate
-	ue.AcctRequestNum = make(map[int32]uint32)
+	ue.TimeThresholdRate = config.Configuration.TimeThresholdRate
+	ue.OfflineVolumeThreshold, ue.OfflineTimeThreshold = config.Configuration.OfflineThresholds(ue.Supi)
+	ue.ChargingSessions = make(map[string]*ChargingSession)
+	ue.RemainingBalance = make(map[int32]money.Money)
+	ue.LowBalanceThresholds = config.Configuration.LowBalanceThresholds(ue.Supi)
+	ue.LowBalanceLevel = make(map[int32]int)
+	ue.RatingCounters = make(map[string]*ratingcounter.Counter)
 	// This needed to be added if rating server do not locate in the same machine
 	// err := dict.Default.Load(bytes.NewReader([]byte(charging_dict.RateDictionary)))
 	// if err != nil {
 	// 	log.Fatal(err)
 	// }
-	ue.ReservedQuota = make(map[int32]int64)
-	ue.UnitCost = make(map[int32]uint32)
 
 	ue.RatingChan = make(chan *diam.Message)
 	ue.AcctChan = make(chan *diam.Message)
-	ue.RatingType = make(map[int32]charging_datatype.RequestSubType)
 	// Create the state machine (it's a diam.ServeMux) and client.
 	ue.RatingMux = sm.New(chfContext.RatingCfg)
 	ue.RatingClient = &sm.Client{
@@ -110,3 +118,20 @@ func (ue *ChfUe) init() {
 	ue.RateSessionId = GenerateRatingSessionId()
 	ue.AcctSessionId = GenerateAccountSessionId()
 }
+
+// Record the remaining balance of the rating group and return the lowest low balance threshold
+// it newly fell to; a recharge above the thresholds re-arms them
+func (ue *ChfUe) UpdateRemainingBalance(ratingGroup int32, balance money.Money) (int64, bool) {
+	ue.RemainingBalance[ratingGroup] = balance
+
+	level := 0
+	for level < len(ue.LowBalanceThresholds) && balance.Cmp(money.FromInt(ue.LowBalanceThresholds[level])) <= 0 {
+		level++
+	}
+	previous := ue.LowBalanceLevel[ratingGroup]
+	ue.LowBalanceLevel[ratingGroup] = level
+	if level <= previous {
+		return 0, false
+	}
+	return ue.LowBalanceThresholds[level-1], true
+}
@@ -22,6 +22,7 @@ var (
 	ChargingdataPostLog *logrus.Entry
 	NotifyEventLog      *logrus.Entry
 	RechargingLog       *logrus.Entry
+	SpendingLimitLog    *logrus.Entry
 	RatingLog           *logrus.Entry
 	AcctLog             *logrus.Entry
 	CgfLog              *logrus.Entry
@@ -49,6 +50,7 @@ func init() {
 	ChargingdataPostLog = NfLog.WithField(logger_util.FieldCategory, "ChargingPost")
 	NotifyEventLog = NfLog.WithField(logger_util.FieldCategory, "NotifyEvent")
 	RechargingLog = NfLog.WithField(logger_util.FieldCategory, "Recharge")
+	SpendingLimitLog = NfLog.WithField(logger_util.FieldCategory, "SpendingLimit")
 	CgfLog = NfLog.WithField(logger_util.FieldCategory, "CGF")
 	RatingLog = NfLog.WithField(logger_util.FieldCategory, "Rating")
 	AcctLog = NfLog.WithField(logger_util.FieldCategory, "Acct")
@@ -0,0 +1,292 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+)
+
+// ISO 4217 code of the tariffs and accounts not configuring their currency
+const DefaultCurrencyCode = 901
+
+type RoundingMode int
+
+const (
+	// Round to the nearest value, ties to the even digit
+	RoundHalfEven RoundingMode = iota
+	// Round toward negative infinity
+	RoundDown
+	// Round toward positive infinity
+	RoundUp
+)
+
+var bigTen = big.NewInt(10)
+
+// Money is an exact decimal amount Digits x 10^Exponent, the representation of the Unit-Value
+// and Unit-Cost AVPs (RFC 4006 8.8). It is kept normalized, without trailing zeros in Digits,
+// so that equal amounts compare equal with ==. An amount with more significant digits than
+// Digits can hold is rounded half to even.
+type Money struct {
+	Digits   int64
+	Exponent int32
+}
+
+func New(digits int64, exponent int32) Money {
+	return fromBig(big.NewInt(digits), exponent)
+}
+
+func FromInt(value int64) Money {
+	return New(value, 0)
+}
+
+// Parse a decimal amount, e.g. "12", "-3.5" or "0.005"
+func Parse(str string) (Money, error) {
+	str = strings.TrimSpace(str)
+	sign := ""
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		sign, str = str[:1], str[1:]
+	}
+	integer, fraction, _ := strings.Cut(str, ".")
+	if integer == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", sign+str)
+	}
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return Money{}, fmt.Errorf("invalid amount %q", sign+str)
+		}
+	}
+
+	digits, _ := new(big.Int).SetString(integer+fraction, 10)
+	if sign == "-" {
+		digits.Neg(digits)
+	}
+	digits, exponent := stripZeros(digits, -int32(len(fraction)))
+	if !digits.IsInt64() {
+		return Money{}, fmt.Errorf("amount %q has too many significant digits", sign+str)
+	}
+	return Money{Digits: digits.Int64(), Exponent: exponent}, nil
+}
+
+// FromFloat converts an estimate, e.g. a consumption rate, to the shortest decimal representing it
+func FromFloat(value float64) Money {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Money{}
+	}
+	m, err := Parse(strconv.FormatFloat(value, 'f', -1, 64))
+	if err != nil {
+		return Money{}
+	}
+	return m
+}
+
+// String formats the amount exactly, without exponent
+func (m Money) String() string {
+	if m.Exponent >= 0 {
+		return new(big.Int).Mul(big.NewInt(m.Digits), pow10(m.Exponent)).String()
+	}
+
+	digits := strconv.FormatInt(m.Digits, 10)
+	sign := ""
+	if m.Digits < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	scale := int(-m.Exponent)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+func (m Money) Sign() int {
+	switch {
+	case m.Digits < 0:
+		return -1
+	case m.Digits > 0:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Digits == 0
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than o
+func (m Money) Cmp(o Money) int {
+	x, y, _ := align(m, o)
+	return x.Cmp(y)
+}
+
+func (m Money) Add(o Money) Money {
+	x, y, exponent := align(m, o)
+	return fromBig(x.Add(x, y), exponent)
+}
+
+func (m Money) Sub(o Money) Money {
+	x, y, exponent := align(m, o)
+	return fromBig(x.Sub(x, y), exponent)
+}
+
+func (m Money) Neg() Money {
+	return fromBig(new(big.Int).Neg(big.NewInt(m.Digits)), m.Exponent)
+}
+
+// Mul is the price of the units at the unit cost m
+func (m Money) Mul(units uint64) Money {
+	digits := new(big.Int).SetUint64(units)
+	return fromBig(digits.Mul(digits, big.NewInt(m.Digits)), m.Exponent)
+}
+
+// Times multiplies two decimal amounts, e.g. a balance by a ratio
+func (m Money) Times(o Money) Money {
+	digits := new(big.Int).Mul(big.NewInt(m.Digits), big.NewInt(o.Digits))
+	return fromBig(digits, m.Exponent+o.Exponent)
+}
+
+// Units is the number of whole units the amount pays for at the unit cost, rounded down.
+// A non-positive amount pays for nothing, the caller handles units free of charge.
+func (m Money) Units(unitCost Money) uint64 {
+	if m.Sign() <= 0 || unitCost.Sign() <= 0 {
+		return 0
+	}
+	x, y, _ := align(m, unitCost)
+	units := x.Quo(x, y)
+	if !units.IsUint64() {
+		return math.MaxUint64
+	}
+	return units.Uint64()
+}
+
+// Round the amount to a multiple of 10^exponent, e.g. to the minor unit of the currency with exponent -2
+func (m Money) Round(exponent int32, mode RoundingMode) Money {
+	if m.Exponent >= exponent {
+		return m
+	}
+	return fromBig(roundBig(big.NewInt(m.Digits), pow10(exponent-m.Exponent), mode), exponent)
+}
+
+// Int64 is the integer part of the amount, truncated toward zero
+func (m Money) Int64() int64 {
+	if m.Exponent >= 0 {
+		return new(big.Int).Mul(big.NewInt(m.Digits), pow10(m.Exponent)).Int64()
+	}
+	return new(big.Int).Quo(big.NewInt(m.Digits), pow10(-m.Exponent)).Int64()
+}
+
+// Float64 approximates the amount, e.g. to compute a consumption rate
+func (m Money) Float64() float64 {
+	value, _ := strconv.ParseFloat(m.String(), 64)
+	return value
+}
+
+func FromUnitValue(unitValue *charging_datatype.UnitValue) Money {
+	if unitValue == nil {
+		return Money{}
+	}
+	return New(int64(unitValue.ValueDigits), int32(unitValue.Exponent))
+}
+
+func (m Money) UnitValue() *charging_datatype.UnitValue {
+	return &charging_datatype.UnitValue{
+		ValueDigits: diam_datatype.Integer64(m.Digits),
+		Exponent:    diam_datatype.Integer32(m.Exponent),
+	}
+}
+
+func FromUnitCost(unitCost *charging_datatype.UnitCost) Money {
+	if unitCost == nil {
+		return Money{}
+	}
+	return New(int64(unitCost.ValueDigits), int32(unitCost.Exponent))
+}
+
+func (m Money) UnitCost() *charging_datatype.UnitCost {
+	return &charging_datatype.UnitCost{
+		ValueDigits: diam_datatype.Integer64(m.Digits),
+		Exponent:    diam_datatype.Integer32(m.Exponent),
+	}
+}
+
+// FromCCMoney returns the amount and the currency of a CC-Money AVP, the default currency if not set
+func FromCCMoney(ccMoney *charging_datatype.CCMoney) (Money, uint32) {
+	if ccMoney == nil {
+		return Money{}, DefaultCurrencyCode
+	}
+	currencyCode := uint32(ccMoney.CurrencyCode)
+	if currencyCode == 0 {
+		currencyCode = DefaultCurrencyCode
+	}
+	return FromUnitValue(ccMoney.UnitValue), currencyCode
+}
+
+func (m Money) CCMoney(currencyCode uint32) *charging_datatype.CCMoney {
+	return &charging_datatype.CCMoney{
+		CurrencyCode: diam_datatype.Unsigned32(currencyCode),
+		UnitValue:    m.UnitValue(),
+	}
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// align returns the digits of both amounts scaled to their common exponent
+func align(a, b Money) (*big.Int, *big.Int, int32) {
+	exponent := min(a.Exponent, b.Exponent)
+	x := new(big.Int).Mul(big.NewInt(a.Digits), pow10(a.Exponent-exponent))
+	y := new(big.Int).Mul(big.NewInt(b.Digits), pow10(b.Exponent-exponent))
+	return x, y, exponent
+}
+
+func stripZeros(digits *big.Int, exponent int32) (*big.Int, int32) {
+	if digits.Sign() == 0 {
+		return digits, 0
+	}
+	quotient, remainder := new(big.Int), new(big.Int)
+	for {
+		quotient.QuoRem(digits, bigTen, remainder)
+		if remainder.Sign() != 0 {
+			return digits, exponent
+		}
+		digits, quotient = quotient, digits
+		exponent++
+	}
+}
+
+func fromBig(digits *big.Int, exponent int32) Money {
+	digits, exponent = stripZeros(digits, exponent)
+	for !digits.IsInt64() {
+		digits = roundBig(digits, bigTen, RoundHalfEven)
+		digits, exponent = stripZeros(digits, exponent+1)
+	}
+	return Money{Digits: digits.Int64(), Exponent: exponent}
+}
+
+// roundBig divides the digits by the divisor, a power of ten, rounding the quotient with the mode
+func roundBig(digits, divisor *big.Int, mode RoundingMode) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(digits, divisor, new(big.Int))
+	if remainder.Sign() == 0 {
+		return quotient
+	}
+
+	away := false
+	switch mode {
+	case RoundDown:
+		away = remainder.Sign() < 0
+	case RoundUp:
+		away = remainder.Sign() > 0
+	default:
+		half := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(divisor)
+		away = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+	}
+	if away {
+		quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+	}
+	return quotient
+}
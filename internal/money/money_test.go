@@ -0,0 +1,91 @@
+package money
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		str   string
+		money Money
+		err   bool
+	}{
+		{str: "12", money: Money{Digits: 12}},
+		{str: "1200", money: Money{Digits: 12, Exponent: 2}},
+		{str: "0.005", money: Money{Digits: 5, Exponent: -3}},
+		{str: "-3.50", money: Money{Digits: -35, Exponent: -1}},
+		{str: "0", money: Money{}},
+		{str: ".5", money: Money{Digits: 5, Exponent: -1}},
+		{str: "", err: true},
+		{str: "1,5", err: true},
+		{str: "1e3", err: true},
+		{str: "123456789012345678901", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.str, func(t *testing.T) {
+			money, err := Parse(tc.str)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.money, money)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	require.Equal(t, "0.005", New(5, -3).String())
+	require.Equal(t, "-0.35", New(-35, -2).String())
+	require.Equal(t, "1200", New(12, 2).String())
+	require.Equal(t, "12.5", New(1250, -2).String())
+	require.Equal(t, "0", Money{}.String())
+}
+
+func TestArithmetic(t *testing.T) {
+	unitCost := New(5, -3)
+
+	// 0.005 per octet is exact whatever the number of octets
+	require.Equal(t, New(5, 0), unitCost.Mul(1000))
+	require.Equal(t, New(3005, -3), New(3, 0).Add(unitCost))
+	require.Equal(t, New(-2995, -3), unitCost.Sub(New(3, 0)))
+	require.Equal(t, New(15, -1), New(15, 0).Times(New(10, -2)))
+	require.Equal(t, uint64(600), New(3, 0).Units(unitCost))
+	require.Equal(t, uint64(0), New(3, 0).Units(Money{}))
+	require.Equal(t, 1, New(1, -2).Cmp(unitCost))
+	require.Equal(t, 0, New(10, -1).Cmp(FromInt(1)))
+	require.Equal(t, int64(-3), New(-3005, -3).Int64())
+	require.Equal(t, int64(1200), New(12, 2).Int64())
+
+	// Digits beyond the capacity are rounded instead of overflowing
+	large := FromInt(math.MaxInt64).Mul(3)
+	require.Equal(t, New(2767011611056432742, 1), large)
+}
+
+func TestRound(t *testing.T) {
+	testCases := []struct {
+		name    string
+		money   Money
+		mode    RoundingMode
+		rounded Money
+	}{
+		{name: "half even down", money: New(125, -3), mode: RoundHalfEven, rounded: New(12, -2)},
+		{name: "half even up", money: New(135, -3), mode: RoundHalfEven, rounded: New(14, -2)},
+		{name: "half even above half", money: New(1251, -4), mode: RoundHalfEven, rounded: New(13, -2)},
+		{name: "half even negative", money: New(-135, -3), mode: RoundHalfEven, rounded: New(-14, -2)},
+		{name: "down", money: New(129, -3), mode: RoundDown, rounded: New(12, -2)},
+		{name: "down negative", money: New(-121, -3), mode: RoundDown, rounded: New(-13, -2)},
+		{name: "up", money: New(121, -3), mode: RoundUp, rounded: New(13, -2)},
+		{name: "already exact", money: New(12, -2), mode: RoundUp, rounded: New(12, -2)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.rounded, tc.money.Round(-2, tc.mode))
+		})
+	}
+}
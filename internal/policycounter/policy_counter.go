@@ -0,0 +1,226 @@
+package policycounter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+const (
+	TypeVolume = "volume"
+	TypeSpend  = "spend"
+
+	// Status of a policy counter without configured initial status
+	DefaultStatus = "valid"
+)
+
+// Counter is the value of a policy counter of a subscriber within the current billing cycle
+type Counter struct {
+	Supi            string    `bson:"supi"`
+	PolicyCounterId string    `bson:"policyCounterId"`
+	Value           int64     `bson:"value"`
+	CycleStart      time.Time `bson:"cycleStart"`
+	Status          string    `bson:"status"`
+}
+
+// Evaluator accumulates the usage and the spend of the subscribers into their policy counters
+type Evaluator struct {
+	mu       sync.Mutex
+	counters map[string]map[string]*Counter
+
+	// Called with each updated counter, e.g. to persist it
+	Updated func(counter Counter)
+	// Called with the policy counters whose status changed
+	StatusChanged func(supi string, infos []models.PolicyCounterInfo)
+	// Called when the subscriber is removed
+	Removed func(supi string)
+}
+
+func NewEvaluator() *Evaluator {
+	return &Evaluator{
+		counters: make(map[string]map[string]*Counter),
+	}
+}
+
+// Restore the counters persisted before restart
+func (e *Evaluator) Restore(counters []Counter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range counters {
+		counter := counters[i]
+		if e.counters[counter.Supi] == nil {
+			e.counters[counter.Supi] = make(map[string]*Counter)
+		}
+		e.counters[counter.Supi][counter.PolicyCounterId] = &counter
+	}
+}
+
+// AddUsage accumulates the amount counted on the rating group into the policy counters of that type
+// and evaluates their status; the amount is negative for refunds
+func (e *Evaluator) AddUsage(supi, counterType string, ratingGroup int32, amount int64, now time.Time) {
+	if amount == 0 || factory.ChfConfig == nil {
+		return
+	}
+	definitions := factory.ChfConfig.Configuration.PolicyCounters(supi)
+
+	var updated []Counter
+	var changed []models.PolicyCounterInfo
+	e.mu.Lock()
+	for _, definition := range definitions {
+		if typeOf(definition) != counterType || !countsRatingGroup(definition, ratingGroup) {
+			continue
+		}
+
+		counter := e.counterOf(supi, definition, now)
+		counter.Value += amount
+		if counter.Value < 0 {
+			counter.Value = 0
+		}
+		status := StatusOf(definition, counter.Value)
+		if status != counter.Status {
+			counter.Status = status
+			changed = append(changed, infoOf(definition, counter))
+		}
+		updated = append(updated, *counter)
+	}
+	e.mu.Unlock()
+
+	if e.Updated != nil {
+		for _, counter := range updated {
+			e.Updated(counter)
+		}
+	}
+	if len(changed) != 0 && e.StatusChanged != nil {
+		e.StatusChanged(supi, changed)
+	}
+}
+
+// Info reports the current status of the policy counter of the subscriber and its pending status
+func (e *Evaluator) Info(supi string, definition *factory.PolicyCounter, now time.Time) models.PolicyCounterInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return infoOf(definition, e.counterOf(supi, definition, now))
+}
+
+// Subscribers are the subscribers with policy counters
+func (e *Evaluator) Subscribers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	supis := make([]string, 0, len(e.counters))
+	for supi := range e.counters {
+		supis = append(supis, supi)
+	}
+	sort.Strings(supis)
+	return supis
+}
+
+// RemoveSubscriber drops the policy counters of a subscriber whose account is removed
+func (e *Evaluator) RemoveSubscriber(supi string) {
+	e.mu.Lock()
+	delete(e.counters, supi)
+	e.mu.Unlock()
+
+	if e.Removed != nil {
+		e.Removed(supi)
+	}
+}
+
+// Counter of the current billing cycle, a counter of a previous cycle starts over; the caller shall hold mu
+func (e *Evaluator) counterOf(supi string, definition *factory.PolicyCounter, now time.Time) *Counter {
+	if e.counters[supi] == nil {
+		e.counters[supi] = make(map[string]*Counter)
+	}
+
+	cycleStart := CycleStart(definition.BillingCycleDay, now)
+	counter, ok := e.counters[supi][definition.PolicyCounterId]
+	if !ok || counter.CycleStart.Before(cycleStart) {
+		counter = &Counter{
+			Supi:            supi,
+			PolicyCounterId: definition.PolicyCounterId,
+			CycleStart:      cycleStart,
+			Status:          initialStatusOf(definition),
+		}
+		e.counters[supi][definition.PolicyCounterId] = counter
+	}
+	return counter
+}
+
+// StatusOf is the status of the highest threshold the value reached, or the initial status below every threshold
+func StatusOf(definition *factory.PolicyCounter, value int64) string {
+	thresholds := make([]*factory.PolicyCounterThreshold, len(definition.Thresholds))
+	copy(thresholds, definition.Thresholds)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Value < thresholds[j].Value })
+
+	status := initialStatusOf(definition)
+	for _, threshold := range thresholds {
+		if value < threshold.Value {
+			break
+		}
+		status = threshold.Status
+	}
+	return status
+}
+
+// CycleStart is the start of the billing cycle containing t
+func CycleStart(billingCycleDay int, t time.Time) time.Time {
+	if billingCycleDay < 1 || billingCycleDay > 28 {
+		billingCycleDay = 1
+	}
+	start := time.Date(t.Year(), t.Month(), billingCycleDay, 0, 0, 0, 0, t.Location())
+	if t.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// The counter starts over with the initial status at the next billing cycle, which is reported as pending status
+func infoOf(definition *factory.PolicyCounter, counter *Counter) models.PolicyCounterInfo {
+	info := models.PolicyCounterInfo{
+		PolicyCounterId: counter.PolicyCounterId,
+		CurrentStatus:   counter.Status,
+	}
+
+	initialStatus := initialStatusOf(definition)
+	if counter.Status != initialStatus {
+		activationTime := counter.CycleStart.AddDate(0, 1, 0)
+		info.PenPolCounterStatuses = []models.PendingPolicyCounterStatus{
+			{
+				PolicyCounterStatus: initialStatus,
+				ActivationTime:      &activationTime,
+			},
+		}
+	}
+	return info
+}
+
+func initialStatusOf(definition *factory.PolicyCounter) string {
+	if definition.InitialStatus == "" {
+		return DefaultStatus
+	}
+	return definition.InitialStatus
+}
+
+func typeOf(definition *factory.PolicyCounter) string {
+	if definition.Type == "" {
+		return TypeVolume
+	}
+	return definition.Type
+}
+
+func countsRatingGroup(definition *factory.PolicyCounter, ratingGroup int32) bool {
+	if len(definition.RatingGroups) == 0 {
+		return true
+	}
+	for _, rg := range definition.RatingGroups {
+		if rg == ratingGroup {
+			return true
+		}
+	}
+	return false
+}
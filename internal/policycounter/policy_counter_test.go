@@ -0,0 +1,162 @@
+package policycounter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestCycleStart(t *testing.T) {
+	testCases := []struct {
+		name            string
+		billingCycleDay int
+		now             time.Time
+		cycleStart      time.Time
+	}{
+		{
+			name:       "default billing cycle day",
+			now:        time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC),
+			cycleStart: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "after billing cycle day",
+			billingCycleDay: 10,
+			now:             time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC),
+			cycleStart:      time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:            "before billing cycle day",
+			billingCycleDay: 20,
+			now:             time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC),
+			cycleStart:      time.Date(2025, 12, 20, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.cycleStart, CycleStart(tc.billingCycleDay, tc.now))
+		})
+	}
+}
+
+func TestAddUsage(t *testing.T) {
+	const supi = "imsi-208930000000001"
+	factory.ChfConfig = &factory.Config{
+		Configuration: &factory.Configuration{
+			SpendingLimitControl: &factory.SpendingLimitControl{
+				PolicyCounters: []*factory.PolicyCounter{
+					{
+						PolicyCounterId: "monthlySpend",
+						Type:            TypeSpend,
+						InitialStatus:   "normal",
+						Thresholds: []*factory.PolicyCounterThreshold{
+							{Value: 1000, Status: "blocked"},
+							{Value: 500, Status: "throttled"},
+						},
+					},
+					{
+						PolicyCounterId: "videoVolume",
+						RatingGroups:    []int32{2},
+						Thresholds: []*factory.PolicyCounterThreshold{
+							{Value: 100, Status: "exhausted"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	evaluator := NewEvaluator()
+	var changed []models.PolicyCounterInfo
+	evaluator.StatusChanged = func(_ string, infos []models.PolicyCounterInfo) {
+		changed = append(changed, infos...)
+	}
+
+	now := time.Date(2026, 3, 15, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name        string
+		counterType string
+		ratingGroup int32
+		amount      int64
+		now         time.Time
+		changed     map[string]string
+	}{
+		{
+			name:        "below thresholds",
+			counterType: TypeSpend,
+			ratingGroup: 1,
+			amount:      300,
+			now:         now,
+		},
+		{
+			name:        "first threshold reached",
+			counterType: TypeSpend,
+			ratingGroup: 1,
+			amount:      300,
+			now:         now,
+			changed:     map[string]string{"monthlySpend": "throttled"},
+		},
+		{
+			name:        "refunded below threshold",
+			counterType: TypeSpend,
+			ratingGroup: 1,
+			amount:      -200,
+			now:         now,
+			changed:     map[string]string{"monthlySpend": "normal"},
+		},
+		{
+			name:        "highest threshold reached",
+			counterType: TypeSpend,
+			ratingGroup: 1,
+			amount:      700,
+			now:         now,
+			changed:     map[string]string{"monthlySpend": "blocked"},
+		},
+		{
+			name:        "volume of another rating group",
+			counterType: TypeVolume,
+			ratingGroup: 1,
+			amount:      500,
+			now:         now,
+		},
+		{
+			name:        "volume of the counted rating group",
+			counterType: TypeVolume,
+			ratingGroup: 2,
+			amount:      500,
+			now:         now,
+			changed:     map[string]string{"videoVolume": "exhausted"},
+		},
+		{
+			name:        "next billing cycle",
+			counterType: TypeSpend,
+			ratingGroup: 1,
+			amount:      100,
+			now:         now.AddDate(0, 1, 0),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changed = nil
+			evaluator.AddUsage(supi, tc.counterType, tc.ratingGroup, tc.amount, tc.now)
+
+			require.Len(t, changed, len(tc.changed))
+			for _, info := range changed {
+				require.Equal(t, tc.changed[info.PolicyCounterId], info.CurrentStatus)
+			}
+		})
+	}
+
+	// A counter out of its initial status returns to it at the next billing cycle
+	definition := factory.ChfConfig.Configuration.SpendingLimitControl.PolicyCounters[1]
+	info := evaluator.Info(supi, definition, now)
+	require.Equal(t, "exhausted", info.CurrentStatus)
+	require.Len(t, info.PenPolCounterStatuses, 1)
+	require.Equal(t, DefaultStatus, info.PenPolCounterStatuses[0].PolicyCounterStatus)
+	require.Equal(t, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), *info.PenPolCounterStatuses[0].ActivationTime)
+}
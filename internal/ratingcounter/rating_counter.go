@@ -0,0 +1,134 @@
+package ratingcounter
+
+import (
+	"time"
+
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+	"go.mongodb.org/mongo-driver/bson"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/util/mongoapi"
+)
+
+// Rating counters are persisted by the ABMF, one document per counter of a subscriber
+const ratingCountersColl = "chf.ratingCounters"
+
+// Counter accumulates the units of a subscriber the rating function prices tiered tariffs and bundles with
+type Counter struct {
+	UeId      string `bson:"ueId"`
+	CounterId string `bson:"counterId"`
+	Value     int64  `bson:"value"`
+	// The counter starts over from 0 at its expiry date, e.g. at the end of the billing cycle
+	ExpiryDate time.Time `bson:"expiryDate"`
+}
+
+// ValueAt is the value of the counter at now, 0 once the counter expired
+func (c *Counter) ValueAt(now time.Time) int64 {
+	if !c.ExpiryDate.IsZero() && !now.Before(c.ExpiryDate) {
+		return 0
+	}
+	return c.Value
+}
+
+// Apply the change of a counter impact, the expiry date of the impact replaces the one of the counter
+func (c *Counter) Apply(change int64, expiryDate, now time.Time) {
+	c.Value = c.ValueAt(now) + change
+	if c.Value < 0 {
+		c.Value = 0
+	}
+	c.ExpiryDate = expiryDate
+}
+
+// ApplyImpact applies the counter impact answered by the rating function
+func (c *Counter) ApplyImpact(impact *charging_datatype.ImpactOnCounter, now time.Time) {
+	c.Apply(int64(impact.CounterValueChange), expiryDateOf(impact.CounterExpiryDate), now)
+}
+
+// Avp is the Counter AVP of the counter at now
+func (c *Counter) Avp(now time.Time) *charging_datatype.Counter {
+	counter := &charging_datatype.Counter{
+		CounterId:    diam_datatype.UTF8String(c.CounterId),
+		CounterValue: diam_datatype.Unsigned64(c.ValueAt(now)),
+	}
+	if !c.ExpiryDate.IsZero() {
+		expiryDate := diam_datatype.Time(c.ExpiryDate)
+		counter.CounterExpiryDate = &expiryDate
+	}
+	return counter
+}
+
+// FromAvp is the counter of the subscriber carried by a Counter AVP
+func FromAvp(ueId string, counter *charging_datatype.Counter) Counter {
+	return Counter{
+		UeId:       ueId,
+		CounterId:  string(counter.CounterId),
+		Value:      int64(counter.CounterValue),
+		ExpiryDate: expiryDateOf(counter.CounterExpiryDate),
+	}
+}
+
+func expiryDateOf(expiryDate *diam_datatype.Time) time.Time {
+	if expiryDate == nil {
+		return time.Time{}
+	}
+	return time.Time(*expiryDate)
+}
+
+// ApplyImpacts persists the counter impacts on the counters of the subscriber and returns all of them
+func ApplyImpacts(ueId string, impacts []*charging_datatype.ImpactOnCounter, now time.Time) ([]Counter, error) {
+	counters, err := Load(ueId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, impact := range impacts {
+		i := 0
+		for i < len(counters) && counters[i].CounterId != string(impact.CounterId) {
+			i++
+		}
+		if i == len(counters) {
+			counters = append(counters, Counter{UeId: ueId, CounterId: string(impact.CounterId)})
+		}
+		counters[i].ApplyImpact(impact, now)
+		if err = Store(counters[i]); err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+// Load the counters of the subscriber
+func Load(ueId string) ([]Counter, error) {
+	stored, err := mongoapi.RestfulAPIGetMany(ratingCountersColl, bson.M{"ueId": ueId})
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make([]Counter, 0, len(stored))
+	for _, data := range stored {
+		var counter Counter
+		raw, errMarshal := bson.Marshal(data)
+		if errMarshal != nil {
+			return nil, errMarshal
+		}
+		if errUnmarshal := bson.Unmarshal(raw, &counter); errUnmarshal != nil {
+			return nil, errUnmarshal
+		}
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
+
+func Store(counter Counter) error {
+	raw, err := bson.Marshal(counter)
+	if err != nil {
+		return err
+	}
+	data := make(bson.M)
+	if err = bson.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	filter := bson.M{"ueId": counter.UeId, "counterId": counter.CounterId}
+	_, err = mongoapi.RestfulAPIPutOne(ratingCountersColl, filter, data)
+	return err
+}
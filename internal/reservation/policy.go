@@ -0,0 +1,145 @@
+package reservation
+
+import (
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/pkg/factory"
+)
+
+const (
+	PolicyRequested    = "requested"
+	PolicyFixed        = "fixed"
+	PolicyBalanceRatio = "balanceRatio"
+	PolicyAdaptive     = "adaptive"
+
+	// Seconds of usage reserved by the adaptive policy if not configured
+	DefaultAdaptiveInterval = 60
+
+	// The quotas estimated from the consumption rate are rounded to the millionth
+	estimateExponent = -6
+)
+
+// Request describes the rating group a quota is reserved for, quotas are in the currency of its tariff
+type Request struct {
+	RatingGroup int32
+	// Price of the units requested by the NF consumer
+	RequestedQuota money.Money
+	// Remaining balance of the account answered by the ABMF, negative if unknown
+	Balance money.Money
+	// Quota consumed per second by the session recently, 0 if unknown
+	ConsumptionRate float64
+}
+
+// Policy decides how much quota is reserved from the account at once
+type Policy interface {
+	ReserveQuota(req Request) money.Money
+}
+
+// NewPolicy builds the policy of the configuration, reserving the requested quota by default
+func NewPolicy(cfg *factory.ReservationPolicy, reserveQuotaRatio int32) Policy {
+	if cfg == nil {
+		return RequestedPolicy{}
+	}
+
+	var policy Policy
+	switch cfg.Type {
+	case PolicyFixed:
+		policy = FixedPolicy{Quota: cfg.Quota()}
+	case PolicyBalanceRatio:
+		policy = BalanceRatioPolicy{Ratio: reserveQuotaRatio}
+	case PolicyAdaptive:
+		interval := cfg.AdaptiveInterval
+		if interval <= 0 {
+			interval = DefaultAdaptiveInterval
+		}
+		policy = AdaptivePolicy{Interval: interval}
+	default:
+		policy = RequestedPolicy{}
+	}
+
+	if len(cfg.RatingGroups) == 0 {
+		return policy
+	}
+
+	bounded := BoundedPolicy{
+		Policy: policy,
+		Bounds: make(map[int32]Bounds),
+	}
+	for _, bounds := range cfg.RatingGroups {
+		minQuota, maxQuota := bounds.Bounds()
+		bounded.Bounds[bounds.RatingGroup] = Bounds{Min: minQuota, Max: maxQuota}
+	}
+	return bounded
+}
+
+// RequestedPolicy reserves exactly the price of the requested units
+type RequestedPolicy struct{}
+
+func (RequestedPolicy) ReserveQuota(req Request) money.Money {
+	return req.RequestedQuota
+}
+
+// FixedPolicy reserves the same quota whatever is requested
+type FixedPolicy struct {
+	Quota money.Money
+}
+
+func (p FixedPolicy) ReserveQuota(req Request) money.Money {
+	if p.Quota.IsZero() {
+		return req.RequestedQuota
+	}
+	return p.Quota
+}
+
+// BalanceRatioPolicy reserves a percentage of the remaining balance,
+// so that one session does not lock the whole balance of the account
+type BalanceRatioPolicy struct {
+	Ratio int32
+}
+
+func (p BalanceRatioPolicy) ReserveQuota(req Request) money.Money {
+	if p.Ratio <= 0 || p.Ratio > 100 || req.Balance.Sign() < 0 {
+		return req.RequestedQuota
+	}
+	return req.Balance.Times(money.New(int64(p.Ratio), -2))
+}
+
+// AdaptivePolicy reserves the quota the session is expected to consume in Interval seconds,
+// so that heavy sessions reserve less often and light sessions do not lock the balance
+type AdaptivePolicy struct {
+	Interval int32
+}
+
+func (p AdaptivePolicy) ReserveQuota(req Request) money.Money {
+	if req.ConsumptionRate <= 0 {
+		return req.RequestedQuota
+	}
+	return money.FromFloat(req.ConsumptionRate*float64(p.Interval)).Round(estimateExponent, money.RoundHalfEven)
+}
+
+// Bounds of the quota reserved at once for a rating group, 0 means unbounded
+type Bounds struct {
+	Min money.Money
+	Max money.Money
+}
+
+// BoundedPolicy keeps the quota decided by the underlying policy within the bounds of each rating group
+type BoundedPolicy struct {
+	Policy
+	Bounds map[int32]Bounds
+}
+
+func (p BoundedPolicy) ReserveQuota(req Request) money.Money {
+	quota := p.Policy.ReserveQuota(req)
+
+	bounds, ok := p.Bounds[req.RatingGroup]
+	if !ok {
+		return quota
+	}
+	if !bounds.Min.IsZero() && quota.Cmp(bounds.Min) < 0 {
+		quota = bounds.Min
+	}
+	if !bounds.Max.IsZero() && quota.Cmp(bounds.Max) > 0 {
+		quota = bounds.Max
+	}
+	return quota
+}
@@ -0,0 +1,98 @@
+package reservation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/pkg/factory"
+)
+
+func TestReserveQuota(t *testing.T) {
+	bounds := []*factory.RatingGroupReservation{
+		{
+			RatingGroup: 1,
+			MinQuota:    "100",
+			MaxQuota:    "1000",
+		},
+	}
+
+	testCases := []struct {
+		name  string
+		cfg   *factory.ReservationPolicy
+		ratio int32
+		req   Request
+		quota money.Money
+	}{
+		{
+			name:  "default",
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.FromInt(50),
+		},
+		{
+			name:  "fixed",
+			cfg:   &factory.ReservationPolicy{Type: PolicyFixed, FixedQuota: "300"},
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.FromInt(300),
+		},
+		{
+			name:  "fixed fraction",
+			cfg:   &factory.ReservationPolicy{Type: PolicyFixed, FixedQuota: "0.50"},
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.New(5, -1),
+		},
+		{
+			name:  "balance ratio",
+			cfg:   &factory.ReservationPolicy{Type: PolicyBalanceRatio},
+			ratio: 10,
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(2000)},
+			quota: money.FromInt(200),
+		},
+		{
+			name:  "balance ratio of a fractional balance",
+			cfg:   &factory.ReservationPolicy{Type: PolicyBalanceRatio},
+			ratio: 10,
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.New(1234, -2)},
+			quota: money.New(1234, -3),
+		},
+		{
+			name:  "balance ratio with unknown balance",
+			cfg:   &factory.ReservationPolicy{Type: PolicyBalanceRatio},
+			ratio: 10,
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.FromInt(50),
+		},
+		{
+			name:  "adaptive",
+			cfg:   &factory.ReservationPolicy{Type: PolicyAdaptive, AdaptiveInterval: 30},
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1), ConsumptionRate: 4},
+			quota: money.FromInt(120),
+		},
+		{
+			name:  "bounded maximum",
+			cfg:   &factory.ReservationPolicy{Type: PolicyFixed, FixedQuota: "5000", RatingGroups: bounds},
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.FromInt(1000),
+		},
+		{
+			name:  "bounded minimum",
+			cfg:   &factory.ReservationPolicy{Type: PolicyRequested, RatingGroups: bounds},
+			req:   Request{RatingGroup: 1, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.FromInt(100),
+		},
+		{
+			name:  "unbounded rating group",
+			cfg:   &factory.ReservationPolicy{Type: PolicyRequested, RatingGroups: bounds},
+			req:   Request{RatingGroup: 2, RequestedQuota: money.FromInt(50), Balance: money.FromInt(-1)},
+			quota: money.FromInt(50),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := NewPolicy(tc.cfg, tc.ratio)
+			require.Equal(t, tc.quota, policy.ReserveQuota(tc.req))
+		})
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/sbi/processor"
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
 )
@@ -56,6 +57,11 @@ func (s *Server) getConvergenChargingRoutes() []Route {
 			Pattern: "/recharging/:rechargingInfo",
 			APIFunc: s.RechargePut,
 		},
+		{
+			Method:  http.MethodPut,
+			Pattern: "/abortcharging/:ueId",
+			APIFunc: s.AbortChargingPut,
+		},
 	}
 }
 
@@ -180,3 +186,16 @@ func (s *Server) RechargePut(c *gin.Context) {
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
+
+func (s *Server) AbortChargingPut(c *gin.Context) {
+	ueId := c.Param("ueId")
+
+	logger.ChargingdataPostLog.Warnf("UE[%s] charging sessions aborted by the operator", ueId)
+
+	_, problemDetails := s.Processor().AbortChargingSessions(ueId, processor.AbortReasonOperator)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
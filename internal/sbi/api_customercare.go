@@ -0,0 +1,53 @@
+package sbi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi/models"
+)
+
+// Operator API of the CHF for the customer-care tools
+func (s *Server) getCustomerCareRoutes() []Route {
+	return []Route{
+		{
+			Name:    "Index",
+			Method:  http.MethodGet,
+			Pattern: "/",
+			APIFunc: func(c *gin.Context) {
+				c.String(http.StatusOK, "Hello free5GC!")
+			},
+		},
+		{
+			Name:    "BalancesGet",
+			Method:  http.MethodGet,
+			Pattern: "/subscribers/:ueId/balances",
+			APIFunc: s.BalancesGet,
+		},
+	}
+}
+
+// The rating groups are given as ratingGroup query parameters, e.g. ?ratingGroup=1&ratingGroup=2
+func (s *Server) BalancesGet(c *gin.Context) {
+	var ratingGroups []int32
+	for _, ratingGroup := range c.QueryArray("ratingGroup") {
+		rg, err := strconv.ParseInt(ratingGroup, 10, 32)
+		if err != nil {
+			problemDetail := "[Query Parameter] ratingGroup: " + err.Error()
+			rsp := models.ProblemDetails{
+				Title:  "Malformed request syntax",
+				Status: http.StatusBadRequest,
+				Detail: problemDetail,
+			}
+			logger.ChargingdataPostLog.Errorln(problemDetail)
+			c.JSON(http.StatusBadRequest, rsp)
+			return
+		}
+		ratingGroups = append(ratingGroups, int32(rg))
+	}
+
+	s.Processor().HandleBalanceQuery(c, c.Param("ueId"), ratingGroups)
+}
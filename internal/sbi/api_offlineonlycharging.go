@@ -14,6 +14,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
 )
 
 func (s *Server) getOfflineOnlyChargingRoutes() []Route {
@@ -47,14 +51,65 @@ func (s *Server) getOfflineOnlyChargingRoutes() []Route {
 	}
 }
 
+// OfflinechargingdataOfflineChargingDataRefReleasePost -
 func (s *Server) OfflinechargingdataOfflineChargingDataRefReleasePost(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	chargingDataReq, ok := deserializeOfflineChargingData(c)
+	if !ok {
+		return
+	}
+	chargingSessionId := c.Param("OfflineChargingDataRef")
+
+	s.Processor().HandleOfflineChargingdataRelease(c, chargingDataReq, chargingSessionId)
 }
 
+// OfflinechargingdataOfflineChargingDataRefUpdatePost -
 func (s *Server) OfflinechargingdataOfflineChargingDataRefUpdatePost(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	chargingDataReq, ok := deserializeOfflineChargingData(c)
+	if !ok {
+		return
+	}
+	chargingSessionId := c.Param("OfflineChargingDataRef")
+
+	s.Processor().HandleOfflineChargingdataUpdate(c, chargingDataReq, chargingSessionId)
 }
 
+// OfflinechargingdataPost -
 func (s *Server) OfflinechargingdataPost(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	chargingDataReq, ok := deserializeOfflineChargingData(c)
+	if !ok {
+		return
+	}
+
+	s.Processor().HandleOfflineChargingdataInitial(c, chargingDataReq)
+}
+
+func deserializeOfflineChargingData(c *gin.Context) (models.ChfOfflineOnlyChargingChargingDataRequest, bool) {
+	var chargingDataReq models.ChfOfflineOnlyChargingChargingDataRequest
+
+	requestBody, err := c.GetRawData()
+	if err != nil {
+		problemDetail := models.ProblemDetails{
+			Title:  "System failure",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+			Cause:  "SYSTEM_FAILURE",
+		}
+		logger.ChargingdataPostLog.Errorf("Get Request Body error: %+v", err)
+		c.JSON(http.StatusInternalServerError, problemDetail)
+		return chargingDataReq, false
+	}
+
+	err = openapi.Deserialize(&chargingDataReq, requestBody, "application/json")
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		}
+		logger.ChargingdataPostLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return chargingDataReq, false
+	}
+	return chargingDataReq, true
 }
@@ -0,0 +1,64 @@
+package sbi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/sbi/processor"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+)
+
+// Operator API of the CHF for the self-care portal and the consumers informing the subscribers
+func (s *Server) getSelfCareRoutes() []Route {
+	return []Route{
+		{
+			Name:    "Index",
+			Method:  http.MethodGet,
+			Pattern: "/",
+			APIFunc: func(c *gin.Context) {
+				c.String(http.StatusOK, "Hello free5GC!")
+			},
+		},
+		{
+			Name:    "CostEstimatesPost",
+			Method:  http.MethodPost,
+			Pattern: "/cost-estimates",
+			APIFunc: s.CostEstimatesPost,
+		},
+	}
+}
+
+func (s *Server) CostEstimatesPost(c *gin.Context) {
+	var costEstimateReq processor.CostEstimateRequest
+
+	requestBody, err := c.GetRawData()
+	if err != nil {
+		problemDetail := models.ProblemDetails{
+			Title:  "System failure",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+			Cause:  "SYSTEM_FAILURE",
+		}
+		logger.ChargingdataPostLog.Errorf("Get Request Body error: %+v", err)
+		c.JSON(http.StatusInternalServerError, problemDetail)
+		return
+	}
+
+	err = openapi.Deserialize(&costEstimateReq, requestBody, "application/json")
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		}
+		logger.ChargingdataPostLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	s.Processor().HandleCostEstimate(c, costEstimateReq)
+}
@@ -14,6 +14,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
 )
 
 func (s *Server) getSpendingLimitControlRoutes() []Route {
@@ -48,13 +52,57 @@ func (s *Server) getSpendingLimitControlRoutes() []Route {
 }
 
 func (s *Server) SubscriptionsPost(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	spendingLimitContext, ok := s.deserializeSpendingLimitContext(c)
+	if !ok {
+		return
+	}
+
+	s.Processor().HandleSpendingLimitSubscriptionCreate(c, spendingLimitContext)
 }
 
 func (s *Server) SubscriptionsSubscriptionIdDelete(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	subscriptionId := c.Param("subscriptionId")
+
+	s.Processor().HandleSpendingLimitSubscriptionDelete(c, subscriptionId)
 }
 
 func (s *Server) SubscriptionsSubscriptionIdPut(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	spendingLimitContext, ok := s.deserializeSpendingLimitContext(c)
+	if !ok {
+		return
+	}
+	subscriptionId := c.Param("subscriptionId")
+
+	s.Processor().HandleSpendingLimitSubscriptionUpdate(c, spendingLimitContext, subscriptionId)
+}
+
+func (s *Server) deserializeSpendingLimitContext(c *gin.Context) (models.SpendingLimitContext, bool) {
+	var spendingLimitContext models.SpendingLimitContext
+
+	requestBody, err := c.GetRawData()
+	if err != nil {
+		problemDetail := models.ProblemDetails{
+			Title:  "System failure",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+			Cause:  "SYSTEM_FAILURE",
+		}
+		logger.SpendingLimitLog.Errorf("Get Request Body error: %+v", err)
+		c.JSON(http.StatusInternalServerError, problemDetail)
+		return spendingLimitContext, false
+	}
+
+	err = openapi.Deserialize(&spendingLimitContext, requestBody, "application/json")
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		}
+		logger.SpendingLimitLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return spendingLimitContext, false
+	}
+	return spendingLimitContext, true
 }
@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	"github.com/free5gc/chf/cdr/cdrType"
+	"github.com/free5gc/chf/internal/cgf"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi/models"
+)
+
+const (
+	// Idle charging sessions are looked for at most this often
+	idleSessionSweepInterval = 10 * time.Second
+	// The accounts the charging sessions hold reservations on are checked this often
+	accountCheckInterval = time.Minute
+)
+
+// Reasons of the CHF to abort a charging session
+const (
+	AbortReasonAccountUnavailable = "account barred or deleted"
+	AbortReasonOperator           = "operator intervention"
+	AbortReasonIdleTimeout        = "idle timeout"
+)
+
+// AbortChargingSessions aborts every charging session of the UE and returns the number of aborted sessions
+func (p *Processor) AbortChargingSessions(ueId, reason string) (int, *models.ProblemDetails) {
+	self := chf_context.GetSelf()
+	ue, ok := self.ChfUeFindBySupi(ueId)
+	if !ok {
+		logger.NotifyEventLog.Errorf("Do not find charging data for UE: %s", ueId)
+		return 0, userUnknown(ueId)
+	}
+
+	var notifyUris []string
+	ue.CULock.Lock()
+	for _, session := range ue.ChargingSessions {
+		notifyUris = append(notifyUris, p.abortChargingSession(ue, session, reason))
+	}
+	ue.CULock.Unlock()
+
+	p.finishAbortCharging(ue.Supi, notifyUris)
+	return len(notifyUris), nil
+}
+
+// Release the charging session on behalf of the NF consumer and return the URI to notify it,
+// the caller shall hold CULock
+func (p *Processor) abortChargingSession(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, reason string,
+) string {
+	chargingSessionId := session.ChargingDataRef
+	logger.NotifyEventLog.Warnf("UE[%s] abort charging session[%s]: %s", ue.Supi, chargingSessionId, reason)
+
+	refundReservedQuota(ue, session, nil)
+	releaseRating(ue, session, nil)
+
+	if cdr, ok := ue.Cdr[chargingSessionId]; ok {
+		if err := p.CloseCDRWithCause(cdr, CauseForRecClosingManagementIntervention); err != nil {
+			logger.NotifyEventLog.Errorf("CloseCDR error: %+v", err)
+		}
+		if err := dumpCdrFile(ue.Supi, []*cdrType.CHFRecord{cdr}); err != nil {
+			logger.NotifyEventLog.Errorf("Dump CDR file error: %+v", err)
+		}
+	}
+
+	ue.DeleteChargingSession(chargingSessionId)
+	delete(ue.Cdr, chargingSessionId)
+
+	return session.NotifyUri
+}
+
+// Transfer the closed records and notify the NF consumers of the aborted sessions
+func (p *Processor) finishAbortCharging(supi string, notifyUris []string) {
+	if len(notifyUris) == 0 {
+		return
+	}
+
+	if err := cgf.SendCDR(supi); err != nil {
+		logger.NotifyEventLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
+	}
+
+	notifyRequest := models.ChargingNotifyRequest{
+		NotificationType: models.ChfConvergedChargingNotificationType_ABORT_CHARGING,
+	}
+	for _, notifyUri := range notifyUris {
+		if notifyUri == "" {
+			continue
+		}
+		p.SendChargingNotification(notifyUri, notifyRequest)
+	}
+}
+
+// Sessions whose account is barred or deleted in the ABMF cannot be charged anymore
+func isAccountUnavailable(chargingErr *ChargingError) bool {
+	return chargingErr != nil &&
+		(chargingErr.Cause == CauseUserUnknown || chargingErr.Cause == CauseEndUserServiceDenied)
+}
+
+// SweepChargingSessions aborts the charging sessions whose account is barred or deleted, and the sessions idle
+// for longer than the idle timeout unless it is 0, until ctx is done
+func (p *Processor) SweepChargingSessions(ctx context.Context, wg *sync.WaitGroup, idleTimeout time.Duration) {
+	defer wg.Done()
+
+	accountTicker := time.NewTicker(accountCheckInterval)
+	defer accountTicker.Stop()
+	// Never ticks if idle sessions are kept
+	var idleTick <-chan time.Time
+	if idleTimeout > 0 {
+		idleTicker := time.NewTicker(min(idleTimeout, idleSessionSweepInterval))
+		defer idleTicker.Stop()
+		idleTick = idleTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-accountTicker.C:
+			p.abortUnavailableAccountSessions()
+		case now := <-idleTick:
+			p.abortIdleChargingSessions(now.Add(-idleTimeout))
+		}
+	}
+}
+
+// Offline only sessions hold no quota, they are not aborted when idle
+func (p *Processor) abortIdleChargingSessions(deadline time.Time) {
+	self := chf_context.GetSelf()
+	self.UePool.Range(func(key, value interface{}) bool {
+		ue := value.(*chf_context.ChfUe)
+
+		var notifyUris []string
+		ue.CULock.Lock()
+		for _, session := range ue.IdleChargingSessions(deadline) {
+			if session.OfflineOnly {
+				continue
+			}
+			notifyUris = append(notifyUris, p.abortChargingSession(ue, session, AbortReasonIdleTimeout))
+		}
+		ue.CULock.Unlock()
+
+		p.finishAbortCharging(ue.Supi, notifyUris)
+		return true
+	})
+}
+
+// The sessions holding a reservation on an account barred or deleted since are aborted before their next request,
+// the account of each rating group is checked once per UE
+func (p *Processor) abortUnavailableAccountSessions() {
+	self := chf_context.GetSelf()
+	self.UePool.Range(func(key, value interface{}) bool {
+		ue := value.(*chf_context.ChfUe)
+
+		var notifyUris []string
+		ue.CULock.Lock()
+		unavailable := make(map[int32]bool)
+		var aborted []*chf_context.ChargingSession
+		for _, session := range ue.ChargingSessions {
+			if session.OfflineOnly {
+				continue
+			}
+			for _, rg := range session.RatingGroups {
+				if !holdsReservation(session, rg) {
+					continue
+				}
+				if _, checked := unavailable[rg]; !checked {
+					unavailable[rg] = isAccountOfRatingGroupUnavailable(ue, rg)
+				}
+				if unavailable[rg] {
+					aborted = append(aborted, session)
+					break
+				}
+			}
+		}
+		for _, session := range aborted {
+			notifyUris = append(notifyUris, p.abortChargingSession(ue, session, AbortReasonAccountUnavailable))
+		}
+		ue.CULock.Unlock()
+
+		p.finishAbortCharging(ue.Supi, notifyUris)
+		return true
+	})
+}
+
+// The ABMF answers a balance check with USER_UNKNOWN once the account is deleted, and denies the rating group
+// of a barred account. An unanswered check tells nothing.
+func isAccountOfRatingGroupUnavailable(ue *chf_context.ChfUe, rg int32) bool {
+	acctDebitRsp, err := sendAccountDebitRequest(ue, newBalanceCheckRequest(ue, rg))
+	if err != nil {
+		logger.NotifyEventLog.Debugf("UE[%s] rating group [%d]: account check error: %+v", ue.Supi, rg, err)
+		return isAccountUnavailable(chargingErrorFrom(err, false, false))
+	}
+	mscc := acctDebitRsp.MultipleServicesCreditControl
+	return mscc != nil && uint32(mscc.ResultCode) == charging_code.EndUserServiceDenied
+}
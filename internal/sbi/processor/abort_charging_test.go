@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+// openOfflineSession creates an offline only charging data resource of testSupi and returns its charging data ref
+func openOfflineSession(t *testing.T, p *Processor) string {
+	_, location, problemDetails := p.OfflineChargingDataCreate(models.ChfOfflineOnlyChargingChargingDataRequest{
+		SubscriberIdentifier:     testSupi,
+		NfConsumerIdentification: &models.ChfOfflineOnlyChargingNfIdentification{NFName: "SMF"},
+	})
+	require.Nil(t, problemDetails)
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+// openReservingSession creates a charging data resource of testSupi holding a reservation for rating group 1
+func openReservingSession(t *testing.T, p *Processor) string {
+	chargingDataRef := openSession(t, p)
+	_, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)), chargingDataRef)
+	require.Nil(t, problemDetails)
+	return chargingDataRef
+}
+
+func TestAbortIdleChargingSessions(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+
+	online := openReservingSession(t, p)
+	offline := openOfflineSession(t, p)
+
+	p.abortIdleChargingSessions(time.Now().Add(time.Hour))
+
+	// The idle online session is aborted and its reservation refunded, the offline only one holds no quota
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	require.NotContains(t, ue.ChargingSessions, online)
+	require.Contains(t, ue.ChargingSessions, offline)
+	require.Equal(t, "100000", peers.balance.String())
+}
+
+func TestAbortUnavailableAccountSessions(t *testing.T) {
+	testCases := []struct {
+		name    string
+		barred  bool
+		deleted bool
+		aborted bool
+	}{
+		{name: "available"},
+		{name: "barred", barred: true, aborted: true},
+		{name: "deleted", deleted: true, aborted: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+			p := setUpCharging(t, peers)
+
+			reserving := openReservingSession(t, p)
+			idle := openSession(t, p)
+			offline := openOfflineSession(t, p)
+
+			peers.barred, peers.deleted = tc.barred, tc.deleted
+			debited := len(peers.debited)
+			p.abortUnavailableAccountSessions()
+
+			// Only the session holding a reservation is checked, before its next request
+			checks := 0
+			for _, ccr := range peers.debited[debited:] {
+				if ccr.RequestedAction == charging_datatype.CHECK_BALANCE {
+					checks++
+				}
+			}
+			require.Equal(t, 1, checks)
+			ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+			require.True(t, ok)
+			if tc.aborted {
+				require.NotContains(t, ue.ChargingSessions, reserving)
+			} else {
+				require.Contains(t, ue.ChargingSessions, reserving)
+			}
+			require.Contains(t, ue.ChargingSessions, idle)
+			require.Contains(t, ue.ChargingSessions, offline)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/gin-gonic/gin"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+// SubscriberBalances are the live balances of the rating groups of a subscriber, e.g. for the customer care
+type SubscriberBalances struct {
+	SubscriberIdentifier string    `json:"subscriberIdentifier"`
+	Balances             []Balance `json:"balances"`
+	TimeStamp            time.Time `json:"timeStamp"`
+}
+
+type Balance struct {
+	RatingGroup int32 `json:"ratingGroup"`
+	// Balance the ABMF can still grant
+	RemainingBalance string `json:"remainingBalance"`
+	// Amount the reservation ledger of the ABMF holds for the charging sessions, not part of the remaining balance
+	// until it is refunded
+	ReservedAmount string `json:"reservedAmount"`
+	CurrencyCode   uint32 `json:"currencyCode"`
+	ActiveSessions int    `json:"activeSessions"`
+	// Buckets of the account the rating group consumes, in money or octets
+	Buckets []BucketBalance `json:"buckets,omitempty"`
+}
+
+type BucketBalance struct {
+	BucketId uint64 `json:"bucketId"`
+	Balance  string `json:"balance"`
+}
+
+func (p *Processor) HandleBalanceQuery(c *gin.Context, ueId string, ratingGroups []int32) {
+	logger.ChargingdataPostLog.Infof("HandleBalanceQuery")
+	balances, problemDetails := p.BalanceQuery(ueId, ratingGroups)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.JSON(http.StatusOK, balances)
+}
+
+// The ABMF answers the balance of each rating group to a balance check along with the amounts its reservation
+// ledger holds for the charging sessions. The rating groups of the sessions of the subscriber are queried if none
+// is given. An unknown subscriber is queried with a transient context, no context is kept for it.
+func (p *Processor) BalanceQuery(ueId string, ratingGroups []int32) (*SubscriberBalances, *models.ProblemDetails) {
+	if ueId == "" {
+		return nil, NewChargingError(CauseMandatoryIeMissing, "subscriberIdentifier is missing",
+			models.InvalidParam{Param: "/subscriberIdentifier", Reason: "missing"}).ProblemDetails()
+	}
+	ue, release, err := enquiryUe(ueId)
+	if err != nil {
+		logger.ChargingdataPostLog.Errorf("New CHFUe error %s", err)
+		return nil, NewChargingError(CauseChargingFailed, err.Error(), models.InvalidParam{
+			Param:  "/subscriberIdentifier",
+			Reason: "only IMSI based SUPI is supported",
+		}).ProblemDetails()
+	}
+	defer release()
+
+	if len(ratingGroups) == 0 {
+		ratingGroups = sessionRatingGroups(ue)
+	}
+	if len(ratingGroups) == 0 {
+		return nil, NewChargingError(CauseMandatoryIeMissing, "ratingGroup is missing",
+			models.InvalidParam{Param: "ratingGroup", Reason: "no active charging session to query"}).ProblemDetails()
+	}
+
+	subscriberBalances := &SubscriberBalances{SubscriberIdentifier: ueId}
+	for _, rg := range ratingGroups {
+		acctDebitRsp, errAcct := sendAccountDebitRequest(ue, newBalanceCheckRequest(ue, rg))
+		if errAcct != nil {
+			logger.ChargingdataPostLog.Errorf("UE[%s] rating group [%d]: balance check error: %+v", ueId, rg, errAcct)
+			return nil, chargingErrorFrom(errAcct, true, false).ProblemDetails()
+		}
+		remainingBalance, currencyCode, found := remainingBalanceOf(acctDebitRsp)
+		if !found {
+			currencyCode = money.DefaultCurrencyCode
+		}
+		reserved, activeSessions := reservedBalanceOf(acctDebitRsp)
+		subscriberBalances.Balances = append(subscriberBalances.Balances, Balance{
+			RatingGroup:      rg,
+			RemainingBalance: remainingBalance.String(),
+			ReservedAmount:   reserved.String(),
+			CurrencyCode:     currencyCode,
+			ActiveSessions:   activeSessions,
+			Buckets:          bucketBalancesOf(acctDebitRsp),
+		})
+	}
+	subscriberBalances.TimeStamp = time.Now()
+
+	return subscriberBalances, nil
+}
+
+// A balance check of the account of the rating group, outside of any charging session
+func newBalanceCheckRequest(ue *chf_context.ChfUe, rg int32) *charging_datatype.AccountDebitRequest {
+	session := chf_context.NewChargingSession("")
+	ccr := newAccountDebitRequest(ue, session, rg, buildSubscriptionId(ue.Supi))
+	ccr.CcRequestType = charging_datatype.EVENT_REQUEST
+	ccr.RequestedAction = charging_datatype.CHECK_BALANCE
+	ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
+		RatingGroup: datatype.Unsigned32(rg),
+	}
+	return ccr
+}
+
+// The ABMF answers an Acct-Balance per bucket to a balance check
+func bucketBalancesOf(acctDebitRsp *charging_datatype.AccountDebitResponse) []BucketBalance {
+	if acctDebitRsp.ABResponse == nil {
+		return nil
+	}
+	var buckets []BucketBalance
+	for _, acctBalance := range acctDebitRsp.ABResponse.AcctBalance {
+		buckets = append(buckets, BucketBalance{
+			BucketId: uint64(acctBalance.AcctBalanceId),
+			Balance:  money.FromUnitValue(acctBalance.UnitValue).String(),
+		})
+	}
+	return buckets
+}
+
+// The ABMF answers the money its reservation ledger holds for the rating group to a balance check, along with
+// the number of sessions holding it
+func reservedBalanceOf(acctDebitRsp *charging_datatype.AccountDebitResponse) (money.Money, int) {
+	if acctDebitRsp.ABResponse == nil || acctDebitRsp.ABResponse.ReservedBalance == nil {
+		return money.Money{}, 0
+	}
+	reservedBalance := acctDebitRsp.ABResponse.ReservedBalance
+	return money.FromUnitValue(reservedBalance.UnitValue), int(reservedBalance.ReservationCount)
+}
+
+// Rating groups the charging sessions of the subscriber hold a reservation for
+func sessionRatingGroups(ue *chf_context.ChfUe) []int32 {
+	var ratingGroups []int32
+	held := make(map[int32]bool)
+	for _, session := range ue.ChargingSessions {
+		for rg, reservedQuota := range session.ReservedQuota {
+			if reservedQuota.Sign() > 0 && !held[rg] {
+				held[rg] = true
+				ratingGroups = append(ratingGroups, rg)
+			}
+		}
+	}
+	sort.Slice(ratingGroups, func(i, j int) bool { return ratingGroups[i] < ratingGroups[j] })
+	return ratingGroups
+}
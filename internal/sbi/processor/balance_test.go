@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestSessionRatingGroups(t *testing.T) {
+	first := chf_context.NewChargingSession("1")
+	first.ReservedQuota[2] = money.New(25, -1)
+	first.ReservedQuota[1] = money.New(1, 0)
+	second := chf_context.NewChargingSession("2")
+	second.ReservedQuota[1] = money.New(5, -1)
+	// Refunded reservation
+	second.ReservedQuota[3] = money.Money{}
+	ue := &chf_context.ChfUe{
+		ChargingSessions: map[string]*chf_context.ChargingSession{"1": first, "2": second},
+	}
+
+	require.Equal(t, []int32{1, 2}, sessionRatingGroups(ue))
+}
+
+func TestReservedBalanceOf(t *testing.T) {
+	reserved, activeSessions := reservedBalanceOf(&charging_datatype.AccountDebitResponse{})
+	require.True(t, reserved.IsZero())
+	require.Equal(t, 0, activeSessions)
+
+	reserved, activeSessions = reservedBalanceOf(&charging_datatype.AccountDebitResponse{
+		ABResponse: &charging_datatype.ABResponse{
+			ReservedBalance: &charging_datatype.ReservedBalance{
+				UnitValue:        money.New(35, -1).UnitValue(),
+				ReservationCount: 2,
+			},
+		},
+	})
+	require.Equal(t, "3.5", reserved.String())
+	require.Equal(t, 2, activeSessions)
+}
+
+func TestBalanceQuery(t *testing.T) {
+	peers := &chargingPeers{
+		unitCost: money.FromInt(1), balance: money.FromInt(100), reserved: money.FromInt(30), reservations: 2,
+	}
+	p := setUpCharging(t, peers)
+	self := chf_context.GetSelf()
+
+	// The reservations are those of the ledger of the ABMF, not of the sessions the CHF holds
+	ue, ok := self.ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	session := chf_context.NewChargingSession("1")
+	session.ReservedQuota[1] = money.FromInt(10)
+	ue.ChargingSessions["1"] = session
+
+	balances, problemDetails := p.BalanceQuery(testSupi, nil)
+	require.Nil(t, problemDetails)
+	require.Len(t, balances.Balances, 1)
+	balance := balances.Balances[0]
+	require.Equal(t, int32(1), balance.RatingGroup)
+	require.Equal(t, "100", balance.RemainingBalance)
+	require.Equal(t, "30", balance.ReservedAmount)
+	require.Equal(t, 2, balance.ActiveSessions)
+
+	// No context is left for the subscriber the CHF does not know
+	const unknownSupi = "imsi-208930000000099"
+	balances, problemDetails = p.BalanceQuery(unknownSupi, []int32{7})
+	require.Nil(t, problemDetails)
+	require.Equal(t, "30", balances.Balances[0].ReservedAmount)
+	_, ok = self.ChfUeFindBySupi(unknownSupi)
+	require.False(t, ok)
+
+	_, problemDetails = p.BalanceQuery(unknownSupi, nil)
+	require.Equal(t, CauseMandatoryIeMissing, problemDetails.Cause)
+}
+
+func TestBalanceQueryWithoutSubscriber(t *testing.T) {
+	p := &Processor{}
+	balances, problemDetails := p.BalanceQuery("", []int32{1})
+	require.Nil(t, balances)
+	require.Equal(t, int32(http.StatusBadRequest), problemDetails.Status)
+	require.Equal(t, CauseMandatoryIeMissing, problemDetails.Cause)
+}
+
+func TestBucketBalancesOf(t *testing.T) {
+	require.Nil(t, bucketBalancesOf(&charging_datatype.AccountDebitResponse{}))
+
+	acctDebitRsp := &charging_datatype.AccountDebitResponse{
+		ABResponse: &charging_datatype.ABResponse{
+			AcctBalance: []*charging_datatype.AcctBalance{
+				{AcctBalanceId: 1, UnitValue: money.New(105, -1).UnitValue()},
+				{AcctBalanceId: 7, UnitValue: money.FromInt(1000).UnitValue()},
+			},
+		},
+	}
+	require.Equal(t, []BucketBalance{{BucketId: 1, Balance: "10.5"}, {BucketId: 7, Balance: "1000"}},
+		bucketBalancesOf(acctDebitRsp))
+}
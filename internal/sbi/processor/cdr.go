@@ -226,7 +226,21 @@ func (p *Processor) UpdateCDR(
 	return nil
 }
 
+// Causes for record closing used by the CHF, TS 32.298 5.1.5.1.3
+const (
+	CauseForRecClosingNormalRelease          int64 = 0
+	CauseForRecClosingPartialRecord          int64 = 1
+	CauseForRecClosingManagementIntervention int64 = 20
+)
+
 func (p *Processor) CloseCDR(record *cdrType.CHFRecord, partial bool) error {
+	if partial {
+		return p.CloseCDRWithCause(record, CauseForRecClosingPartialRecord)
+	}
+	return p.CloseCDRWithCause(record, CauseForRecClosingNormalRelease)
+}
+
+func (p *Processor) CloseCDRWithCause(record *cdrType.CHFRecord, cause int64) error {
 	logger.ChargingdataPostLog.Infof("Close CDR")
 
 	chfCdr := record.ChargingFunctionRecord
@@ -253,11 +267,7 @@ func (p *Processor) CloseCDR(record *cdrType.CHFRecord, partial bool) error {
 	// positionMethodFailure	 (54),
 	// unknownOrUnreachableLCSClient	 (58),
 	// listofDownstreamNodeChange	 (59)
-	if partial {
-		chfCdr.CauseForRecClosing = cdrType.CauseForRecClosing{Value: 1}
-	} else {
-		chfCdr.CauseForRecClosing = cdrType.CauseForRecClosing{Value: 0}
-	}
+	chfCdr.CauseForRecClosing = cdrType.CauseForRecClosing{Value: cause}
 
 	return nil
 }
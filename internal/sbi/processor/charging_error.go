@@ -0,0 +1,250 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/abmf"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/rating"
+	"github.com/free5gc/chf/internal/ratingcounter"
+	"github.com/free5gc/openapi/models"
+)
+
+// Application errors of Nchf_ConvergedCharging, TS 32.291 6.1.7.3
+const (
+	CauseChargingFailed          = "CHARGING_FAILED"
+	CauseReAuthorizationFailed   = "RE_AUTHORIZATION_FAILED"
+	CauseChargingNotApplicable   = "CHARGING_NOT_APPLICABLE"
+	CauseUserUnknown             = "USER_UNKNOWN"
+	CauseEndUserRequestDenied    = "END_USER_REQUEST_DENIED"
+	CauseQuotaLimitReached       = "QUOTA_LIMIT_REACHED"
+	CauseEndUserServiceDenied    = "END_USER_SERVICE_DENIED"
+	CauseChargingDataRefNotFound = "CHARGING_DATA_REF_NOT_FOUND"
+)
+
+var chargingErrorStatus = map[string]int32{
+	CauseChargingFailed:          http.StatusBadRequest,
+	CauseReAuthorizationFailed:   http.StatusBadRequest,
+	CauseChargingNotApplicable:   http.StatusBadRequest,
+	CauseUserUnknown:             http.StatusNotFound,
+	CauseEndUserRequestDenied:    http.StatusForbidden,
+	CauseQuotaLimitReached:       http.StatusForbidden,
+	CauseEndUserServiceDenied:    http.StatusForbidden,
+	CauseChargingDataRefNotFound: http.StatusNotFound,
+}
+
+// ChargingError is a failure of a charging data request, reported to the NF consumer as ProblemDetails
+type ChargingError struct {
+	Cause         string
+	Detail        string
+	InvalidParams []models.InvalidParam
+}
+
+func NewChargingError(cause, detail string, invalidParams ...models.InvalidParam) *ChargingError {
+	return &ChargingError{
+		Cause:         cause,
+		Detail:        detail,
+		InvalidParams: invalidParams,
+	}
+}
+
+func (e *ChargingError) Error() string {
+	return e.Cause + ": " + e.Detail
+}
+
+func (e *ChargingError) Status() int32 {
+	if status, ok := chargingErrorStatus[e.Cause]; ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+func (e *ChargingError) ProblemDetails() *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Title:         e.Cause,
+		Status:        e.Status(),
+		Detail:        e.Detail,
+		Cause:         e.Cause,
+		InvalidParams: e.InvalidParams,
+	}
+}
+
+// Failure Result-Code answered by the rating function or the ABMF
+type diameterResultError uint32
+
+func (e diameterResultError) Error() string {
+	return fmt.Sprintf("diameter Result-Code %d", uint32(e))
+}
+
+func isDiameterFailure(resultCode uint32) bool {
+	// Peers not setting the Result-Code are treated as successful
+	return resultCode != 0 && resultCode != diam.Success
+}
+
+// Peers a charging data request is rated and debited with
+const (
+	peerRatingFunction = "rating function"
+	peerAbmf           = "account balance management"
+)
+
+// peerError is a request to the rating function or the ABMF that failed, unanswered or answered with a failure
+type peerError struct {
+	peer string
+	err  error
+}
+
+func (e *peerError) Error() string {
+	return e.peer + ": " + e.err.Error()
+}
+
+func (e *peerError) Unwrap() error {
+	return e.err
+}
+
+// Diameter requests to the rating function and the ABMF, replaced in tests
+var (
+	rateServiceUsage = rating.SendServiceUsageRequest
+	debitAccount     = abmf.SendAccountDebitRequest
+)
+
+func sendServiceUsageRequest(
+	ue *chf_context.ChfUe, sur *charging_datatype.ServiceUsageRequest,
+) (*charging_datatype.ServiceUsageResponse, error) {
+	sua, err := rateServiceUsage(ue, sur)
+	if err != nil {
+		return nil, &peerError{peer: peerRatingFunction, err: err}
+	}
+	if isDiameterFailure(uint32(sua.ResultCode)) {
+		return nil, &peerError{peer: peerRatingFunction, err: diameterResultError(sua.ResultCode)}
+	}
+	// The counter impacts are sent to the ABMF with the next account request
+	if sua.ServiceRating != nil && len(sua.ServiceRating.ImpactOnCounter) != 0 {
+		loadRatingCounters(ue)
+		ue.ApplyCounterImpacts(sua.ServiceRating.ImpactOnCounter, time.Now())
+	}
+	return sua, nil
+}
+
+func sendAccountDebitRequest(
+	ue *chf_context.ChfUe, ccr *charging_datatype.AccountDebitRequest,
+) (*charging_datatype.AccountDebitResponse, error) {
+	ccr.ImpactOnCounter = ue.TakeCounterImpacts()
+	cca, err := debitAccount(ue, ccr)
+	if err != nil {
+		// Not answered, the counter impacts are sent again with the next account request
+		ue.PendingCounterImpacts = append(ccr.ImpactOnCounter, ue.PendingCounterImpacts...)
+		return nil, &peerError{peer: peerAbmf, err: err}
+	}
+	// The ABMF answers the counters of the subscriber once it persisted the counter impacts
+	if cca.ABResponse != nil {
+		counters := make([]ratingcounter.Counter, 0, len(cca.ABResponse.Counter))
+		for _, counter := range cca.ABResponse.Counter {
+			counters = append(counters, ratingcounter.FromAvp(ue.Supi, counter))
+		}
+		ue.RestoreRatingCounters(counters)
+	}
+	if isDiameterFailure(uint32(cca.ResultCode)) {
+		return nil, &peerError{peer: peerAbmf, err: diameterResultError(cca.ResultCode)}
+	}
+	return cca, nil
+}
+
+// unitFailures are the errors of the rating groups of a request whose rating or account request failed
+type unitFailures map[int32]error
+
+// fail reports the failure of the rating group in its result code
+func (f unitFailures) fail(unitInformation *models.MultipleUnitInformation, err error) {
+	unitInformation.ResultCode = unitResultCodeOf(err)
+	f[unitInformation.RatingGroup] = err
+}
+
+// Result of a rating group whose rating or account request failed
+func unitResultCodeOf(err error) models.ChfConvergedChargingResultCode {
+	var resultErr diameterResultError
+	if !errors.As(err, &resultErr) {
+		// No answer from the rating function or the ABMF, the rating group cannot be charged
+		return models.ChfConvergedChargingResultCode_RATING_FAILED
+	}
+
+	switch uint32(resultErr) {
+	case charging_code.EndUserServiceDenied:
+		return models.ChfConvergedChargingResultCode_END_USER_SERVICE_DENIED
+	case charging_code.CreditControlNotApplicable:
+		return models.ChfConvergedChargingResultCode_QUOTA_MANAGEMENT_NOT_APPLICABLE
+	case charging_code.CreditLimitReached:
+		return models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED
+	case charging_code.UserUnknown:
+		return models.ChfConvergedChargingResultCode_USER_UNKNOWN
+	}
+	return models.ChfConvergedChargingResultCode_RATING_FAILED
+}
+
+// Application error of a request whose rating groups all failed with the given result, cause is the error
+// of the failed request if known. reAuthorization is set for update requests, which re-authorize the quota
+// of a session.
+func chargingErrorOf(
+	resultCode models.ChfConvergedChargingResultCode, cause error, oneTimeEvent, reAuthorization bool,
+) *ChargingError {
+	switch resultCode {
+	case models.ChfConvergedChargingResultCode_USER_UNKNOWN:
+		return NewChargingError(CauseUserUnknown, "Subscriber is unknown to the account balance management",
+			models.InvalidParam{Param: "/subscriberIdentifier", Reason: "no account for the subscriber"})
+	case models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED:
+		return NewChargingError(CauseQuotaLimitReached, "Credit limit of the subscriber is reached")
+	case models.ChfConvergedChargingResultCode_QUOTA_MANAGEMENT_NOT_APPLICABLE:
+		return NewChargingError(CauseChargingNotApplicable, "Credit control is not applicable to the subscriber")
+	case models.ChfConvergedChargingResultCode_END_USER_SERVICE_DENIED,
+		models.ChfConvergedChargingResultCode_END_USER_SERVICE_REJECTED:
+		// A denied event is a denied request, a denied session is a denied service
+		if oneTimeEvent {
+			return NewChargingError(CauseEndUserRequestDenied, "Account balance management denied the event")
+		}
+		return NewChargingError(CauseEndUserServiceDenied, "Account balance management denied the service")
+	}
+
+	// The peer that did not answer, the rating function or the ABMF, is told in the detail
+	detail := "Rating of the charging data failed"
+	var failed *peerError
+	if errors.As(cause, &failed) {
+		switch failed.peer {
+		case peerAbmf:
+			detail = "Account balance management request failed: " + failed.err.Error()
+		case peerRatingFunction:
+			detail = "Rating function request failed: " + failed.err.Error()
+		}
+	}
+	if reAuthorization {
+		return NewChargingError(CauseReAuthorizationFailed,
+			"Quota of the charging session cannot be re-authorized. "+detail)
+	}
+	return NewChargingError(CauseChargingFailed, detail)
+}
+
+// chargingErrorFrom is the application error of a request that failed with err
+func chargingErrorFrom(err error, oneTimeEvent, reAuthorization bool) *ChargingError {
+	return chargingErrorOf(unitResultCodeOf(err), err, oneTimeEvent, reAuthorization)
+}
+
+// The request fails as a whole only if every rating group of it fails
+func requestChargingError(
+	multipleUnitInformation []models.MultipleUnitInformation, failures unitFailures, oneTimeEvent, reAuthorization bool,
+) *ChargingError {
+	if len(multipleUnitInformation) == 0 {
+		return nil
+	}
+	for _, unitInformation := range multipleUnitInformation {
+		if unitInformation.ResultCode == "" ||
+			unitInformation.ResultCode == models.ChfConvergedChargingResultCode_SUCCESS {
+			return nil
+		}
+	}
+	return chargingErrorOf(multipleUnitInformation[0].ResultCode, failures[multipleUnitInformation[0].RatingGroup],
+		oneTimeEvent, reAuthorization)
+}
@@ -0,0 +1,221 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestUnitResultCodeOf(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		resultCode models.ChfConvergedChargingResultCode
+	}{
+		{
+			name:       "no answer",
+			err:        fmt.Errorf("timeout: no rate answer received"),
+			resultCode: models.ChfConvergedChargingResultCode_RATING_FAILED,
+		},
+		{
+			name:       "credit limit reached",
+			err:        diameterResultError(charging_code.CreditLimitReached),
+			resultCode: models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED,
+		},
+		{
+			name:       "user unknown",
+			err:        fmt.Errorf("debit: %w", diameterResultError(charging_code.UserUnknown)),
+			resultCode: models.ChfConvergedChargingResultCode_USER_UNKNOWN,
+		},
+		{
+			name:       "service denied",
+			err:        diameterResultError(charging_code.EndUserServiceDenied),
+			resultCode: models.ChfConvergedChargingResultCode_END_USER_SERVICE_DENIED,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.resultCode, unitResultCodeOf(tc.err))
+		})
+	}
+}
+
+func TestChargingErrorStatus(t *testing.T) {
+	problemDetails := NewChargingError(CauseChargingNotApplicable, "Charging is not applicable").ProblemDetails()
+	require.Equal(t, int32(http.StatusBadRequest), problemDetails.Status)
+}
+
+func TestRequestChargingError(t *testing.T) {
+	errTimeout := errors.New("timeout")
+	failed := func(resultCode models.ChfConvergedChargingResultCode) models.MultipleUnitInformation {
+		return models.MultipleUnitInformation{ResultCode: resultCode}
+	}
+
+	testCases := []struct {
+		name                    string
+		multipleUnitInformation []models.MultipleUnitInformation
+		failures                unitFailures
+		oneTimeEvent            bool
+		reAuthorization         bool
+		status                  int32
+		cause                   string
+		detail                  string
+	}{
+		{
+			name: "partial failure",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_SUCCESS),
+				failed(models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED),
+			},
+		},
+		{
+			name: "quota limit reached",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED),
+			},
+			status: http.StatusForbidden,
+			cause:  CauseQuotaLimitReached,
+		},
+		{
+			name: "denied event",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_END_USER_SERVICE_DENIED),
+			},
+			oneTimeEvent: true,
+			status:       http.StatusForbidden,
+			cause:        CauseEndUserRequestDenied,
+		},
+		{
+			name: "unknown user",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_USER_UNKNOWN),
+			},
+			status: http.StatusNotFound,
+			cause:  CauseUserUnknown,
+		},
+		{
+			name: "rating failed on update",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_RATING_FAILED),
+			},
+			reAuthorization: true,
+			status:          http.StatusBadRequest,
+			cause:           CauseReAuthorizationFailed,
+		},
+		{
+			name: "rating function not answering",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_RATING_FAILED),
+			},
+			failures: unitFailures{0: &peerError{peer: peerRatingFunction, err: errTimeout}},
+			status:   http.StatusBadRequest,
+			cause:    CauseChargingFailed,
+			detail:   "Rating function request failed: timeout",
+		},
+		{
+			name: "ABMF not answering",
+			multipleUnitInformation: []models.MultipleUnitInformation{
+				failed(models.ChfConvergedChargingResultCode_RATING_FAILED),
+			},
+			failures: unitFailures{0: &peerError{peer: peerAbmf, err: errTimeout}},
+			status:   http.StatusBadRequest,
+			cause:    CauseChargingFailed,
+			detail:   "Account balance management request failed: timeout",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chargingErr := requestChargingError(tc.multipleUnitInformation, tc.failures, tc.oneTimeEvent,
+				tc.reAuthorization)
+			if tc.cause == "" {
+				require.Nil(t, chargingErr)
+				return
+			}
+			require.NotNil(t, chargingErr)
+			problemDetails := chargingErr.ProblemDetails()
+			require.Equal(t, tc.status, problemDetails.Status)
+			require.Equal(t, tc.cause, problemDetails.Cause)
+			if tc.detail != "" {
+				require.Equal(t, tc.detail, problemDetails.Detail)
+			}
+		})
+	}
+}
+
+func TestPeerTimeoutChargingError(t *testing.T) {
+	rfTimeout := errors.New("timeout: no rate answer received")
+	abmfTimeout := errors.New("timeout: no account answer received")
+	event := models.ChfConvergedChargingChargingDataRequest{
+		SubscriberIdentifier:     testSupi,
+		NfConsumerIdentification: &models.ChfConvergedChargingNfIdentification{NFName: "SMSF"},
+		OneTimeEvent:             true,
+		OneTimeEventType:         models.OneTimeEventType_IEC,
+		MultipleUnitUsage: []models.ChfConvergedChargingMultipleUnitUsage{
+			{RatingGroup: 1, RequestedUnit: &models.RequestedUnit{ServiceSpecificUnits: 1}},
+		},
+	}
+	final := []models.ChfConvergedChargingTrigger{{TriggerType: models.ChfConvergedChargingTriggerType_FINAL}}
+
+	testCases := []struct {
+		name   string
+		peers  *chargingPeers
+		update bool
+		cause  string
+		detail string
+	}{
+		{
+			name:   "event not rated",
+			peers:  &chargingPeers{rateErr: rfTimeout},
+			cause:  CauseChargingFailed,
+			detail: "Rating function request failed: " + rfTimeout.Error(),
+		},
+		{
+			name:   "event not debited",
+			peers:  &chargingPeers{unitCost: money.FromInt(1), debitErr: abmfTimeout},
+			cause:  CauseChargingFailed,
+			detail: "Account balance management request failed: " + abmfTimeout.Error(),
+		},
+		{
+			name:   "final usage not rated",
+			peers:  &chargingPeers{rateErr: rfTimeout},
+			update: true,
+			cause:  CauseReAuthorizationFailed,
+			detail: "Rating function request failed: " + rfTimeout.Error(),
+		},
+		{
+			name:   "quota not reserved",
+			peers:  &chargingPeers{unitCost: money.FromInt(1), debitErr: abmfTimeout},
+			update: true,
+			cause:  CauseReAuthorizationFailed,
+			detail: "Account balance management request failed: " + abmfTimeout.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := setUpCharging(t, tc.peers)
+			var problemDetails *models.ProblemDetails
+			if tc.update {
+				chargingData := chargingDataOf(1, onlineUsage(1, 100, 1000))
+				if tc.peers.rateErr != nil {
+					chargingData.Triggers = final
+				}
+				_, problemDetails = p.ChargingDataUpdate(chargingData, openSession(t, p))
+			} else {
+				_, _, problemDetails = p.ChargingDataCreate(event)
+			}
+			require.NotNil(t, problemDetails)
+			require.Equal(t, tc.cause, problemDetails.Cause)
+			require.Contains(t, problemDetails.Detail, tc.detail)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package processor
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
@@ -17,16 +18,26 @@ import (
 	"github.com/free5gc/chf/cdr/asn"
 	"github.com/free5gc/chf/cdr/cdrConvert"
 	"github.com/free5gc/chf/cdr/cdrType"
-	"github.com/free5gc/chf/internal/abmf"
 	"github.com/free5gc/chf/internal/cgf"
 	chf_context "github.com/free5gc/chf/internal/context"
 	"github.com/free5gc/chf/internal/logger"
-	"github.com/free5gc/chf/internal/rating"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/internal/policycounter"
+	"github.com/free5gc/chf/internal/ratingcounter"
+	"github.com/free5gc/chf/internal/reservation"
+	"github.com/free5gc/chf/internal/tariffcache"
 	"github.com/free5gc/chf/internal/util"
 	Nchf_ConvergedCharging "github.com/free5gc/openapi/chf/ConvergedCharging"
 	"github.com/free5gc/openapi/models"
 )
 
+// Unit types a rating group can be charged by
+var chargedUnitTypes = []charging_datatype.CCUnitType{
+	charging_datatype.TIME,
+	charging_datatype.TOTALOCTETS,
+	charging_datatype.SERVICESPECIFICUNITS,
+}
+
 func min[T constraints.Ordered](a, b T) T {
 	if a < b {
 		return a
@@ -35,8 +46,6 @@ func min[T constraints.Ordered](a, b T) T {
 }
 
 func (p *Processor) NotifyRecharge(ueId string, rg int32) {
-	var reauthorizationDetails []models.ReauthorizationDetails
-
 	self := chf_context.GetSelf()
 	ue, ok := self.ChfUeFindBySupi(ueId)
 	if !ok {
@@ -44,17 +53,31 @@ func (p *Processor) NotifyRecharge(ueId string, rg int32) {
 		return
 	}
 
-	// If it is previosly set to debit mode due to quota exhausted, need to reverse to the reserve mode
-	ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
-	reauthorizationDetails = append(reauthorizationDetails, models.ReauthorizationDetails{
-		RatingGroup: rg,
-	})
+	// Every charging session of the UE using the rating group needs to be reauthorized
+	var notifyUris []string
+	ue.CULock.Lock()
+	for _, session := range ue.ChargingSessions {
+		if !session.FindRatingGroup(rg) {
+			continue
+		}
+		// If it is previosly set to debit mode due to quota exhausted, need to reverse to the reserve mode
+		session.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
+		notifyUris = append(notifyUris, session.NotifyUri)
+	}
+	ue.CULock.Unlock()
 
 	notifyRequest := models.ChargingNotifyRequest{
-		ReauthorizationDetails: reauthorizationDetails,
+		NotificationType: models.ChfConvergedChargingNotificationType_REAUTHORIZATION,
+		ReauthorizationDetails: []models.ReauthorizationDetails{
+			{
+				RatingGroup: rg,
+			},
+		},
 	}
 
-	p.SendChargingNotification(ue.NotifyUri, notifyRequest)
+	for _, notifyUri := range notifyUris {
+		p.SendChargingNotification(notifyUri, notifyRequest)
+	}
 }
 
 func (p *Processor) SendChargingNotification(notifyUri string, notifyRequest models.ChargingNotifyRequest) {
@@ -86,10 +109,7 @@ func (p *Processor) HandleChargingdataInitial(
 		c.JSON(int(problemDetails.Status), problemDetails)
 		return
 	}
-	problemDetails = &models.ProblemDetails{
-		Status: http.StatusForbidden,
-		Cause:  "UNSPECIFIED",
-	}
+	problemDetails = NewChargingError(CauseChargingFailed, "Charging data request is not handled").ProblemDetails()
 	c.JSON(int(problemDetails.Status), problemDetails)
 }
 
@@ -108,10 +128,7 @@ func (p *Processor) HandleChargingdataUpdate(
 		c.JSON(int(problemDetails.Status), problemDetails)
 		return
 	}
-	problemDetails = &models.ProblemDetails{
-		Status: http.StatusForbidden,
-		Cause:  "UNSPECIFIED",
-	}
+	problemDetails = NewChargingError(CauseChargingFailed, "Charging data request is not handled").ProblemDetails()
 	c.JSON(int(problemDetails.Status), problemDetails)
 }
 
@@ -124,7 +141,7 @@ func (p *Processor) HandleChargingdataRelease(
 
 	problemDetails := p.ChargingDataRelease(chargingdata, chargingSessionId)
 	if problemDetails == nil {
-		c.Status(http.StatusBadRequest)
+		c.Status(http.StatusNoContent)
 		return
 	}
 	c.JSON(int(problemDetails.Status), problemDetails)
@@ -149,14 +166,14 @@ func (p *Processor) ChargingDataCreate(
 	ue, err := self.NewCHFUe(ueId)
 	if err != nil {
 		logger.ChargingdataPostLog.Errorf("New CHFUe error %s", err)
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return nil, "", problemDetails
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error(), models.InvalidParam{
+			Param:  "/subscriberIdentifier",
+			Reason: "only IMSI based SUPI is supported",
+		}).ProblemDetails()
 	}
 
 	ue.CULock.Lock()
-	ue.NotifyUri = chargingData.NotifyUri
+	recordGpsi(ue, chargingData)
 
 	consumerId := chargingData.NfConsumerIdentification.NFName
 	if !chargingData.OneTimeEvent {
@@ -166,33 +183,49 @@ func (p *Processor) ChargingDataCreate(
 	if err != nil {
 		// Lock in line 158
 		ue.CULock.Unlock()
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return nil, "", problemDetails
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 	}
 
 	err = p.UpdateCDR(cdr, chargingData)
 	if err != nil {
 		// Lock in line 158
 		ue.CULock.Unlock()
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return nil, "", problemDetails
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 	}
 
 	ue.Cdr[chargingSessionId] = cdr
 	ue.Records = append(ue.Records, ue.Cdr[chargingSessionId])
+
+	if !chargingData.OneTimeEvent {
+		session := ue.NewChargingSession(chargingSessionId)
+		session.NotifyUri = chargingData.NotifyUri
+		session.LastInvocationSequenceNumber = chargingData.InvocationSequenceNumber
+	}
+
+	// Online charging of events: IEC debits the event at once while ECUR reserves units for it
+	failures := unitFailures{}
+	if chargingData.OneTimeEvent && chargingData.OneTimeEventType == models.OneTimeEventType_IEC {
+		responseBody.MultipleUnitInformation = immediateEventCharging(ue, chargingData, failures)
+	} else if !chargingData.OneTimeEvent && isEventChargingWithUnitReservation(chargingData) {
+		responseBody = p.BuildOnlineChargingDataCreateResopone(ue, chargingData, chargingSessionId, failures)
+	}
+	chargingErr := requestChargingError(responseBody.MultipleUnitInformation, failures, chargingData.OneTimeEvent,
+		false)
+	if chargingErr != nil && !chargingData.OneTimeEvent {
+		// No charging data resource is created for a rejected session
+		ue.DeleteChargingSession(chargingSessionId)
+		delete(ue.Cdr, chargingSessionId)
+		ue.Records = ue.Records[:len(ue.Records)-1]
+		ue.CULock.Unlock()
+		logger.ChargingdataPostLog.Warnf("Charging data request of UE %s rejected: %s", ueId, chargingErr)
+		return nil, "", chargingErr.ProblemDetails()
+	}
 	ue.CULock.Unlock()
 
 	if chargingData.OneTimeEvent {
 		err = p.CloseCDR(cdr, false)
 		if err != nil {
-			problemDetails := &models.ProblemDetails{
-				Status: http.StatusBadRequest,
-			}
-			return nil, "", problemDetails
+			return nil, "", NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 		}
 	}
 
@@ -202,6 +235,12 @@ func (p *Processor) ChargingDataCreate(
 		logger.ChargingdataPostLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
 	}
 
+	// The record of a rejected event is still kept
+	if chargingErr != nil {
+		logger.ChargingdataPostLog.Warnf("Charging data request of UE %s rejected: %s", ueId, chargingErr)
+		return nil, "", chargingErr.ProblemDetails()
+	}
+
 	logger.ChargingdataPostLog.Infof("Open CDR for UE %s", ueId)
 
 	// build response
@@ -223,70 +262,56 @@ func (p *Processor) ChargingDataUpdate(
 	ue, ok := self.ChfUeFindBySupi(ueId)
 	if !ok {
 		logger.ChargingdataPostLog.Errorf("CHFUe[%s] not found", ueId)
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return nil, problemDetails
+		return nil, userUnknown(ueId)
 	}
 
 	ue.CULock.Lock()
 	defer ue.CULock.Unlock()
 
-	// Online charging: Rate, Account, Reservation
-	responseBody, partialRecord := p.BuildConvergedChargingDataUpdateResopone(chargingData)
-
-	cdr := ue.Cdr[chargingSessionId]
-
-	if len(ue.Records) > 1 {
-		cdr = ue.Records[len(ue.Records)-1]
+	session, ok := ue.ChargingSessionFind(chargingSessionId)
+	if !ok || session.OfflineOnly {
+		logger.ChargingdataPostLog.Errorf("Charging session[%s] of CHFUe[%s] not found", chargingSessionId, ueId)
+		return nil, chargingDataRefNotFound(chargingSessionId)
 	}
 
-	cdrBytes, errCdrBer := asn.BerMarshalWithParams(&cdr, "explicit,choice")
-	if errCdrBer != nil {
-		logger.ChargingdataPostLog.Error(errCdrBer)
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-			Detail: errCdrBer.Error(),
+	// A retransmitted request is answered with the previous response and not charged again
+	seqNum := chargingData.InvocationSequenceNumber
+	if seqNum != 0 && seqNum == session.LastInvocationSequenceNumber &&
+		(session.LastResponse != nil || session.LastProblem != nil) {
+		logger.ChargingdataPostLog.Warnf("Charging session[%s]: duplicate invocation sequence number %d",
+			chargingSessionId, seqNum)
+		if session.LastProblem != nil {
+			return nil, session.LastProblem
 		}
-		return nil, problemDetails
+		return session.LastResponse, nil
 	}
-
-	var chgDataBytes []byte
-	var errChgDataBer error
-	if len(chargingData.MultipleUnitUsage) != 0 {
-		cdrMultiUnitUsage := cdrConvert.MultiUnitUsageToCdr(chargingData.MultipleUnitUsage)
-		chgDataBytes, errChgDataBer = asn.BerMarshalWithParams(&cdrMultiUnitUsage, "explicit,choice")
-		if errChgDataBer != nil {
-			logger.ChargingdataPostLog.Error(errChgDataBer)
-			problemDetails := &models.ProblemDetails{
-				Status: http.StatusBadRequest,
-				Detail: errChgDataBer.Error(),
-			}
-			return nil, problemDetails
-		}
+	if problemDetails := checkInvocationSequenceNumber(session, seqNum); problemDetails != nil {
+		return nil, problemDetails
 	}
+	session.LastActivity = time.Now()
+	recordGpsi(ue, chargingData)
 
-	if len(cdrBytes)+len(chgDataBytes) > math.MaxUint16 {
-		var newRecord *cdrType.CHFRecord
-		cdrJson, err := json.Marshal(cdr)
-		if err != nil {
-			logger.ChargingdataPostLog.Error(err)
-		}
-		err = json.Unmarshal(cdrJson, &newRecord)
-		if err != nil {
-			logger.ChargingdataPostLog.Error(err)
-		}
-
-		newRecord.ChargingFunctionRecord.ListOfMultipleUnitUsage = []cdrType.MultipleUnitUsage{}
-		cdr = newRecord
-		ue.Records = append(ue.Records, cdr)
+	// Online charging: Rate, Account, Reservation
+	failures := unitFailures{}
+	responseBody, partialRecord := p.BuildConvergedChargingDataUpdateResopone(chargingData, chargingSessionId, failures)
+	// The reported usage is still recorded when the quota cannot be re-authorized
+	chargingErr := requestChargingError(responseBody.MultipleUnitInformation, failures, false, true)
+
+	// The usage is charged from now on, even if the request is rejected or its record fails, so its
+	// retransmission is answered the same and not charged again
+	session.LastInvocationSequenceNumber = seqNum
+	session.LastResponse, session.LastProblem = nil, nil
+	if chargingErr != nil {
+		session.LastProblem = chargingErr.ProblemDetails()
+	} else {
+		timeStamp := time.Now()
+		responseBody.InvocationTimeStamp = &timeStamp
+		responseBody.InvocationSequenceNumber = chargingData.InvocationSequenceNumber
+		session.LastResponse = &responseBody
 	}
 
-	err := p.UpdateCDR(cdr, chargingData)
-	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
+	cdr, problemDetails := p.updateSessionCDR(ue, chargingSessionId, chargingData)
+	if problemDetails != nil {
 		return nil, problemDetails
 	}
 
@@ -297,12 +322,8 @@ func (p *Processor) ChargingDataUpdate(
 		if close_err != nil {
 			logger.ChargingdataPostLog.Error("CloseCDR error:", close_err)
 		}
-		err = dumpCdrFile(ueId, []*cdrType.CHFRecord{cdr})
-		if err != nil {
-			problemDetails := &models.ProblemDetails{
-				Status: http.StatusBadRequest,
-			}
-			return nil, problemDetails
+		if err := dumpCdrFile(ueId, []*cdrType.CHFRecord{cdr}); err != nil {
+			return nil, NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 		}
 
 		_, oper_err := p.OpenCDR(chargingData, ue, chargingSessionId, partialRecord)
@@ -313,12 +334,9 @@ func (p *Processor) ChargingDataUpdate(
 			"CDR Record Sequence Number after Reopen %+v", *cdr.ChargingFunctionRecord.RecordSequenceNumber)
 	}
 
-	err = dumpCdrFile(ueId, ue.Records)
+	err := dumpCdrFile(ueId, ue.Records)
 	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return nil, problemDetails
+		return nil, NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 	}
 
 	err = cgf.SendCDR(chargingData.SubscriberIdentifier)
@@ -326,11 +344,16 @@ func (p *Processor) ChargingDataUpdate(
 		logger.ChargingdataPostLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
 	}
 
-	timeStamp := time.Now()
-	responseBody.InvocationTimeStamp = &timeStamp
-	responseBody.InvocationSequenceNumber = chargingData.InvocationSequenceNumber
+	if chargingErr != nil {
+		logger.ChargingdataPostLog.Warnf("Charging data request of UE %s rejected: %s", ueId, chargingErr)
+		if isAccountUnavailable(chargingErr) {
+			notifyUri := p.abortChargingSession(ue, session, AbortReasonAccountUnavailable)
+			go p.finishAbortCharging(ue.Supi, []string{notifyUri})
+		}
+		return nil, session.LastProblem
+	}
 
-	return &responseBody, nil
+	return session.LastResponse, nil
 }
 
 func (p *Processor) ChargingDataRelease(
@@ -341,54 +364,364 @@ func (p *Processor) ChargingDataRelease(
 	ue, ok := self.ChfUeFindBySupi(ueId)
 	if !ok {
 		logger.ChargingdataPostLog.Errorf("Do not find CHFUe[%s] error", ueId)
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return problemDetails
+		return userUnknown(ueId)
 	}
 
 	ue.CULock.Lock()
 	defer ue.CULock.Unlock()
 
-	sessionChargingReservation(chargingData)
+	session, ok := ue.ChargingSessionFind(chargingSessionId)
+	if !ok || session.OfflineOnly {
+		logger.ChargingdataPostLog.Errorf("Charging session[%s] of CHFUe[%s] not found", chargingSessionId, ueId)
+		return chargingDataRefNotFound(chargingSessionId)
+	}
+
+	// A release is never a retransmission of an update
+	seqNum := chargingData.InvocationSequenceNumber
+	if problemDetails := checkInvocationSequenceNumber(session, seqNum); problemDetails != nil {
+		return problemDetails
+	}
+
+	// Final debit or refund of this charging session only, reservations of other sessions are kept. The usage
+	// a failed release reported is already buffered, its retry does not charge the usage again.
+	for _, rg := range session.RatingGroups {
+		session.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
+	}
+	finalUsage := chargingData
+	if session.Releasing {
+		finalUsage.MultipleUnitUsage = bufferedUsage(session)
+	}
+	failures := unitFailures{}
+	multipleUnitInformation, _ := sessionChargingReservation(finalUsage, chargingSessionId, failures)
+	// Rating groups without final usage report return the whole reservation to the account, those whose final
+	// usage is not charged keep it
+	refundReservedQuota(ue, session, failures)
+	releaseRating(ue, session, failures)
+
+	// The session is kept until the usage of every rating group is charged
+	for _, unitInformation := range multipleUnitInformation {
+		if err, failed := failures[unitInformation.RatingGroup]; failed {
+			session.Releasing = true
+			chargingErr := chargingErrorOf(unitInformation.ResultCode, err, false, false)
+			logger.ChargingdataPostLog.Warnf("Charging session[%s] rating group [%d] not settled: %s",
+				chargingSessionId, unitInformation.RatingGroup, chargingErr)
+			return chargingErr.ProblemDetails()
+		}
+		logger.ChargingdataPostLog.Tracef("Charging session[%s] rating group [%d] settled",
+			chargingSessionId, unitInformation.RatingGroup)
+	}
 
 	cdr := ue.Cdr[chargingSessionId]
 
 	err := p.UpdateCDR(cdr, chargingData)
 	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-			Cause:  err.Error(),
-		}
-		return problemDetails
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 	}
 
 	err = p.CloseCDR(cdr, false)
 	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return problemDetails
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 	}
 
 	err = dumpCdrFile(ueId, []*cdrType.CHFRecord{cdr})
 	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return problemDetails
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
 	}
 
+	// The charging data resource is removed, later requests on it are rejected
+	ue.DeleteChargingSession(chargingSessionId)
+	delete(ue.Cdr, chargingSessionId)
+
 	return nil
 }
 
+// Record the reported usage into the current record of the charging session,
+// a new record is started when the current one would exceed the maximum record size
+func (p *Processor) updateSessionCDR(
+	ue *chf_context.ChfUe, chargingSessionId string, chargingData models.ChfConvergedChargingChargingDataRequest,
+) (*cdrType.CHFRecord, *models.ProblemDetails) {
+	cdr := ue.Cdr[chargingSessionId]
+
+	if len(ue.Records) > 1 {
+		cdr = ue.Records[len(ue.Records)-1]
+	}
+
+	cdrBytes, errCdrBer := asn.BerMarshalWithParams(&cdr, "explicit,choice")
+	if errCdrBer != nil {
+		logger.ChargingdataPostLog.Error(errCdrBer)
+		return nil, NewChargingError(CauseChargingFailed, errCdrBer.Error()).ProblemDetails()
+	}
+
+	var chgDataBytes []byte
+	var errChgDataBer error
+	if len(chargingData.MultipleUnitUsage) != 0 {
+		cdrMultiUnitUsage := cdrConvert.MultiUnitUsageToCdr(chargingData.MultipleUnitUsage)
+		chgDataBytes, errChgDataBer = asn.BerMarshalWithParams(&cdrMultiUnitUsage, "explicit,choice")
+		if errChgDataBer != nil {
+			logger.ChargingdataPostLog.Error(errChgDataBer)
+			return nil, NewChargingError(CauseChargingFailed, errChgDataBer.Error()).ProblemDetails()
+		}
+	}
+
+	if len(cdrBytes)+len(chgDataBytes) > math.MaxUint16 {
+		var newRecord *cdrType.CHFRecord
+		cdrJson, err := json.Marshal(cdr)
+		if err != nil {
+			logger.ChargingdataPostLog.Error(err)
+		}
+		err = json.Unmarshal(cdrJson, &newRecord)
+		if err != nil {
+			logger.ChargingdataPostLog.Error(err)
+		}
+
+		newRecord.ChargingFunctionRecord.ListOfMultipleUnitUsage = []cdrType.MultipleUnitUsage{}
+		cdr = newRecord
+		ue.Records = append(ue.Records, cdr)
+	}
+
+	if err := p.UpdateCDR(cdr, chargingData); err != nil {
+		return nil, NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+	return cdr, nil
+}
+
+// Accumulate the volume reported for the rating group into the policy counters of the subscriber
+func countReportedVolume(supi string, unitUsage models.ChfConvergedChargingMultipleUnitUsage) {
+	var reportedVolume int64
+	for _, usedUnit := range unitUsage.UsedUnitContainer {
+		reportedVolume += int64(usedUnitsOf(usedUnit, charging_datatype.TOTALOCTETS))
+	}
+	chf_context.GetSelf().PolicyCounters.AddUsage(
+		supi, policycounter.TypeVolume, unitUsage.RatingGroup, reportedVolume, time.Now())
+}
+
+// Remaining balance of the account and its currency
+func remainingBalanceOf(acctDebitRsp *charging_datatype.AccountDebitResponse) (money.Money, uint32, bool) {
+	if acctDebitRsp.RemainingBalance == nil || acctDebitRsp.RemainingBalance.UnitValue == nil {
+		return money.Money{}, 0, false
+	}
+	remainingBalance := acctDebitRsp.RemainingBalance
+	return money.FromUnitValue(remainingBalance.UnitValue), uint32(remainingBalance.CurrencyCode), true
+}
+
+func chargingDataRefNotFound(chargingSessionId string) *models.ProblemDetails {
+	return NewChargingError(CauseChargingDataRefNotFound,
+		"Charging data resource "+chargingSessionId+" does not exist").ProblemDetails()
+}
+
+// Consumers numbering their requests shall increase the invocation sequence number of each request,
+// requests of consumers leaving it 0 are not checked
+func checkInvocationSequenceNumber(session *chf_context.ChargingSession, seqNum int32) *models.ProblemDetails {
+	if seqNum == 0 || seqNum > session.LastInvocationSequenceNumber {
+		return nil
+	}
+	logger.ChargingdataPostLog.Warnf("Charging session[%s]: out of order invocation sequence number %d, last %d",
+		session.ChargingDataRef, seqNum, session.LastInvocationSequenceNumber)
+	return NewChargingError(CauseChargingFailed,
+		fmt.Sprintf("Invocation sequence number %d is not after %d", seqNum, session.LastInvocationSequenceNumber),
+		models.InvalidParam{
+			Param:  "/invocationSequenceNumber",
+			Reason: "out of order",
+		}).ProblemDetails()
+}
+
+func userUnknown(ueId string) *models.ProblemDetails {
+	return NewChargingError(CauseUserUnknown, "No charging data of UE "+ueId, models.InvalidParam{
+		Param:  "/subscriberIdentifier",
+		Reason: "subscriber has no charging data resource",
+	}).ProblemDetails()
+}
+
+// Refund the unused reservation of each rating group held by the charging session, except the rating groups
+// whose usage failed to be charged
+func refundReservedQuota(ue *chf_context.ChfUe, session *chf_context.ChargingSession, failures unitFailures) {
+	subscriberIdentifier := buildSubscriptionId(ue.Supi)
+
+	for _, rg := range session.RatingGroups {
+		if _, failed := failures[rg]; failed {
+			continue
+		}
+		// The reservation the ABMF holds is released even if it is used up, it would expire otherwise
+		if !holdsReservation(session, rg) {
+			continue
+		}
+
+		ccr := newAccountDebitRequest(ue, session, rg, subscriberIdentifier)
+		ccr.CcRequestType = charging_datatype.TERMINATION_REQUEST
+		ccr.RequestedAction = charging_datatype.REFUND_ACCOUNT
+		refundedQuota := session.ReservedQuota[rg]
+		if refundedQuota.Sign() < 0 {
+			refundedQuota = money.Money{}
+		}
+		ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
+			RatingGroup: datatype.Unsigned32(rg),
+			RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
+				CCMoney:       refundedQuota.CCMoney(session.Currency(rg)),
+				CCTotalOctets: datatype.Unsigned64(session.ReservedOctets[rg]),
+			},
+		}
+		session.AcctRequestNum[rg]++
+
+		if _, err := sendAccountDebitRequest(ue, ccr); err != nil {
+			logger.ChargingdataPostLog.Errorf("SendAccountDebitRequest err: %+v", err)
+			continue
+		}
+		logger.ChargingdataPostLog.Infof("UE[%s] rating group [%d]: refund unused reservation %s and %d octets",
+			ue.Supi, rg, refundedQuota, session.ReservedOctets[rg])
+		session.ReservedQuota[rg] = money.Money{}
+		delete(session.ReservedOctets, rg)
+		releaseHeld(session, rg)
+	}
+}
+
+// bufferUnchargedUsage keeps the usage of the rating group that could not be charged, the next request of the
+// session charges it along with the usage buffered during suspension
+func bufferUnchargedUsage(
+	session *chf_context.ChargingSession, rg int32,
+	usedUnits, usedUnitsAfterSwitch map[charging_datatype.CCUnitType]uint32,
+) {
+	for _, units := range []map[charging_datatype.CCUnitType]uint32{usedUnits, usedUnitsAfterSwitch} {
+		for unitType, used := range units {
+			session.BufferSuspendedUsage(rg, unitType, used)
+		}
+	}
+}
+
+// bufferedUsage reports no usage of the rating groups holding buffered usage, so that only the buffered usage
+// is charged
+func bufferedUsage(session *chf_context.ChargingSession) []models.ChfConvergedChargingMultipleUnitUsage {
+	var multipleUnitUsage []models.ChfConvergedChargingMultipleUnitUsage
+	for _, rg := range session.RatingGroups {
+		if len(session.SuspendedUsage[rg]) != 0 {
+			multipleUnitUsage = append(multipleUnitUsage, models.ChfConvergedChargingMultipleUnitUsage{RatingGroup: rg})
+		}
+	}
+	return multipleUnitUsage
+}
+
+// usageSinceHeld is the quota and the octets used since the last reservation of the rating group, the part of
+// the units the ABMF holds that is not reserved anymore
+func usageSinceHeld(session *chf_context.ChargingSession, rg int32) (money.Money, uint64) {
+	usedQuota := session.HeldQuota[rg].Sub(session.ReservedQuota[rg])
+	if usedQuota.Sign() < 0 {
+		usedQuota = money.Money{}
+	}
+	heldOctets := session.HeldOctets[rg]
+	return usedQuota, heldOctets - min(session.ReservedOctets[rg], heldOctets)
+}
+
+// holdReservation records the units the ABMF holds after a reservation of the rating group, it holds nothing
+// once neither quota nor octets are left
+func holdReservation(session *chf_context.ChargingSession, rg int32) {
+	if session.ReservedQuota[rg].Sign() <= 0 && session.ReservedOctets[rg] == 0 {
+		releaseHeld(session, rg)
+		return
+	}
+	session.HeldQuota[rg] = session.ReservedQuota[rg]
+	session.HeldOctets[rg] = session.ReservedOctets[rg]
+}
+
+// holdsReservation tells if the ABMF holds a reservation of the rating group for the session, even a used up one
+func holdsReservation(session *chf_context.ChargingSession, rg int32) bool {
+	_, held := session.HeldQuota[rg]
+	return held || session.ReservedQuota[rg].Sign() > 0 || session.ReservedOctets[rg] != 0
+}
+
+func releaseHeld(session *chf_context.ChargingSession, rg int32) {
+	delete(session.HeldQuota, rg)
+	delete(session.HeldOctets, rg)
+}
+
+// Refund the octets granted from the data buckets that the usage of the rating group did not consume
+func refundReservedOctets(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32,
+	subscriberIdentifier *charging_datatype.SubscriptionId,
+) {
+	octets := session.ReservedOctets[rg]
+	delete(session.ReservedOctets, rg)
+	if octets == 0 {
+		return
+	}
+
+	ccr := newAccountDebitRequest(ue, session, rg, subscriberIdentifier)
+	ccr.CcRequestType = charging_datatype.TERMINATION_REQUEST
+	ccr.RequestedAction = charging_datatype.REFUND_ACCOUNT
+	ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
+		RatingGroup: datatype.Unsigned32(rg),
+		RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
+			CCTotalOctets: datatype.Unsigned64(octets),
+		},
+	}
+	session.AcctRequestNum[rg]++
+
+	if _, err := sendAccountDebitRequest(ue, ccr); err != nil {
+		logger.ChargingdataPostLog.Errorf("SendAccountDebitRequest err: %+v", err)
+		return
+	}
+	logger.ChargingdataPostLog.Infof("UE[%s] rating group [%d]: refund %d unused octets", ue.Supi, rg, octets)
+}
+
+// coverReservedOctets deducts the octets used, before then after the tariff switch, from the octets granted
+// from the data buckets, so that only the octets they do not cover are priced
+func coverReservedOctets(
+	session *chf_context.ChargingSession, rg int32,
+	usedUnits, usedUnitsAfterSwitch map[charging_datatype.CCUnitType]uint32,
+) {
+	for _, units := range []map[charging_datatype.CCUnitType]uint32{usedUnits, usedUnitsAfterSwitch} {
+		covered := min(uint64(units[charging_datatype.TOTALOCTETS]), session.ReservedOctets[rg])
+		if covered == 0 {
+			continue
+		}
+		units[charging_datatype.TOTALOCTETS] -= uint32(covered)
+		session.ReservedOctets[rg] -= covered
+	}
+}
+
+// 32.296 6.2.2.3: Release the rating of each rating group of the charging session once its reservation
+// is settled with the ABMF, so that the rating function frees the rating state of the session. The rating
+// groups whose usage failed to be charged are still rated for charging it.
+func releaseRating(ue *chf_context.ChfUe, session *chf_context.ChargingSession, failures unitFailures) {
+	self := chf_context.GetSelf()
+	subscriberIdentifier := buildSubscriptionId(ue.Supi)
+
+	for _, rg := range session.RatingGroups {
+		unitCost, rated := session.UnitCost[rg]
+		if _, failed := failures[rg]; !rated || failed {
+			continue
+		}
+
+		sur := &charging_datatype.ServiceUsageRequest{
+			SessionId:      datatype.UTF8String(strconv.Itoa(int(ue.RateSessionId))),
+			OriginHost:     datatype.DiameterIdentity(self.RatingCfg.OriginHost),
+			OriginRealm:    datatype.DiameterIdentity(self.RatingCfg.OriginRealm),
+			ActualTime:     datatype.Time(time.Now()),
+			SubscriptionId: subscriberIdentifier,
+			UserName:       datatype.OctetString(self.Name),
+		}
+		if ratingTime, ok := session.RatingTime[rg]; ok {
+			sur.ActualTime = datatype.Time(ratingTime)
+		}
+		for unitType := range unitCost {
+			sur.ServiceRating = &charging_datatype.ServiceRating{
+				ServiceIdentifier: datatype.Unsigned32(rg),
+				CCUnitType:        unitType,
+				RequestSubType:    charging_datatype.REQ_SUBTYPE_RELEASE,
+				Counter:           ratingCountersOf(ue, session, rg),
+			}
+			if _, err := sendServiceUsageRequest(ue, sur); err != nil {
+				logger.ChargingdataPostLog.Errorf("UE[%s] rating group [%d]: release rating err: %+v", ue.Supi, rg, err)
+			}
+		}
+	}
+}
+
 func (p *Processor) BuildOnlineChargingDataCreateResopone(
-	ue *chf_context.ChfUe, chargingData models.ChfConvergedChargingChargingDataRequest,
+	ue *chf_context.ChfUe, chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string,
+	failures unitFailures,
 ) models.ChfConvergedChargingChargingDataResponse {
 	logger.ChargingdataPostLog.Info("In Build Online Charging Data Create Resopone")
-	ue.NotifyUri = chargingData.NotifyUri
 
-	multipleUnitInformation, _ := sessionChargingReservation(chargingData)
+	multipleUnitInformation, _ := sessionChargingReservation(chargingData, chargingSessionId, failures)
 
 	responseBody := models.ChfConvergedChargingChargingDataResponse{
 		MultipleUnitInformation: multipleUnitInformation,
@@ -398,13 +731,13 @@ func (p *Processor) BuildOnlineChargingDataCreateResopone(
 }
 
 func (p *Processor) BuildConvergedChargingDataUpdateResopone(
-	chargingData models.ChfConvergedChargingChargingDataRequest,
+	chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string, failures unitFailures,
 ) (models.ChfConvergedChargingChargingDataResponse, bool) {
 	var partialRecord bool
 
 	logger.ChargingdataPostLog.Info("In BuildConvergedChargingDataUpdateResopone")
 
-	multipleUnitInformation, partialRecord := sessionChargingReservation(chargingData)
+	multipleUnitInformation, partialRecord := sessionChargingReservation(chargingData, chargingSessionId, failures)
 
 	responseBody := models.ChfConvergedChargingChargingDataResponse{
 		MultipleUnitInformation: multipleUnitInformation,
@@ -413,45 +746,249 @@ func (p *Processor) BuildConvergedChargingDataUpdateResopone(
 	return responseBody, partialRecord
 }
 
-func getUnitCost(ue *chf_context.ChfUe, rg int32, sur *charging_datatype.ServiceUsageRequest) uint32 {
+// Rate the tariff of the rating group and save it in the session for pricing the usage,
+// together with the tariff applying after the tariff switch if any. The tariffs are reused
+// from the cache while the rating function tells they are valid.
+func rateTariff(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32, serviceContext string,
+	sur *charging_datatype.ServiceUsageRequest,
+) {
+	defaultUnitCost := map[charging_datatype.CCUnitType]money.Money{
+		charging_datatype.TOTALOCTETS: money.FromInt(1),
+	}
+	session.ClearRating(rg)
+	session.UnitCost[rg] = defaultUnitCost
+
 	if sur == nil {
 		logger.ChargingdataPostLog.Errorln("ServiceUsageRequest is nil, set unitCost to 1")
-		return 1
+		return
 	}
 
+	tariffCache := chf_context.GetSelf().TariffCache
+	key := tariffcache.Key{Supi: ue.Supi, RatingGroup: rg, Context: serviceContext}
+	if tariff, ok := tariffCache.Get(key, time.Now()); ok {
+		saveTariff(session, rg, tariff)
+		return
+	}
+
+	// The tariff of a counted unit type is the tier of its counter
 	sur.ServiceRating = &charging_datatype.ServiceRating{
 		ServiceIdentifier: datatype.Unsigned32(rg),
-		MonetaryQuota:     datatype.Unsigned32(0), // dummy
+		CCUnitType:        charging_datatype.TOTALOCTETS,
 		RequestSubType:    charging_datatype.REQ_SUBTYPE_RESERVE,
+		Counter:           allRatingCounters(ue),
 	}
 
-	serviceUsageRsp, err := rating.SendServiceUsageRequest(ue, sur)
+	serviceUsageRsp, err := sendServiceUsageRequest(ue, sur)
 	if err != nil {
 		logger.ChargingdataPostLog.Errorf("err: %+v", err)
 		logger.ChargingdataPostLog.Errorln("cannot get unitCost by SendServiceUsageRequest, set unitCost to 1")
-		return 1
+		return
+	}
+	if serviceUsageRsp.ServiceRating == nil || serviceUsageRsp.ServiceRating.MonetaryTariff == nil {
+		logger.ChargingdataPostLog.Errorln("no MonetaryTariff in ServiceUsageResponse, set unitCost to 1")
+		return
 	}
 
-	return uint32(serviceUsageRsp.ServiceRating.MonetaryTariff.RateElement.UnitCost.ValueDigits) *
-		uint32(math.Pow10(int(serviceUsageRsp.ServiceRating.MonetaryTariff.RateElement.UnitCost.Exponent)))
+	tariff := tariffOf(serviceUsageRsp.ServiceRating, time.Time(sur.ActualTime))
+	saveTariff(session, rg, tariff)
+	tariffCache.Put(key, tariff, time.Now())
 }
 
-// 32.296 6.2.2.3.1: Service usage request method with reservation
-func sessionChargingReservation(
-	chargingData models.ChfConvergedChargingChargingDataRequest,
-) ([]models.MultipleUnitInformation, bool) {
-	var multipleUnitInformation []models.MultipleUnitInformation
-	var partialRecord bool
-	var subscriberIdentifier *charging_datatype.SubscriptionId
+// The tariff answered by the rating function for the rating time
+func tariffOf(serviceRating *charging_datatype.ServiceRating, ratingTime time.Time) tariffcache.Tariff {
+	tariff := tariffcache.Tariff{
+		UnitCost:      unitCostOf(serviceRating.MonetaryTariff.RateElement),
+		CurrencyCode:  uint32(serviceRating.MonetaryTariff.CurrencyCode),
+		RatingTime:    ratingTime,
+		ValidUnits:    uint64(serviceRating.ValidUnits),
+		ValidUnitType: serviceRating.CCUnitType,
+	}
+	if serviceRating.RequestedCounters != nil {
+		for _, counterId := range serviceRating.RequestedCounters.CounterId {
+			tariff.RequestedCounters = append(tariff.RequestedCounters, string(counterId))
+		}
+	}
+	if serviceRating.NextMonetaryTariff != nil && serviceRating.TariffSwitchTime != 0 {
+		tariff.NextUnitCost = unitCostOf(serviceRating.NextMonetaryTariff.RateElement)
+		tariff.TariffSwitch = ratingTime.Add(time.Duration(serviceRating.TariffSwitchTime) * time.Second)
+	}
+	if serviceRating.ExpiryTime != nil {
+		tariff.ExpiryTime = time.Time(*serviceRating.ExpiryTime)
+	}
+	return tariff
+}
 
-	self := chf_context.GetSelf()
-	supi := chargingData.SubscriberIdentifier
+func saveTariff(session *chf_context.ChargingSession, rg int32, tariff tariffcache.Tariff) {
+	session.UnitCost[rg] = tariff.UnitCost
+	if tariff.CurrencyCode != 0 {
+		session.CurrencyCode[rg] = tariff.CurrencyCode
+	}
+	if len(tariff.RequestedCounters) != 0 {
+		session.RequestedCounters[rg] = tariff.RequestedCounters
+	}
+	session.RatingTime[rg] = tariff.RatingTime
+	if !tariff.TariffSwitch.IsZero() {
+		session.NextUnitCost[rg] = tariff.NextUnitCost
+		session.TariffSwitch[rg] = tariff.TariffSwitch
+	}
+}
 
-	ue, ok := self.ChfUeFindBySupi(supi)
+// Count the units priced with the cached tariff of the rating group, its valid units may be used up
+func consumeTariff(
+	ue *chf_context.ChfUe, rg int32, serviceContext string,
+	usedUnits, usedUnitsAfterSwitch map[charging_datatype.CCUnitType]uint32,
+) {
+	key := tariffcache.Key{Supi: ue.Supi, RatingGroup: rg, Context: serviceContext}
+	for _, unitType := range chargedUnitTypes {
+		units := uint64(usedUnits[unitType]) + uint64(usedUnitsAfterSwitch[unitType])
+		chf_context.GetSelf().TariffCache.Consume(key, unitType, units)
+	}
+}
+
+// One rate element for each unit type the rating group is charged by
+func unitCostOf(rateElements []*charging_datatype.RateElement) map[charging_datatype.CCUnitType]money.Money {
+	unitCost := make(map[charging_datatype.CCUnitType]money.Money)
+	for _, rateElement := range rateElements {
+		if rateElement.UnitCost == nil {
+			continue
+		}
+		unitCost[rateElement.CCUnitType] = money.FromUnitCost(rateElement.UnitCost)
+	}
+	return unitCost
+}
+
+// Price the used units with the saved tariffs, the units used after the tariff switch with the next tariff
+func usageQuota(
+	session *chf_context.ChargingSession, rg int32,
+	usedUnits, usedUnitsAfterSwitch map[charging_datatype.CCUnitType]uint32,
+) money.Money {
+	var quota money.Money
+	for unitType, unitCost := range session.UnitCost[rg] {
+		quota = quota.Add(unitCost.Mul(uint64(usedUnits[unitType])))
+	}
+	nextUnitCost, ok := session.NextUnitCost[rg]
 	if !ok {
-		logger.ChargingdataPostLog.Warnf("Do not find UE[%s]", supi)
-		return nil, false
+		nextUnitCost = session.UnitCost[rg]
+	}
+	for unitType, unitCost := range nextUnitCost {
+		quota = quota.Add(unitCost.Mul(uint64(usedUnitsAfterSwitch[unitType])))
+	}
+	return quota
+}
+
+// Counter AVPs of the counters the rating group is priced with
+func ratingCountersOf(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32,
+) []*charging_datatype.Counter {
+	counterIds := session.RequestedCounters[rg]
+	if len(counterIds) == 0 {
+		return nil
+	}
+	loadRatingCounters(ue)
+	return ue.RatingCounterAvps(counterIds, time.Now())
+}
+
+// Every rating counter of the UE, for ratings whose counters are not known yet
+func allRatingCounters(ue *chf_context.ChfUe) []*charging_datatype.Counter {
+	loadRatingCounters(ue)
+	counterIds := make([]string, 0, len(ue.RatingCounters))
+	for counterId := range ue.RatingCounters {
+		counterIds = append(counterIds, counterId)
+	}
+	return ue.RatingCounterAvps(counterIds, time.Now())
+}
+
+// Rating counters persisted by the ABMF, replaced in tests
+var loadPersistedCounters = ratingcounter.Load
+
+// The rating counters persisted by the ABMF are loaded once, the ABMF answers them afterwards
+func loadRatingCounters(ue *chf_context.ChfUe) {
+	if ue.RatingCountersLoaded {
+		return
 	}
+	counters, err := loadPersistedCounters(ue.Supi)
+	if err != nil {
+		logger.ChargingdataPostLog.Errorf("UE[%s] load rating counters error: %+v", ue.Supi, err)
+		return
+	}
+	ue.RestoreRatingCounters(counters)
+}
+
+// Split the units of the container at the tariff switch. A container reported across the switch,
+// which the NF consumer should have closed at the switch, is split in proportion to its usage time.
+func splitUsedUnits(
+	usedUnit models.ChfConvergedChargingUsedUnitContainer, unitType charging_datatype.CCUnitType,
+	tariffSwitch time.Time,
+) (uint32, uint32) {
+	units := usedUnitsOf(usedUnit, unitType)
+	if tariffSwitch.IsZero() {
+		return units, 0
+	}
+
+	var firstUsage, lastUsage *time.Time
+	if usedUnit.PDUContainerInformation != nil {
+		firstUsage = usedUnit.PDUContainerInformation.TimeofFirstUsage
+		lastUsage = usedUnit.PDUContainerInformation.TimeofLastUsage
+	}
+	if lastUsage == nil {
+		lastUsage = usedUnit.TriggerTimestamp
+	}
+
+	switch {
+	case lastUsage == nil || !lastUsage.After(tariffSwitch):
+		return units, 0
+	case firstUsage == nil || !firstUsage.Before(tariffSwitch):
+		return 0, units
+	}
+	after := uint32(uint64(units) * uint64(lastUsage.Sub(tariffSwitch)) / uint64(lastUsage.Sub(*firstUsage)))
+	return units - after, after
+}
+
+func usedUnitsOf(
+	usedUnit models.ChfConvergedChargingUsedUnitContainer, unitType charging_datatype.CCUnitType,
+) uint32 {
+	switch unitType {
+	case charging_datatype.TIME:
+		return uint32(usedUnit.Time)
+	case charging_datatype.TOTALOCTETS:
+		return uint32(usedUnit.TotalVolume)
+	case charging_datatype.SERVICESPECIFICUNITS:
+		return uint32(usedUnit.ServiceSpecificUnits)
+	}
+	return 0
+}
+
+func requestedUnitsOf(requestedUnit *models.RequestedUnit, unitType charging_datatype.CCUnitType) uint32 {
+	if requestedUnit == nil {
+		return 0
+	}
+	switch unitType {
+	case charging_datatype.TIME:
+		return uint32(requestedUnit.Time)
+	case charging_datatype.TOTALOCTETS:
+		return uint32(requestedUnit.TotalVolume)
+	case charging_datatype.SERVICESPECIFICUNITS:
+		return uint32(requestedUnit.ServiceSpecificUnits)
+	}
+	return 0
+}
+
+func setGrantedUnits(grantedUnit *models.GrantedUnit, unitType charging_datatype.CCUnitType, units uint32) {
+	switch unitType {
+	case charging_datatype.TIME:
+		grantedUnit.Time = int32(units)
+	case charging_datatype.TOTALOCTETS:
+		grantedUnit.TotalVolume = int32(units)
+		grantedUnit.DownlinkVolume = int32(units)
+		grantedUnit.UplinkVolume = int32(units)
+	case charging_datatype.SERVICESPECIFICUNITS:
+		grantedUnit.ServiceSpecificUnits = int32(units)
+	}
+}
+
+func buildSubscriptionId(supi string) *charging_datatype.SubscriptionId {
+	var subscriberIdentifier *charging_datatype.SubscriptionId
 
 	supiType := strings.Split(supi, "-")[0]
 	switch supiType {
@@ -477,16 +1014,92 @@ func sessionChargingReservation(
 		}
 	}
 
+	return subscriberIdentifier
+}
+
+func newAccountDebitRequest(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32,
+	subscriberIdentifier *charging_datatype.SubscriptionId,
+) *charging_datatype.AccountDebitRequest {
+	self := chf_context.GetSelf()
+
+	return &charging_datatype.AccountDebitRequest{
+		SessionId:       datatype.UTF8String(accountSessionId(ue, session)),
+		OriginHost:      datatype.DiameterIdentity(self.AbmfCfg.OriginHost),
+		OriginRealm:     datatype.DiameterIdentity(self.AbmfCfg.OriginRealm),
+		EventTimestamp:  datatype.Time(time.Now()),
+		SubscriptionId:  subscriberIdentifier,
+		UserName:        datatype.OctetString(self.Name),
+		CcRequestNumber: datatype.Unsigned32(session.AcctRequestNum[rg]),
+		SubscriptionIds: gpsiSubscriptionIds(ue),
+	}
+}
+
+// accountSessionId identifies the charging session to the ABMF, which holds a reservation per session
+func accountSessionId(ue *chf_context.ChfUe, session *chf_context.ChargingSession) string {
+	sessionId := strconv.Itoa(int(ue.AcctSessionId))
+	if session.ChargingDataRef != "" {
+		sessionId += ";" + session.ChargingDataRef
+	}
+	return sessionId
+}
+
+// The ABMF finds the shared account of a member identified by its GPSI
+func gpsiSubscriptionIds(ue *chf_context.ChfUe) []*charging_datatype.SubscriptionId {
+	msisdn, ok := strings.CutPrefix(ue.Gpsi, "msisdn-")
+	if !ok {
+		return nil
+	}
+	return []*charging_datatype.SubscriptionId{{
+		SubscriptionIdType: charging_datatype.END_USER_E164,
+		SubscriptionIdData: datatype.UTF8String(msisdn),
+	}}
+}
+
+// recordGpsi keeps the GPSI served to the UE, the SMF reports it in the user information
+func recordGpsi(ue *chf_context.ChfUe, chargingData models.ChfConvergedChargingChargingDataRequest) {
+	if pduSessionInfo := chargingData.PDUSessionChargingInformation; pduSessionInfo != nil &&
+		pduSessionInfo.UserInformation != nil && pduSessionInfo.UserInformation.ServedGPSI != "" {
+		ue.Gpsi = pduSessionInfo.UserInformation.ServedGPSI
+	}
+}
+
+// 32.296 6.2.2.3.1: Service usage request method with reservation
+// The errors of the rating groups that cannot be charged are added to failures.
+func sessionChargingReservation(
+	chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string, failures unitFailures,
+) ([]models.MultipleUnitInformation, bool) {
+	var multipleUnitInformation []models.MultipleUnitInformation
+	var partialRecord bool
+
+	self := chf_context.GetSelf()
+	supi := chargingData.SubscriberIdentifier
+
+	ue, ok := self.ChfUeFindBySupi(supi)
+	if !ok {
+		logger.ChargingdataPostLog.Warnf("Do not find UE[%s]", supi)
+		return nil, false
+	}
+	session, ok := ue.ChargingSessionFind(chargingSessionId)
+	if !ok {
+		logger.ChargingdataPostLog.Warnf("Do not find charging session[%s] of UE[%s]", chargingSessionId, supi)
+		return nil, false
+	}
+
+	subscriberIdentifier := buildSubscriptionId(supi)
+
 	for unitUsageNum, unitUsage := range chargingData.MultipleUnitUsage {
-		var totalUsedUnit uint32
 		var finalUnitIndication models.FinalUnitIndication
 		creditControl := false
+		quotaManagement := chf_context.QuotaManagementOnline
+		totalUsedUnit := make(map[charging_datatype.CCUnitType]uint32)
+		totalUsedUnitAfterSwitch := make(map[charging_datatype.CCUnitType]uint32)
 
 		rg := unitUsage.RatingGroup
-		if !ue.FindRatingGroup(rg) {
-			ue.RatingGroups = append(ue.RatingGroups, rg)
-			ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
-		}
+		session.AddRatingGroup(rg)
+
+		// The reported usage accumulates into the policy counters whatever the quota management
+		countReportedVolume(supi, unitUsage)
 
 		unitInformation := models.MultipleUnitInformation{
 			UPFID:               unitUsage.UPFID,
@@ -497,16 +1110,10 @@ func sessionChargingReservation(
 		for _, usedUnit := range unitUsage.UsedUnitContainer {
 			switch usedUnit.QuotaManagementIndicator {
 			case models.QuotaManagementIndicator_OFFLINE_CHARGING:
-				unitInformation.Triggers = append(unitInformation.Triggers,
-					models.ChfConvergedChargingTrigger{
-						TriggerType:     models.ChfConvergedChargingTriggerType_QUOTA_THRESHOLD,
-						TriggerCategory: models.TriggerCategory_IMMEDIATE_REPORT,
-					},
-				)
-
-				unitInformation.VolumeQuotaThreshold = int32(30000000)
+				quotaManagement = chf_context.QuotaManagementOffline
 				continue
 			case models.QuotaManagementIndicator_ONLINE_CHARGING:
+				quotaManagement = chf_context.QuotaManagementOnline
 				creditControl = true
 
 				for _, trigger := range chargingData.Triggers {
@@ -520,31 +1127,70 @@ func sessionChargingReservation(
 					case t.TriggerType == models.ChfConvergedChargingTriggerType_MAX_NUMBER_OF_CHANGES_IN_CHARGING_CONDITIONS:
 					case t.TriggerType == models.ChfConvergedChargingTriggerType_MANAGEMENT_INTERVENTION:
 					case t.TriggerType == models.ChfConvergedChargingTriggerType_FINAL:
-						ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
+						session.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
 						partialRecord = false
 					}
 				}
-				// calculate total used unit
-				totalUsedUnit += uint32(usedUnit.TotalVolume)
+				// calculate total used unit, before and after the tariff switch
+				for _, unitType := range chargedUnitTypes {
+					before, after := splitUsedUnits(usedUnit, unitType, session.TariffSwitch[rg])
+					totalUsedUnit[unitType] += before
+					totalUsedUnitAfterSwitch[unitType] += after
+				}
 			case models.QuotaManagementIndicator_QUOTA_MANAGEMENT_SUSPENDED:
-				logger.ChargingdataPostLog.Errorf("Current do not support QUOTA MANAGEMENT SUSPENDED")
+				// The usage is charged once quota management resumes
+				quotaManagement = chf_context.QuotaManagementSuspended
+				for _, unitType := range chargedUnitTypes {
+					session.BufferSuspendedUsage(rg, unitType, usedUnitsOf(usedUnit, unitType))
+				}
+			}
+		}
+		if len(unitUsage.UsedUnitContainer) != 0 {
+			previous := session.SetQuotaManagement(rg, quotaManagement)
+			if previous != quotaManagement {
+				logger.ChargingdataPostLog.Infof("Rating group [%d] quota management: %s -> %s",
+					rg, previous, quotaManagement)
+			}
+		}
+
+		// Debit the usage buffered during suspension when quota management resumes or the usage is final
+		if creditControl || session.RatingType[rg] == charging_datatype.REQ_SUBTYPE_DEBIT {
+			if suspendedUsage := session.TakeSuspendedUsage(rg); len(suspendedUsage) != 0 {
+				logger.ChargingdataPostLog.Infof("Rating group [%d]: charge usage reported during suspension %+v",
+					rg, suspendedUsage)
+				for unitType, units := range suspendedUsage {
+					totalUsedUnit[unitType] += units
+				}
+				creditControl = true
 			}
 		}
+
+		if !creditControl && session.QuotaManagement[rg] == chf_context.QuotaManagementOffline {
+			// Offline charging only needs the usage to be reported at the thresholds
+			unitInformation.Triggers = append(unitInformation.Triggers,
+				models.ChfConvergedChargingTrigger{
+					TriggerType:     models.ChfConvergedChargingTriggerType_QUOTA_THRESHOLD,
+					TriggerCategory: models.TriggerCategory_IMMEDIATE_REPORT,
+				},
+			)
+			unitInformation.VolumeQuotaThreshold = ue.OfflineVolumeThreshold
+			unitInformation.TimeQuotaThreshold = ue.OfflineTimeThreshold
+			unitInformation.ResultCode = models.ChfConvergedChargingResultCode_SUCCESS
+			multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+			continue
+		}
+		// Event charging with unit reservation: the initial request only carries the requested units
+		if len(unitUsage.UsedUnitContainer) == 0 && requestedUnitsOf(unitUsage.RequestedUnit,
+			charging_datatype.SERVICESPECIFICUNITS) != 0 {
+			creditControl = true
+		}
 		if !creditControl {
 			logger.ChargingdataPostLog.Infof("Credit Control are not required for rating group: %d", rg)
 			continue
 		}
 		// Only online charging with request unit or used unit need to perform credit control
 
-		ccr := &charging_datatype.AccountDebitRequest{
-			SessionId:       datatype.UTF8String(strconv.Itoa(int(ue.AcctSessionId))),
-			OriginHost:      datatype.DiameterIdentity(self.AbmfCfg.OriginHost),
-			OriginRealm:     datatype.DiameterIdentity(self.AbmfCfg.OriginRealm),
-			EventTimestamp:  datatype.Time(time.Now()),
-			SubscriptionId:  subscriberIdentifier,
-			UserName:        datatype.OctetString(self.Name),
-			CcRequestNumber: datatype.Unsigned32(ue.AcctRequestNum[rg]),
-		}
+		ccr := newAccountDebitRequest(ue, session, rg, subscriberIdentifier)
 
 		sur := &charging_datatype.ServiceUsageRequest{
 			SessionId:      datatype.UTF8String(strconv.Itoa(int(ue.RateSessionId))),
@@ -555,35 +1201,95 @@ func sessionChargingReservation(
 			UserName:       datatype.OctetString(self.Name),
 		}
 
-		switch ue.RatingType[rg] {
-		case charging_datatype.REQ_SUBTYPE_RESERVE:
-			var requestedQuota uint64
-
-			ue.UnitCost[rg] = getUnitCost(ue, rg, sur)
+		consumeTariff(ue, rg, chargingData.ServiceSpecificationInfo, totalUsedUnit, totalUsedUnitAfterSwitch)
+		coverReservedOctets(session, rg, totalUsedUnit, totalUsedUnitAfterSwitch)
 
-			usedQuota := uint64(totalUsedUnit * ue.UnitCost[rg])
-			requestedQuota = uint64(uint32(unitUsage.RequestedUnit.TotalVolume) * ue.UnitCost[rg])
-			ue.ReservedQuota[rg] -= int64(usedQuota)
-			NeedReserveQuota := ue.ReservedQuota[rg] <= 0
+		switch session.RatingType[rg] {
+		case charging_datatype.REQ_SUBTYPE_RESERVE:
+			var requestedQuota money.Money
 
-			if NeedReserveQuota {
-				reserveQuota := -uint64(ue.ReservedQuota[rg]) + requestedQuota
+			// The usage is priced with the tariffs saved when its units were granted
+			if len(session.UnitCost[rg]) == 0 {
+				rateTariff(ue, session, rg, chargingData.ServiceSpecificationInfo, sur)
+			}
+			usedQuota := usageQuota(session, rg, totalUsedUnit, totalUsedUnitAfterSwitch)
+			// The rating function prices the usage counted by tiers
+			if len(session.RequestedCounters[rg]) != 0 {
+				price, err := debitPrice(ue, session, rg, sur, totalUsedUnit, totalUsedUnitAfterSwitch)
+				if err != nil {
+					logger.ChargingdataPostLog.Errorf("SendServiceUsageRequest err: %+v", err)
+					failures.fail(&unitInformation, err)
+					multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+					session.AcctRequestNum[rg]++
+					continue
+				}
+				usedQuota = price
+			}
+			for unitType, unitCost := range session.UnitCost[rg] {
+				requestedQuota = requestedQuota.Add(
+					unitCost.Mul(uint64(requestedUnitsOf(unitUsage.RequestedUnit, unitType))))
+			}
+			session.ReservedQuota[rg] = session.ReservedQuota[rg].Sub(usedQuota)
+			session.UpdateConsumptionRate(rg, usedQuota, time.Now())
+			NeedReserveQuota := session.ReservedQuota[rg].Sign() <= 0
+			// The octets are requested from the data buckets on the first reservation, then again once the
+			// octets granted are used up as long as the account grants octets
+			requestedOctets := uint64(requestedUnitsOf(unitUsage.RequestedUnit, charging_datatype.TOTALOCTETS))
+			reservedOctets, dataBucket := session.ReservedOctets[rg]
+			needReserveOctets := dataBucket && reservedOctets < requestedOctets
+
+			if NeedReserveQuota || needReserveOctets {
+				var reserveQuota money.Money
+				if NeedReserveQuota {
+					balance, known := ue.RemainingBalance[rg]
+					if !known {
+						balance = money.FromInt(-1)
+					}
+					// The quota consumed beyond the reservation is always deducted
+					reserveQuota = session.ReservedQuota[rg].Neg().Add(self.ReservationPolicy.ReserveQuota(
+						reservation.Request{
+							RatingGroup:     rg,
+							RequestedQuota:  requestedQuota,
+							Balance:         balance,
+							ConsumptionRate: session.ConsumptionRate[rg],
+						}))
+				}
+				// The usage since the last reservation is settled against the units the ABMF holds
+				usedQuota, usedOctets := usageSinceHeld(session, rg)
 				ccr.CcRequestType = charging_datatype.UPDATE_REQUEST
 				ccr.RequestedAction = charging_datatype.DIRECT_DEBITING
 				ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
 					RatingGroup: datatype.Unsigned32(rg),
 					RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(reserveQuota),
+						CCMoney:       reserveQuota.CCMoney(session.Currency(rg)),
+						CCTotalOctets: datatype.Unsigned64(requestedOctets - min(reservedOctets, requestedOctets)),
+					},
+					UsedServiceUnit: &charging_datatype.UsedServiceUnit{
+						CCMoney:       usedQuota.CCMoney(session.Currency(rg)),
+						CCTotalOctets: datatype.Unsigned64(usedOctets),
 					},
 				}
 
-				acctDebitRsp, err := abmf.SendAccountDebitRequest(ue, ccr)
+				acctDebitRsp, err := sendAccountDebitRequest(ue, ccr)
 				if err != nil {
 					logger.ChargingdataPostLog.Errorf("SendAccountDebitRequest err: %+v", err)
+					failures.fail(&unitInformation, err)
+					multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+					session.AcctRequestNum[rg]++
 					continue
 				}
 
-				ue.ReservedQuota[rg] += int64(acctDebitRsp.MultipleServicesCreditControl.GrantedServiceUnit.CCTotalOctets)
+				grantedServiceUnit := acctDebitRsp.MultipleServicesCreditControl.GrantedServiceUnit
+				grantedQuota, _ := money.FromCCMoney(grantedServiceUnit.CCMoney)
+				session.ReservedQuota[rg] = session.ReservedQuota[rg].Add(grantedQuota)
+				if grantedOctets := uint64(grantedServiceUnit.CCTotalOctets); grantedOctets != 0 {
+					session.ReservedOctets[rg] = reservedOctets + grantedOctets
+				} else if reservedOctets == 0 {
+					// The data buckets are used up
+					delete(session.ReservedOctets, rg)
+				}
+				holdReservation(session, rg)
+				recordRemainingBalance(ue, session, rg, acctDebitRsp, &unitInformation)
 
 				// Deduct the reserved quota from the account
 				if acctDebitRsp.MultipleServicesCreditControl.FinalUnitIndication != nil {
@@ -593,29 +1299,33 @@ func sessionChargingReservation(
 						finalUnitIndication = models.FinalUnitIndication{
 							FinalUnitAction: models.FinalUnitAction_TERMINATE,
 						}
-						ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
+						session.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
 					}
 				}
 			}
 
-			sur.ServiceRating = &charging_datatype.ServiceRating{
-				ServiceIdentifier: datatype.Unsigned32(rg),
-				MonetaryQuota:     datatype.Unsigned32(requestedQuota),
-				RequestSubType:    charging_datatype.REQ_SUBTYPE_RESERVE,
-			}
-
-			// Retrieve and save the tarrif for pricing the next usage
-			serviceUsageRsp, err := rating.SendServiceUsageRequest(ue, sur)
+			// Retrieve the allowed units of each unit type the rating group is charged by
+			grantedUnit, err := reserveGrantedUnits(ue, session, rg, sur, unitUsage.RequestedUnit)
 			if err != nil {
 				logger.ChargingdataPostLog.Errorf("SendServiceUsageRequest err: %+v", err)
+				failures.fail(&unitInformation, err)
+				multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+				session.AcctRequestNum[rg]++
 				continue
 			}
 
-			ue.UnitCost[rg] = getUnitCost(ue, rg, sur)
+			// The octets granted from the data buckets are granted on top of the octets the quota pays for
+			if reservedOctets := session.ReservedOctets[rg]; reservedOctets != 0 {
+				grantedUnit.TotalVolume = int32(min(uint64(grantedUnit.TotalVolume)+reservedOctets, math.MaxInt32))
+			}
 
-			grantedUnit := min(uint32(serviceUsageRsp.ServiceRating.AllowedUnits), uint32(unitUsage.RequestedUnit.TotalVolume))
+			// Retrieve and save the tarrif for pricing the next usage
+			rateTariff(ue, session, rg, chargingData.ServiceSpecificationInfo, sur)
+			if tariffSwitch, ok := session.TariffSwitch[rg]; ok {
+				grantedUnit.TariffTimeChange = &tariffSwitch
+			}
 
-			if ue.RatingType[rg] == charging_datatype.REQ_SUBTYPE_RESERVE {
+			if session.RatingType[rg] == charging_datatype.REQ_SUBTYPE_RESERVE {
 				unitInformation.Triggers = append(unitInformation.Triggers,
 					models.ChfConvergedChargingTrigger{
 						TriggerType:     models.ChfConvergedChargingTriggerType_QUOTA_THRESHOLD,
@@ -623,7 +1333,8 @@ func sessionChargingReservation(
 					},
 				)
 
-				unitInformation.VolumeQuotaThreshold = int32(float32(grantedUnit) * ue.VolumeThresholdRate)
+				unitInformation.VolumeQuotaThreshold = int32(float32(grantedUnit.TotalVolume) * ue.VolumeThresholdRate)
+				unitInformation.TimeQuotaThreshold = int32(float32(grantedUnit.Time) * ue.TimeThresholdRate)
 			}
 
 			unitInformation.Triggers = append(unitInformation.Triggers,
@@ -633,12 +1344,9 @@ func sessionChargingReservation(
 				},
 			)
 
-			unitInformation.GrantedUnit = &models.GrantedUnit{
-				TotalVolume:    int32(grantedUnit),
-				DownlinkVolume: int32(grantedUnit),
-				UplinkVolume:   int32(grantedUnit),
-			}
-			logger.ChargingdataPostLog.Tracef("granted Unit: %d", unitInformation.GrantedUnit.TotalVolume)
+			unitInformation.GrantedUnit = grantedUnit
+			logger.ChargingdataPostLog.Tracef("granted Unit: volume %d, time %d",
+				unitInformation.GrantedUnit.TotalVolume, unitInformation.GrantedUnit.Time)
 
 			// The timer of VolumeLimit is remain in SMF
 			if ue.VolumeLimit != 0 {
@@ -676,56 +1384,71 @@ func sessionChargingReservation(
 		case charging_datatype.REQ_SUBTYPE_DEBIT:
 			logger.ChargingdataPostLog.Info("Debit mode, will not grant unit")
 			// retrieved tarrif for final pricing
-			sur.ServiceRating = &charging_datatype.ServiceRating{
-				ServiceIdentifier: datatype.Unsigned32(rg),
-				ConsumedUnits:     datatype.Unsigned32(totalUsedUnit),
-				RequestSubType:    charging_datatype.REQ_SUBTYPE_DEBIT,
+			if len(session.UnitCost[rg]) == 0 {
+				rateTariff(ue, session, rg, chargingData.ServiceSpecificationInfo, sur)
 			}
 
-			serviceUsageRsp, err := rating.SendServiceUsageRequest(ue, sur)
+			price, err := debitPrice(ue, session, rg, sur, totalUsedUnit, totalUsedUnitAfterSwitch)
 			if err != nil {
 				logger.ChargingdataPostLog.Errorf("SendServiceUsageRequest err: %+v", err)
+				bufferUnchargedUsage(session, rg, totalUsedUnit, totalUsedUnitAfterSwitch)
+				failures.fail(&unitInformation, err)
+				multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+				session.AcctRequestNum[rg]++
 				continue
 			}
 			logger.ChargingdataPostLog.Tracef(
-				"price %+v, ue.ReservedQuota[rg]: %+v", serviceUsageRsp.ServiceRating.Price, ue.ReservedQuota[rg])
+				"price %s, session.ReservedQuota[rg]: %s", price, session.ReservedQuota[rg])
 
-			if int64(serviceUsageRsp.ServiceRating.Price) < ue.ReservedQuota[rg] {
+			if price.Cmp(session.ReservedQuota[rg]) < 0 {
 				// The final consumed quota is smaller than the reserved quota
 				// Therefore, return the extra reserved quota back to the user account
-				reservedRemained := ue.ReservedQuota[rg] - int64(serviceUsageRsp.ServiceRating.Price)
+				reservedRemained := session.ReservedQuota[rg].Sub(price)
 				ccr.RequestedAction = charging_datatype.REFUND_ACCOUNT
 				ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
 					RatingGroup: datatype.Unsigned32(rg),
 					RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(reservedRemained),
+						CCMoney:       reservedRemained.CCMoney(session.Currency(rg)),
+						CCTotalOctets: datatype.Unsigned64(session.ReservedOctets[rg]),
 					},
 				}
 				// Typically, the reserved quota will be exhausted for the flow (or PDU session)
 				// However, for the case the flow quota  and PDU session's quota is both last granted quota
 				// and the PDU session's quota is larger than the flow's quota
 				// PDU session's quota should be refund and set to reserved mode in order to reserve the quota for other flow
-				ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
+				session.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
 			} else {
 				// The final consumed quota exceed the reserved quota
 				// Deduct the extra consumed quota from the user account
-				extraConsumed := int64(serviceUsageRsp.ServiceRating.Price) - ue.ReservedQuota[rg]
+				extraConsumed := price.Sub(session.ReservedQuota[rg])
 				ccr.RequestedAction = charging_datatype.DIRECT_DEBITING
 				ccr.CcRequestType = charging_datatype.TERMINATION_REQUEST
 				ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
 					RatingGroup: datatype.Unsigned32(rg),
 					UsedServiceUnit: &charging_datatype.UsedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(extraConsumed),
+						CCMoney: extraConsumed.CCMoney(session.Currency(rg)),
 					},
 				}
 			}
 
-			_, err = abmf.SendAccountDebitRequest(ue, ccr)
+			acctDebitRsp, err := sendAccountDebitRequest(ue, ccr)
 			if err != nil {
 				logger.ChargingdataPostLog.Errorf("SendAccountDebitRequest err: %+v", err)
+				bufferUnchargedUsage(session, rg, totalUsedUnit, totalUsedUnitAfterSwitch)
+				failures.fail(&unitInformation, err)
+				multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+				session.AcctRequestNum[rg]++
 				continue
 			}
-			ue.ReservedQuota[rg] = 0
+			recordRemainingBalance(ue, session, rg, acctDebitRsp, &unitInformation)
+			session.ReservedQuota[rg] = money.Money{}
+			if ccr.RequestedAction == charging_datatype.REFUND_ACCOUNT {
+				// The refund released the reservation along with its octets
+				delete(session.ReservedOctets, rg)
+			} else {
+				refundReservedOctets(ue, session, rg, subscriberIdentifier)
+			}
+			releaseHeld(session, rg)
 
 			unitInformation.Triggers = append(unitInformation.Triggers,
 				models.ChfConvergedChargingTrigger{
@@ -734,15 +1457,101 @@ func sessionChargingReservation(
 				},
 			)
 			unitInformation.GrantedUnit = &models.GrantedUnit{
+				Time:           int32(0),
 				TotalVolume:    int32(0),
 				DownlinkVolume: int32(0),
 				UplinkVolume:   int32(0),
 			}
 		}
+		unitInformation.ResultCode = models.ChfConvergedChargingResultCode_SUCCESS
 		multipleUnitInformation = append(multipleUnitInformation, unitInformation)
 
-		ue.AcctRequestNum[rg]++
+		session.AcctRequestNum[rg]++
 	}
 
 	return multipleUnitInformation, partialRecord
 }
+
+// Ask the rating function how many units of each charged unit type the quota reserved from the account
+// pays for, at most the requested units
+func reserveGrantedUnits(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32,
+	sur *charging_datatype.ServiceUsageRequest, requestedUnit *models.RequestedUnit,
+) (*models.GrantedUnit, error) {
+	grantedUnit := &models.GrantedUnit{}
+
+	// The reservation is sized by the reservation policy and the balance of the account, not by the request
+	var reservedQuota money.Money
+	if session.ReservedQuota[rg].Sign() > 0 {
+		reservedQuota = session.ReservedQuota[rg]
+	}
+
+	// Without counters, the reserved quota pays for the units at the tariff the rating function rated,
+	// the rating group is only rated again for the counters
+	_, rated := session.RatingTime[rg]
+	for unitType, unitCost := range session.UnitCost[rg] {
+		requestedUnits := requestedUnitsOf(requestedUnit, unitType)
+		if rated && len(session.RequestedCounters[rg]) == 0 {
+			setGrantedUnits(grantedUnit, unitType, reservedUnits(reservedQuota, unitCost, requestedUnits))
+			continue
+		}
+		sur.ServiceRating = &charging_datatype.ServiceRating{
+			ServiceIdentifier: datatype.Unsigned32(rg),
+			CCUnitType:        unitType,
+			MonetaryQuota:     reservedQuota.CCMoney(session.Currency(rg)),
+			RequestSubType:    charging_datatype.REQ_SUBTYPE_RESERVE,
+			Counter:           ratingCountersOf(ue, session, rg),
+		}
+
+		serviceUsageRsp, err := sendServiceUsageRequest(ue, sur)
+		if err != nil {
+			return nil, err
+		}
+
+		setGrantedUnits(grantedUnit, unitType, min(uint32(serviceUsageRsp.ServiceRating.AllowedUnits), requestedUnits))
+	}
+
+	return grantedUnit, nil
+}
+
+// Units the reserved quota pays for at the unit cost, at most the requested units; free units are all granted
+func reservedUnits(reservedQuota, unitCost money.Money, requestedUnits uint32) uint32 {
+	if unitCost.Sign() <= 0 {
+		return requestedUnits
+	}
+	return uint32(min(reservedQuota.Units(unitCost), uint64(requestedUnits)))
+}
+
+// Price the consumed units of each unit type the rating group is charged by,
+// the units consumed after the tariff switch are priced with the next tariff
+func debitPrice(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32,
+	sur *charging_datatype.ServiceUsageRequest,
+	consumedUnits, consumedUnitsAfterSwitch map[charging_datatype.CCUnitType]uint32,
+) (money.Money, error) {
+	var price money.Money
+
+	// Rate at the time the tariffs were saved, so that the tariff switch is the same
+	if ratingTime, ok := session.RatingTime[rg]; ok {
+		sur.ActualTime = datatype.Time(ratingTime)
+	}
+	for unitType := range session.UnitCost[rg] {
+		sur.ServiceRating = &charging_datatype.ServiceRating{
+			ServiceIdentifier:              datatype.Unsigned32(rg),
+			CCUnitType:                     unitType,
+			ConsumedUnits:                  datatype.Unsigned32(consumedUnits[unitType]),
+			ConsumedUnitsAfterTariffSwitch: datatype.Unsigned32(consumedUnitsAfterSwitch[unitType]),
+			RequestSubType:                 charging_datatype.REQ_SUBTYPE_DEBIT,
+			Counter:                        ratingCountersOf(ue, session, rg),
+		}
+
+		serviceUsageRsp, err := sendServiceUsageRequest(ue, sur)
+		if err != nil {
+			return money.Money{}, err
+		}
+		unitsPrice, _ := money.FromCCMoney(serviceUsageRsp.ServiceRating.Price)
+		price = price.Add(unitsPrice)
+	}
+
+	return price, nil
+}
@@ -0,0 +1,772 @@
+package processor
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam"
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/internal/ratingcounter"
+	"github.com/free5gc/chf/internal/reservation"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+const testSupi = "imsi-208930000000001"
+
+// chargingPeers answer the requests of the processor in place of the rating function and the ABMF:
+// every unit costs unitCost and the account grants at most its balance
+type chargingPeers struct {
+	unitCost money.Money
+	balance  money.Money
+	// Unit types the tariff prices, every charged unit type if empty
+	unitTypes []charging_datatype.CCUnitType
+	// Counters the tariff is priced with
+	counters []string
+	// Money the reservation ledger of the account holds, answered to a balance check
+	reserved     money.Money
+	reservations int
+
+	// The account is barred, or deleted and unknown to the ABMF
+	barred  bool
+	deleted bool
+
+	rateErr  error
+	debitErr error
+	// Errors of the account requests of a rating group
+	ratingGroupDebitErr map[int32]error
+
+	rated   []*charging_datatype.ServiceRating
+	debited []*charging_datatype.AccountDebitRequest
+}
+
+func (c *chargingPeers) rate(
+	ue *chf_context.ChfUe, sur *charging_datatype.ServiceUsageRequest,
+) (*charging_datatype.ServiceUsageResponse, error) {
+	c.rated = append(c.rated, sur.ServiceRating)
+	if c.rateErr != nil {
+		return nil, c.rateErr
+	}
+	rating := sur.ServiceRating
+	answer := &charging_datatype.ServiceRating{
+		CCUnitType:     rating.CCUnitType,
+		MonetaryTariff: &charging_datatype.MonetaryTariff{},
+	}
+	if len(c.counters) != 0 {
+		answer.RequestedCounters = &charging_datatype.RequestedCounters{}
+		for _, counterId := range c.counters {
+			answer.RequestedCounters.CounterId = append(answer.RequestedCounters.CounterId,
+				datatype.UTF8String(counterId))
+		}
+	}
+	unitTypes := c.unitTypes
+	if len(unitTypes) == 0 {
+		unitTypes = chargedUnitTypes
+	}
+	for _, unitType := range unitTypes {
+		answer.MonetaryTariff.RateElement = append(answer.MonetaryTariff.RateElement,
+			&charging_datatype.RateElement{CCUnitType: unitType, UnitCost: c.unitCost.UnitCost()})
+	}
+	switch rating.RequestSubType {
+	case charging_datatype.REQ_SUBTYPE_RESERVE:
+		if rating.MonetaryQuota != nil {
+			quota, _ := money.FromCCMoney(rating.MonetaryQuota)
+			answer.AllowedUnits = datatype.Unsigned32(quota.Units(c.unitCost))
+		}
+	case charging_datatype.REQ_SUBTYPE_DEBIT:
+		units := uint64(rating.ConsumedUnits) + uint64(rating.ConsumedUnitsAfterTariffSwitch)
+		answer.Price = c.unitCost.Mul(units).CCMoney(0)
+	case charging_datatype.REQ_SUBTYPE_AOC:
+		answer.Price = c.unitCost.Mul(uint64(rating.RequestedUnits)).CCMoney(0)
+	}
+	return &charging_datatype.ServiceUsageResponse{ResultCode: diam.Success, ServiceRating: answer}, nil
+}
+
+func (c *chargingPeers) debit(
+	ue *chf_context.ChfUe, ccr *charging_datatype.AccountDebitRequest,
+) (*charging_datatype.AccountDebitResponse, error) {
+	c.debited = append(c.debited, ccr)
+	if c.debitErr != nil {
+		return nil, c.debitErr
+	}
+	if c.deleted {
+		return &charging_datatype.AccountDebitResponse{ResultCode: charging_code.UserUnknown}, nil
+	}
+	mscc := ccr.MultipleServicesCreditControl
+	if err := c.ratingGroupDebitErr[int32(mscc.RatingGroup)]; err != nil {
+		return nil, err
+	}
+	var granted money.Money
+	switch {
+	case ccr.RequestedAction == charging_datatype.REFUND_ACCOUNT:
+		refund, _ := money.FromCCMoney(mscc.RequestedServiceUnit.CCMoney)
+		c.balance = c.balance.Add(refund)
+	case ccr.CcRequestType == charging_datatype.TERMINATION_REQUEST:
+		used, _ := money.FromCCMoney(mscc.UsedServiceUnit.CCMoney)
+		c.balance = c.balance.Sub(used)
+	case mscc.RequestedServiceUnit != nil:
+		requested, _ := money.FromCCMoney(mscc.RequestedServiceUnit.CCMoney)
+		granted = requested
+		if granted.Cmp(c.balance) > 0 {
+			granted = c.balance
+		}
+		c.balance = c.balance.Sub(granted)
+	}
+	acctDebitRsp := &charging_datatype.AccountDebitResponse{
+		ResultCode: diam.Success,
+		MultipleServicesCreditControl: &charging_datatype.MultipleServicesCreditControl{
+			RatingGroup:        mscc.RatingGroup,
+			GrantedServiceUnit: &charging_datatype.GrantedServiceUnit{CCMoney: granted.CCMoney(0)},
+		},
+		RemainingBalance: &charging_datatype.RemainingBalance{UnitValue: c.balance.UnitValue()},
+	}
+	if ccr.RequestedAction == charging_datatype.CHECK_BALANCE {
+		acctDebitRsp.ABResponse = &charging_datatype.ABResponse{
+			ReservedBalance: &charging_datatype.ReservedBalance{
+				UnitValue:        c.reserved.UnitValue(),
+				ReservationCount: datatype.Unsigned32(c.reservations),
+			},
+		}
+		if c.barred {
+			acctDebitRsp.MultipleServicesCreditControl.ResultCode = charging_code.EndUserServiceDenied
+		}
+	}
+	return acctDebitRsp, nil
+}
+
+// setUpCharging returns a processor whose rating and account requests are answered by the peers,
+// the subscriber testSupi is known with its rating counters loaded
+func setUpCharging(t *testing.T, peers *chargingPeers) *Processor {
+	factory.ChfConfig = &factory.Config{
+		Info: &factory.Info{Version: "1.0.3"},
+		Configuration: &factory.Configuration{
+			Sbi:          &factory.Sbi{},
+			RfDiameter:   &factory.Diameter{},
+			AbmfDiameter: &factory.Diameter{},
+		},
+	}
+	self := chf_context.GetSelf()
+	chf_context.InitChfContext(self)
+	self.UePool.Range(func(supi, _ any) bool {
+		self.UePool.Delete(supi)
+		return true
+	})
+	ue, err := self.NewCHFUe(testSupi)
+	require.NoError(t, err)
+	ue.RatingCountersLoaded = true
+
+	rate, debit, load := rateServiceUsage, debitAccount, loadPersistedCounters
+	rateServiceUsage, debitAccount = peers.rate, peers.debit
+	loadPersistedCounters = func(supi string) ([]ratingcounter.Counter, error) {
+		return nil, nil
+	}
+	t.Cleanup(func() {
+		rateServiceUsage, debitAccount, loadPersistedCounters = rate, debit, load
+	})
+
+	p, err := NewProcessor(nil)
+	require.NoError(t, err)
+	return p
+}
+
+// onlineUsage reports the used octets of the rating group and requests more
+func onlineUsage(rg, used, requested int32) models.ChfConvergedChargingMultipleUnitUsage {
+	unitUsage := models.ChfConvergedChargingMultipleUnitUsage{
+		RatingGroup:   rg,
+		RequestedUnit: &models.RequestedUnit{TotalVolume: requested},
+		UsedUnitContainer: []models.ChfConvergedChargingUsedUnitContainer{
+			{QuotaManagementIndicator: models.QuotaManagementIndicator_ONLINE_CHARGING, TotalVolume: used},
+		},
+	}
+	return unitUsage
+}
+
+// chargingDataOf is a charging data request of testSupi
+func chargingDataOf(
+	seqNum int32, unitUsage ...models.ChfConvergedChargingMultipleUnitUsage,
+) models.ChfConvergedChargingChargingDataRequest {
+	return models.ChfConvergedChargingChargingDataRequest{
+		SubscriberIdentifier:     testSupi,
+		NfConsumerIdentification: &models.ChfConvergedChargingNfIdentification{NFName: "SMF"},
+		InvocationSequenceNumber: seqNum,
+		MultipleUnitUsage:        unitUsage,
+	}
+}
+
+// openSession creates a charging data resource of testSupi and returns its charging data ref
+func openSession(t *testing.T, p *Processor) string {
+	_, location, problemDetails := p.ChargingDataCreate(chargingDataOf(0))
+	require.Nil(t, problemDetails)
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+func TestSplitUsedUnits(t *testing.T) {
+	tariffSwitch := time.Date(2026, 3, 11, 22, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) *time.Time {
+		timestamp := time.Date(2026, 3, 11, hour, minute, 0, 0, time.UTC)
+		return &timestamp
+	}
+
+	testCases := []struct {
+		name         string
+		usedUnit     models.ChfConvergedChargingUsedUnitContainer
+		tariffSwitch time.Time
+		before       uint32
+		after        uint32
+	}{
+		{
+			name:     "no tariff switch",
+			usedUnit: models.ChfConvergedChargingUsedUnitContainer{TotalVolume: 1000, TriggerTimestamp: at(23, 0)},
+			before:   1000,
+		},
+		{
+			name:         "reported before the switch",
+			usedUnit:     models.ChfConvergedChargingUsedUnitContainer{TotalVolume: 1000, TriggerTimestamp: at(21, 0)},
+			tariffSwitch: tariffSwitch,
+			before:       1000,
+		},
+		{
+			name:         "no usage time",
+			usedUnit:     models.ChfConvergedChargingUsedUnitContainer{TotalVolume: 1000},
+			tariffSwitch: tariffSwitch,
+			before:       1000,
+		},
+		{
+			name: "used after the switch",
+			usedUnit: models.ChfConvergedChargingUsedUnitContainer{
+				TotalVolume: 1000,
+				PDUContainerInformation: &models.ChfConvergedChargingPduContainerInformation{
+					TimeofFirstUsage: at(22, 0),
+					TimeofLastUsage:  at(22, 30),
+				},
+			},
+			tariffSwitch: tariffSwitch,
+			after:        1000,
+		},
+		{
+			name: "used across the switch",
+			usedUnit: models.ChfConvergedChargingUsedUnitContainer{
+				TotalVolume: 1000,
+				PDUContainerInformation: &models.ChfConvergedChargingPduContainerInformation{
+					TimeofFirstUsage: at(21, 30),
+					TimeofLastUsage:  at(23, 0),
+				},
+			},
+			tariffSwitch: tariffSwitch,
+			before:       334,
+			after:        666,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before, after := splitUsedUnits(tc.usedUnit, charging_datatype.TOTALOCTETS, tc.tariffSwitch)
+			require.Equal(t, tc.before, before)
+			require.Equal(t, tc.after, after)
+		})
+	}
+}
+
+func TestUsageQuota(t *testing.T) {
+	session := chf_context.NewChargingSession("")
+	usedUnits := map[charging_datatype.CCUnitType]uint32{charging_datatype.TOTALOCTETS: 100}
+	usedUnitsAfterSwitch := map[charging_datatype.CCUnitType]uint32{charging_datatype.TOTALOCTETS: 50}
+
+	session.UnitCost[1] = map[charging_datatype.CCUnitType]money.Money{
+		charging_datatype.TOTALOCTETS: money.FromInt(3),
+	}
+	require.Equal(t, money.FromInt(450), usageQuota(session, 1, usedUnits, usedUnitsAfterSwitch))
+
+	session.NextUnitCost[1] = map[charging_datatype.CCUnitType]money.Money{
+		charging_datatype.TOTALOCTETS: money.FromInt(1),
+	}
+	require.Equal(t, money.FromInt(350), usageQuota(session, 1, usedUnits, usedUnitsAfterSwitch))
+
+	// Fractional unit costs are priced exactly
+	session.NextUnitCost[1] = map[charging_datatype.CCUnitType]money.Money{
+		charging_datatype.TOTALOCTETS: money.New(5, -3),
+	}
+	require.Equal(t, money.New(30025, -2), usageQuota(session, 1, usedUnits, usedUnitsAfterSwitch))
+}
+
+func TestCoverReservedOctets(t *testing.T) {
+	session := chf_context.NewChargingSession("")
+	session.ReservedOctets[1] = 120
+	usedUnits := map[charging_datatype.CCUnitType]uint32{
+		charging_datatype.TOTALOCTETS: 100,
+		charging_datatype.TIME:        10,
+	}
+	usedUnitsAfterSwitch := map[charging_datatype.CCUnitType]uint32{charging_datatype.TOTALOCTETS: 50}
+
+	coverReservedOctets(session, 1, usedUnits, usedUnitsAfterSwitch)
+	require.Equal(t, uint32(0), usedUnits[charging_datatype.TOTALOCTETS])
+	require.Equal(t, uint32(10), usedUnits[charging_datatype.TIME])
+	require.Equal(t, uint32(30), usedUnitsAfterSwitch[charging_datatype.TOTALOCTETS])
+	require.Equal(t, uint64(0), session.ReservedOctets[1])
+
+	// Without data bucket every octet is priced
+	coverReservedOctets(session, 2, usedUnits, usedUnitsAfterSwitch)
+	require.Equal(t, uint32(30), usedUnitsAfterSwitch[charging_datatype.TOTALOCTETS])
+	require.NotContains(t, session.ReservedOctets, int32(2))
+}
+
+func TestHeldReservation(t *testing.T) {
+	session := chf_context.NewChargingSession("chargingData-1")
+	ue := &chf_context.ChfUe{AcctSessionId: 7}
+	require.Equal(t, "7;chargingData-1", accountSessionId(ue, session))
+
+	// The quota used beyond the reservation is paid by the grant, the ABMF holds what is left
+	session.ReservedQuota[1] = money.FromInt(-5)
+	usedQuota, usedOctets := usageSinceHeld(session, 1)
+	require.Equal(t, money.FromInt(5), usedQuota)
+	require.Equal(t, uint64(0), usedOctets)
+	session.ReservedQuota[1] = money.FromInt(25)
+	session.ReservedOctets[1] = 1000
+	holdReservation(session, 1)
+
+	session.ReservedQuota[1] = money.FromInt(10)
+	session.ReservedOctets[1] = 400
+	usedQuota, usedOctets = usageSinceHeld(session, 1)
+	require.Equal(t, money.FromInt(15), usedQuota)
+	require.Equal(t, uint64(600), usedOctets)
+
+	// Nothing is held once the reservation is used up
+	session.ReservedQuota[1] = money.Money{}
+	session.ReservedOctets[1] = 0
+	holdReservation(session, 1)
+	require.NotContains(t, session.HeldQuota, int32(1))
+	require.NotContains(t, session.HeldOctets, int32(1))
+}
+
+func TestTariffOf(t *testing.T) {
+	ratingTime := time.Date(2026, 3, 11, 21, 0, 0, 0, time.UTC)
+	expiryTime := datatype.Time(ratingTime.Add(10 * time.Minute))
+	serviceRating := &charging_datatype.ServiceRating{
+		CCUnitType: charging_datatype.TOTALOCTETS,
+		MonetaryTariff: &charging_datatype.MonetaryTariff{
+			CurrencyCode: 978,
+			RateElement: []*charging_datatype.RateElement{
+				{CCUnitType: charging_datatype.TOTALOCTETS, UnitCost: money.New(5, -3).UnitCost()},
+			},
+		},
+		NextMonetaryTariff: &charging_datatype.NextMonetaryTariff{
+			RateElement: []*charging_datatype.RateElement{
+				{CCUnitType: charging_datatype.TOTALOCTETS, UnitCost: money.New(2, -3).UnitCost()},
+			},
+		},
+		TariffSwitchTime:  3600,
+		ExpiryTime:        &expiryTime,
+		ValidUnits:        800,
+		RequestedCounters: &charging_datatype.RequestedCounters{CounterId: []datatype.UTF8String{"monthlyVolume"}},
+	}
+
+	tariff := tariffOf(serviceRating, ratingTime)
+	require.Equal(t, uint32(978), tariff.CurrencyCode)
+	require.Equal(t, ratingTime.Add(time.Hour), tariff.TariffSwitch)
+	require.Equal(t, ratingTime.Add(10*time.Minute), tariff.ExpiryTime)
+	require.Equal(t, uint64(800), tariff.ValidUnits)
+
+	session := chf_context.NewChargingSession("")
+	saveTariff(session, 1, tariff)
+	require.Equal(t, money.New(5, -3), session.UnitCost[1][charging_datatype.TOTALOCTETS])
+	require.Equal(t, money.New(2, -3), session.NextUnitCost[1][charging_datatype.TOTALOCTETS])
+	require.Equal(t, []string{"monthlyVolume"}, session.RequestedCounters[1])
+	require.Equal(t, uint32(978), session.Currency(1))
+	require.Equal(t, ratingTime, session.RatingTime[1])
+}
+
+func TestGpsiSubscriptionIds(t *testing.T) {
+	ue := &chf_context.ChfUe{Supi: "imsi-208930000000001"}
+	require.Nil(t, gpsiSubscriptionIds(ue))
+
+	recordGpsi(ue, models.ChfConvergedChargingChargingDataRequest{
+		PDUSessionChargingInformation: &models.ChfConvergedChargingPduSessionChargingInformation{
+			UserInformation: &models.ChfConvergedChargingUserInformation{ServedGPSI: "msisdn-886912345678"},
+		},
+	})
+	require.Equal(t, []*charging_datatype.SubscriptionId{{
+		SubscriptionIdType: charging_datatype.END_USER_E164,
+		SubscriptionIdData: "886912345678",
+	}}, gpsiSubscriptionIds(ue))
+
+	// The GPSI is kept when a request does not report it
+	recordGpsi(ue, models.ChfConvergedChargingChargingDataRequest{})
+	require.Equal(t, "msisdn-886912345678", ue.Gpsi)
+}
+
+func TestRetransmittedUpdate(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	chargingDataRef := openSession(t, p)
+
+	response, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)), chargingDataRef)
+	require.Nil(t, problemDetails)
+	debited := len(peers.debited)
+
+	// The retransmission is answered with the response of the request, nothing is charged again
+	retransmitted, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)), chargingDataRef)
+	require.Nil(t, problemDetails)
+	require.Equal(t, response, retransmitted)
+	require.Len(t, peers.debited, debited)
+
+	// The retransmission of a rejected request is rejected the same
+	peers.debitErr = errors.New("timeout: no account answer received")
+	_, rejected := p.ChargingDataUpdate(chargingDataOf(2, onlineUsage(1, 1000, 1000)), chargingDataRef)
+	require.NotNil(t, rejected)
+	debited = len(peers.debited)
+	_, problemDetails = p.ChargingDataUpdate(chargingDataOf(2, onlineUsage(1, 1000, 1000)), chargingDataRef)
+	require.Equal(t, rejected, problemDetails)
+	require.Equal(t, CauseReAuthorizationFailed, problemDetails.Cause)
+	require.Len(t, peers.debited, debited)
+}
+
+func TestGrantedUnitsOfReservationPolicy(t *testing.T) {
+	testCases := []struct {
+		name            string
+		policy          *factory.ReservationPolicy
+		ratio           int32
+		balance         int64
+		consumptionRate float64
+		granted         int32
+	}{
+		{
+			name:    "requested",
+			granted: 1000,
+		},
+		{
+			name:    "fixed",
+			policy:  &factory.ReservationPolicy{Type: reservation.PolicyFixed, FixedQuota: "100"},
+			granted: 100,
+		},
+		{
+			name:    "balance ratio",
+			policy:  &factory.ReservationPolicy{Type: reservation.PolicyBalanceRatio},
+			ratio:   10,
+			balance: 5000,
+			granted: 500,
+		},
+		{
+			name:            "adaptive",
+			policy:          &factory.ReservationPolicy{Type: reservation.PolicyAdaptive, AdaptiveInterval: 60},
+			consumptionRate: 2,
+			granted:         120,
+		},
+		{
+			name: "bounded",
+			policy: &factory.ReservationPolicy{RatingGroups: []*factory.RatingGroupReservation{
+				{RatingGroup: 1, MaxQuota: "300"},
+			}},
+			granted: 300,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := setUpCharging(t, &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)})
+			self := chf_context.GetSelf()
+			self.ReservationPolicy = reservation.NewPolicy(tc.policy, tc.ratio)
+			chargingDataRef := openSession(t, p)
+
+			ue, ok := self.ChfUeFindBySupi(testSupi)
+			require.True(t, ok)
+			if tc.balance != 0 {
+				ue.RemainingBalance[1] = money.FromInt(tc.balance)
+			}
+			if tc.consumptionRate != 0 {
+				session, found := ue.ChargingSessionFind(chargingDataRef)
+				require.True(t, found)
+				session.ConsumptionRate[1] = tc.consumptionRate
+			}
+
+			response, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)),
+				chargingDataRef)
+			require.Nil(t, problemDetails)
+			require.Len(t, response.MultipleUnitInformation, 1)
+			require.Equal(t, tc.granted, response.MultipleUnitInformation[0].GrantedUnit.TotalVolume)
+		})
+	}
+}
+
+func TestGrantedUnitsOfPartialReservation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		counters []string
+	}{
+		{name: "rated tariff"},
+		{name: "counted tariff", counters: []string{"monthly-volume"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The account only grants the money left of the requested 1000 units
+			peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(400), counters: tc.counters}
+			p := setUpCharging(t, peers)
+			chargingDataRef := openSession(t, p)
+
+			response, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)),
+				chargingDataRef)
+			require.Nil(t, problemDetails)
+			require.Equal(t, int32(400), response.MultipleUnitInformation[0].GrantedUnit.TotalVolume)
+
+			// The units of the counted tariff are rated for the quota reserved
+			if len(tc.counters) != 0 {
+				var reserved []string
+				for _, rating := range peers.rated {
+					if rating.RequestSubType == charging_datatype.REQ_SUBTYPE_RESERVE && rating.MonetaryQuota != nil {
+						quota, _ := money.FromCCMoney(rating.MonetaryQuota)
+						reserved = append(reserved, quota.String())
+					}
+				}
+				require.Contains(t, reserved, "400")
+			}
+		})
+	}
+}
+
+func TestGrantedUnitsOfUnitTypes(t *testing.T) {
+	testCases := []struct {
+		name      string
+		unitTypes []charging_datatype.CCUnitType
+		requested models.RequestedUnit
+		granted   models.GrantedUnit
+	}{
+		{
+			name:      "time",
+			requested: models.RequestedUnit{Time: 60},
+			granted:   models.GrantedUnit{Time: 60},
+		},
+		{
+			name:      "time and volume",
+			requested: models.RequestedUnit{Time: 60, TotalVolume: 1000},
+			granted:   models.GrantedUnit{Time: 60, TotalVolume: 1000, UplinkVolume: 1000, DownlinkVolume: 1000},
+		},
+		{
+			name:      "volume tariff",
+			unitTypes: []charging_datatype.CCUnitType{charging_datatype.TOTALOCTETS},
+			requested: models.RequestedUnit{Time: 60, TotalVolume: 1000},
+			granted:   models.GrantedUnit{TotalVolume: 1000, UplinkVolume: 1000, DownlinkVolume: 1000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000), unitTypes: tc.unitTypes}
+			p := setUpCharging(t, peers)
+			chargingDataRef := openSession(t, p)
+			ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+			require.True(t, ok)
+			ue.TimeThresholdRate, ue.VolumeThresholdRate = 0.5, 0.5
+
+			unitUsage := onlineUsage(1, 0, 0)
+			unitUsage.RequestedUnit = &tc.requested
+			response, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, unitUsage), chargingDataRef)
+			require.Nil(t, problemDetails)
+			unitInformation := response.MultipleUnitInformation[0]
+			require.Equal(t, tc.granted, *unitInformation.GrantedUnit)
+			require.Equal(t, tc.granted.Time/2, unitInformation.TimeQuotaThreshold)
+			require.Equal(t, tc.granted.TotalVolume/2, unitInformation.VolumeQuotaThreshold)
+		})
+	}
+}
+
+func TestTimeUsage(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.New(5, -1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	chargingDataRef := openSession(t, p)
+
+	timeUsage := func(used, requested int32) models.ChfConvergedChargingMultipleUnitUsage {
+		unitUsage := onlineUsage(1, 0, 0)
+		unitUsage.RequestedUnit = &models.RequestedUnit{Time: requested}
+		unitUsage.UsedUnitContainer[0].Time = used
+		return unitUsage
+	}
+	_, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, timeUsage(0, 60)), chargingDataRef)
+	require.Nil(t, problemDetails)
+
+	// The seconds used are priced and settled with the ABMF when the next seconds are reserved
+	response, problemDetails := p.ChargingDataUpdate(chargingDataOf(2, timeUsage(60, 60)), chargingDataRef)
+	require.Nil(t, problemDetails)
+	require.Equal(t, int32(60), response.MultipleUnitInformation[0].GrantedUnit.Time)
+	ccr := peers.debited[len(peers.debited)-1]
+	used, _ := money.FromCCMoney(ccr.MultipleServicesCreditControl.UsedServiceUnit.CCMoney)
+	reserved, _ := money.FromCCMoney(ccr.MultipleServicesCreditControl.RequestedServiceUnit.CCMoney)
+	require.Equal(t, "30", used.String())
+	require.Equal(t, "30", reserved.String())
+}
+
+func TestSessionIsolation(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	first, second := openSession(t, p), openSession(t, p)
+	require.NotEqual(t, first, second)
+
+	// Both sessions use rating group 1, each holds its own reservation with the ABMF
+	_, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)), first)
+	require.Nil(t, problemDetails)
+	_, problemDetails = p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 500)), second)
+	require.Nil(t, problemDetails)
+	require.Len(t, peers.debited, 2)
+	require.NotEqual(t, peers.debited[0].SessionId, peers.debited[1].SessionId)
+
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	firstSession, ok := ue.ChargingSessionFind(first)
+	require.True(t, ok)
+	secondSession, ok := ue.ChargingSessionFind(second)
+	require.True(t, ok)
+	require.Equal(t, "1000", firstSession.ReservedQuota[1].String())
+	require.Equal(t, "500", secondSession.ReservedQuota[1].String())
+
+	// Releasing a session only refunds its own reservation
+	require.Nil(t, p.ChargingDataRelease(chargingDataOf(2), first))
+	refund := peers.debited[len(peers.debited)-1]
+	require.Equal(t, charging_datatype.REFUND_ACCOUNT, refund.RequestedAction)
+	require.Equal(t, peers.debited[0].SessionId, refund.SessionId)
+	refunded, _ := money.FromCCMoney(refund.MultipleServicesCreditControl.RequestedServiceUnit.CCMoney)
+	require.Equal(t, "1000", refunded.String())
+	require.Equal(t, "500", secondSession.ReservedQuota[1].String())
+	_, ok = ue.ChargingSessionFind(first)
+	require.False(t, ok)
+}
+
+// debitedOf are the account requests of the rating group
+func debitedOf(peers *chargingPeers, rg int32) []*charging_datatype.AccountDebitRequest {
+	var debited []*charging_datatype.AccountDebitRequest
+	for _, ccr := range peers.debited {
+		if int32(ccr.MultipleServicesCreditControl.RatingGroup) == rg {
+			debited = append(debited, ccr)
+		}
+	}
+	return debited
+}
+
+func TestHandleChargingdataRelease(t *testing.T) {
+	p := setUpCharging(t, &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)})
+	chargingDataRef := openSession(t, p)
+	_, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)), chargingDataRef)
+	require.Nil(t, problemDetails)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	p.HandleChargingdataRelease(c, chargingDataOf(2, onlineUsage(1, 300, 0)), chargingDataRef)
+	require.Equal(t, http.StatusNoContent, c.Writer.Status())
+
+	// The charging data resource does not exist anymore
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	p.HandleChargingdataUpdate(c, chargingDataOf(3, onlineUsage(1, 0, 1000)), chargingDataRef)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), CauseChargingDataRefNotFound)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	p.HandleChargingdataRelease(c, chargingDataOf(3), chargingDataRef)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReleaseSettlementFailure(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	chargingDataRef := openSession(t, p)
+	_, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000), onlineUsage(2, 0, 1000)),
+		chargingDataRef)
+	require.Nil(t, problemDetails)
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	session, ok := ue.ChargingSessionFind(chargingDataRef)
+	require.True(t, ok)
+
+	// The final usage of rating group 1 is settled, the reservation of rating group 2 is kept as the ABMF fails
+	abmfTimeout := errors.New("timeout: no account answer received")
+	peers.ratingGroupDebitErr = map[int32]error{2: abmfTimeout}
+	release := chargingDataOf(2, onlineUsage(1, 300, 0), onlineUsage(2, 400, 0))
+	problemDetails = p.ChargingDataRelease(release, chargingDataRef)
+	require.NotNil(t, problemDetails)
+	require.Equal(t, CauseChargingFailed, problemDetails.Cause)
+	require.Contains(t, problemDetails.Detail, abmfTimeout.Error())
+
+	settled := debitedOf(peers, 1)
+	refund := settled[len(settled)-1]
+	require.Equal(t, charging_datatype.REFUND_ACCOUNT, refund.RequestedAction)
+	refunded, _ := money.FromCCMoney(refund.MultipleServicesCreditControl.RequestedServiceUnit.CCMoney)
+	require.Equal(t, "700", refunded.String())
+	require.True(t, session.ReservedQuota[1].IsZero())
+	require.Equal(t, "1000", session.ReservedQuota[2].String())
+	_, ok = ue.ChargingSessionFind(chargingDataRef)
+	require.True(t, ok)
+
+	// The retried release only settles rating group 2, with the usage the failed release reported
+	peers.ratingGroupDebitErr = nil
+	debited := len(settled)
+	require.Nil(t, p.ChargingDataRelease(release, chargingDataRef))
+	require.Len(t, debitedOf(peers, 1), debited)
+	unsettled := debitedOf(peers, 2)
+	refund = unsettled[len(unsettled)-1]
+	require.Equal(t, charging_datatype.REFUND_ACCOUNT, refund.RequestedAction)
+	refunded, _ = money.FromCCMoney(refund.MultipleServicesCreditControl.RequestedServiceUnit.CCMoney)
+	require.Equal(t, "600", refunded.String())
+	require.Equal(t, "99300", peers.balance.String())
+	_, ok = ue.ChargingSessionFind(chargingDataRef)
+	require.False(t, ok)
+}
+
+func TestSuspendedQuotaManagement(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	chargingDataRef := openSession(t, p)
+	_, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, onlineUsage(1, 0, 1000)), chargingDataRef)
+	require.Nil(t, problemDetails)
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	session, ok := ue.ChargingSessionFind(chargingDataRef)
+	require.True(t, ok)
+
+	// The usage reported while quota management is suspended is buffered, nothing is charged
+	suspended := onlineUsage(1, 200, 0)
+	suspended.UsedUnitContainer[0].QuotaManagementIndicator = models.QuotaManagementIndicator_QUOTA_MANAGEMENT_SUSPENDED
+	debited := len(peers.debited)
+	_, problemDetails = p.ChargingDataUpdate(chargingDataOf(2, suspended), chargingDataRef)
+	require.Nil(t, problemDetails)
+	require.Len(t, peers.debited, debited)
+	require.Equal(t, chf_context.QuotaManagementSuspended, session.QuotaManagement[1])
+	require.Equal(t, uint32(200), session.SuspendedUsage[1][charging_datatype.TOTALOCTETS])
+	require.Equal(t, "1000", session.ReservedQuota[1].String())
+
+	// The buffered usage is charged along with the usage reported once quota management resumes
+	_, problemDetails = p.ChargingDataUpdate(chargingDataOf(3, onlineUsage(1, 300, 1000)), chargingDataRef)
+	require.Nil(t, problemDetails)
+	require.Equal(t, chf_context.QuotaManagementOnline, session.QuotaManagement[1])
+	require.Empty(t, session.SuspendedUsage[1])
+	require.Equal(t, "500", session.ReservedQuota[1].String())
+}
+
+func TestOfflineQuotaManagement(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	chargingDataRef := openSession(t, p)
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	ue.OfflineVolumeThreshold, ue.OfflineTimeThreshold = 5000000, 3600
+
+	// The usage of offline charging is only reported at the thresholds of the subscriber
+	offline := onlineUsage(1, 200, 0)
+	offline.UsedUnitContainer[0].QuotaManagementIndicator = models.QuotaManagementIndicator_OFFLINE_CHARGING
+	response, problemDetails := p.ChargingDataUpdate(chargingDataOf(1, offline), chargingDataRef)
+	require.Nil(t, problemDetails)
+	require.Empty(t, peers.debited)
+	require.Len(t, response.MultipleUnitInformation, 1)
+	unitInformation := response.MultipleUnitInformation[0]
+	require.Equal(t, int32(5000000), unitInformation.VolumeQuotaThreshold)
+	require.Equal(t, int32(3600), unitInformation.TimeQuotaThreshold)
+	require.Nil(t, unitInformation.GrantedUnit)
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/gin-gonic/gin"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+// Unit types as named by the tariffs
+var unitTypeNames = map[charging_datatype.CCUnitType]string{
+	charging_datatype.TIME:                 "time",
+	charging_datatype.TOTALOCTETS:          "volume",
+	charging_datatype.SERVICESPECIFICUNITS: "serviceSpecificUnits",
+}
+
+// CostEstimateRequest asks the price of the units of a rating group before using them,
+// e.g. by the self-care portal for the roaming price transparency
+type CostEstimateRequest struct {
+	SubscriberIdentifier string                `json:"subscriberIdentifier"`
+	RatingGroup          int32                 `json:"ratingGroup"`
+	RequestedUnit        *models.RequestedUnit `json:"requestedUnit,omitempty"`
+	// The units are priced with the tariff applying at this time, now if not set
+	UsageTime *time.Time `json:"usageTime,omitempty"`
+}
+
+// CostEstimate is the advice of charge of the requested units, nothing is reserved nor debited
+type CostEstimate struct {
+	SubscriberIdentifier string     `json:"subscriberIdentifier"`
+	RatingGroup          int32      `json:"ratingGroup"`
+	Price                string     `json:"price"`
+	CurrencyCode         uint32     `json:"currencyCode"`
+	UnitCosts            []UnitCost `json:"unitCosts,omitempty"`
+	// The units used from this time on are priced with another tariff
+	TariffSwitchTime *time.Time `json:"tariffSwitchTime,omitempty"`
+	TimeStamp        time.Time  `json:"timeStamp"`
+}
+
+type UnitCost struct {
+	UnitType string `json:"unitType"`
+	UnitCost string `json:"unitCost"`
+}
+
+func (p *Processor) HandleCostEstimate(c *gin.Context, costEstimateReq CostEstimateRequest) {
+	logger.ChargingdataPostLog.Infof("HandleCostEstimate")
+	costEstimate, problemDetails := p.CostEstimate(costEstimateReq)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.JSON(http.StatusOK, costEstimate)
+}
+
+// Advice of charge: the rating function prices the requested units, the counters are left unchanged. The
+// estimate answers no account data, the balance of the account is only answered by the customer-care API.
+func (p *Processor) CostEstimate(costEstimateReq CostEstimateRequest) (*CostEstimate, *models.ProblemDetails) {
+	self := chf_context.GetSelf()
+	ueId := costEstimateReq.SubscriberIdentifier
+	rg := costEstimateReq.RatingGroup
+
+	if ueId == "" {
+		return nil, NewChargingError(CauseMandatoryIeMissing, "subscriberIdentifier is missing",
+			models.InvalidParam{Param: "/subscriberIdentifier", Reason: "missing"}).ProblemDetails()
+	}
+	ue, release, err := enquiryUe(ueId)
+	if err != nil {
+		logger.ChargingdataPostLog.Errorf("New CHFUe error %s", err)
+		return nil, NewChargingError(CauseChargingFailed, err.Error(), models.InvalidParam{
+			Param:  "/subscriberIdentifier",
+			Reason: "only IMSI based SUPI is supported",
+		}).ProblemDetails()
+	}
+	defer release()
+
+	usageTime := time.Now()
+	if costEstimateReq.UsageTime != nil {
+		usageTime = *costEstimateReq.UsageTime
+	}
+	subscriberIdentifier := buildSubscriptionId(ueId)
+	sur := &charging_datatype.ServiceUsageRequest{
+		SessionId:      datatype.UTF8String(strconv.Itoa(int(ue.RateSessionId))),
+		OriginHost:     datatype.DiameterIdentity(self.RatingCfg.OriginHost),
+		OriginRealm:    datatype.DiameterIdentity(self.RatingCfg.OriginRealm),
+		ActualTime:     datatype.Time(usageTime),
+		SubscriptionId: subscriberIdentifier,
+		UserName:       datatype.OctetString(self.Name),
+	}
+
+	// The counters the units are priced with are not known before rating
+	counters := allRatingCounters(ue)
+
+	costEstimate := &CostEstimate{
+		SubscriberIdentifier: ueId,
+		RatingGroup:          rg,
+		CurrencyCode:         money.DefaultCurrencyCode,
+	}
+	var price money.Money
+	for _, unitType := range estimatedUnitTypes(costEstimateReq.RequestedUnit) {
+		sur.ServiceRating = &charging_datatype.ServiceRating{
+			ServiceIdentifier: datatype.Unsigned32(rg),
+			CCUnitType:        unitType,
+			RequestSubType:    charging_datatype.REQ_SUBTYPE_AOC,
+			RequestedUnits:    datatype.Unsigned32(requestedUnitsOf(costEstimateReq.RequestedUnit, unitType)),
+			Counter:           counters,
+		}
+		serviceUsageRsp, errRating := sendServiceUsageRequest(ue, sur)
+		if errRating != nil {
+			logger.ChargingdataPostLog.Errorf("UE[%s] rating group [%d]: advice of charge error: %+v",
+				ueId, rg, errRating)
+			return nil, chargingErrorFrom(errRating, true, false).ProblemDetails()
+		}
+		if serviceUsageRsp.ServiceRating == nil {
+			continue
+		}
+		serviceRating := serviceUsageRsp.ServiceRating
+		unitsPrice, currencyCode := money.FromCCMoney(serviceRating.Price)
+		price = price.Add(unitsPrice)
+		costEstimate.CurrencyCode = currencyCode
+		if costEstimate.UnitCosts == nil && serviceRating.MonetaryTariff != nil {
+			costEstimate.UnitCosts = unitCostsOf(serviceRating.MonetaryTariff.RateElement)
+		}
+		if serviceRating.NextMonetaryTariff != nil && serviceRating.TariffSwitchTime != 0 {
+			tariffSwitch := usageTime.Add(time.Duration(serviceRating.TariffSwitchTime) * time.Second)
+			costEstimate.TariffSwitchTime = &tariffSwitch
+		}
+	}
+	costEstimate.Price = price.String()
+	costEstimate.TimeStamp = time.Now()
+
+	logger.ChargingdataPostLog.Infof("UE[%s] rating group [%d]: estimated price %s", ueId, rg, costEstimate.Price)
+	return costEstimate, nil
+}
+
+// enquiryUe is the context of the UE for an enquiry with its lock held: the context of a charging UE, otherwise
+// a transient context so that enquiries about any subscriber do not leave contexts behind. release unlocks and
+// frees it.
+func enquiryUe(ueId string) (ue *chf_context.ChfUe, release func(), err error) {
+	self := chf_context.GetSelf()
+	ue, ok := self.ChfUeFindBySupi(ueId)
+	if ok {
+		ue.CULock.Lock()
+		return ue, ue.CULock.Unlock, nil
+	}
+	if ue, err = self.NewTransientChfUe(ueId); err != nil {
+		return nil, nil, err
+	}
+	return ue, func() {
+		self.ReleaseTransientChfUe(ue)
+	}, nil
+}
+
+// The unit types requested, the tariff alone is answered if no unit is requested
+func estimatedUnitTypes(requestedUnit *models.RequestedUnit) []charging_datatype.CCUnitType {
+	var unitTypes []charging_datatype.CCUnitType
+	for _, unitType := range chargedUnitTypes {
+		if requestedUnitsOf(requestedUnit, unitType) != 0 {
+			unitTypes = append(unitTypes, unitType)
+		}
+	}
+	if len(unitTypes) == 0 {
+		unitTypes = append(unitTypes, charging_datatype.TOTALOCTETS)
+	}
+	return unitTypes
+}
+
+func unitCostsOf(rateElements []*charging_datatype.RateElement) []UnitCost {
+	var unitCosts []UnitCost
+	for unitType, unitCost := range unitCostOf(rateElements) {
+		unitCosts = append(unitCosts, UnitCost{UnitType: unitTypeNames[unitType], UnitCost: unitCost.String()})
+	}
+	sort.Slice(unitCosts, func(i, j int) bool { return unitCosts[i].UnitType < unitCosts[j].UnitType })
+	return unitCosts
+}
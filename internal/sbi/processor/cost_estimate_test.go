@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestEstimatedUnitTypes(t *testing.T) {
+	testCases := []struct {
+		name          string
+		requestedUnit *models.RequestedUnit
+		unitTypes     []charging_datatype.CCUnitType
+	}{
+		{
+			name:          "volume and time",
+			requestedUnit: &models.RequestedUnit{Time: 60, TotalVolume: 1000},
+			unitTypes:     []charging_datatype.CCUnitType{charging_datatype.TIME, charging_datatype.TOTALOCTETS},
+		},
+		{
+			name:          "events",
+			requestedUnit: &models.RequestedUnit{ServiceSpecificUnits: 3},
+			unitTypes:     []charging_datatype.CCUnitType{charging_datatype.SERVICESPECIFICUNITS},
+		},
+		{
+			name:      "tariff only",
+			unitTypes: []charging_datatype.CCUnitType{charging_datatype.TOTALOCTETS},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.unitTypes, estimatedUnitTypes(tc.requestedUnit))
+		})
+	}
+}
+
+func TestUnitCostsOf(t *testing.T) {
+	unitCosts := unitCostsOf([]*charging_datatype.RateElement{
+		{CCUnitType: charging_datatype.TOTALOCTETS, UnitCost: money.New(5, -3).UnitCost()},
+		{CCUnitType: charging_datatype.TIME, UnitCost: money.New(1, 0).UnitCost()},
+	})
+	require.Equal(t, []UnitCost{
+		{UnitType: "time", UnitCost: "1"},
+		{UnitType: "volume", UnitCost: "0.005"},
+	}, unitCosts)
+}
+
+func TestCostEstimateWithoutSubscriber(t *testing.T) {
+	p := &Processor{}
+	costEstimate, problemDetails := p.CostEstimate(CostEstimateRequest{RatingGroup: 1})
+	require.Nil(t, costEstimate)
+	require.Equal(t, int32(http.StatusBadRequest), problemDetails.Status)
+	require.Equal(t, CauseMandatoryIeMissing, problemDetails.Cause)
+
+	_, problemDetails = p.CostEstimate(CostEstimateRequest{SubscriberIdentifier: "nai-user@example.com"})
+	require.Equal(t, CauseChargingFailed, problemDetails.Cause)
+}
+
+func TestCostEstimate(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.New(5, -3), balance: money.FromInt(100)}
+	p := setUpCharging(t, peers)
+	self := chf_context.GetSelf()
+
+	for _, ueId := range []string{testSupi, "imsi-208930000000099"} {
+		costEstimate, problemDetails := p.CostEstimate(CostEstimateRequest{
+			SubscriberIdentifier: ueId,
+			RatingGroup:          1,
+			RequestedUnit:        &models.RequestedUnit{TotalVolume: 1000},
+		})
+		require.Nil(t, problemDetails)
+		require.Equal(t, "5", costEstimate.Price)
+	}
+
+	// The account is not enquired and no context is left for the subscriber without charging session
+	require.Empty(t, peers.debited)
+	_, ok := self.ChfUeFindBySupi("imsi-208930000000099")
+	require.False(t, ok)
+}
@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi/models"
+)
+
+// Event charging with unit reservation is started by a charging data request
+// which only requests service specific units (e.g. SMS or API calls)
+func isEventChargingWithUnitReservation(chargingData models.ChfConvergedChargingChargingDataRequest) bool {
+	for _, unitUsage := range chargingData.MultipleUnitUsage {
+		if len(unitUsage.UsedUnitContainer) == 0 &&
+			requestedUnitsOf(unitUsage.RequestedUnit, charging_datatype.SERVICESPECIFICUNITS) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 32.290 5.2.2.2: Immediate event charging
+// Each rating group of the event is priced by the rating function and
+// debited from the account in a single request, no quota is reserved.
+func immediateEventCharging(
+	ue *chf_context.ChfUe, chargingData models.ChfConvergedChargingChargingDataRequest, failures unitFailures,
+) []models.MultipleUnitInformation {
+	var multipleUnitInformation []models.MultipleUnitInformation
+
+	self := chf_context.GetSelf()
+	subscriberIdentifier := buildSubscriptionId(chargingData.SubscriberIdentifier)
+	// One-time events have no charging data resource, the tariff is only kept for pricing this event
+	session := chf_context.NewChargingSession("")
+
+	for _, unitUsage := range chargingData.MultipleUnitUsage {
+		rg := unitUsage.RatingGroup
+		unitInformation := models.MultipleUnitInformation{
+			UPFID:       unitUsage.UPFID,
+			RatingGroup: rg,
+		}
+
+		// The units of the event are the requested units, or the reported units if nothing is requested
+		eventUnits := make(map[charging_datatype.CCUnitType]uint32)
+		for _, unitType := range chargedUnitTypes {
+			eventUnits[unitType] = requestedUnitsOf(unitUsage.RequestedUnit, unitType)
+			if eventUnits[unitType] != 0 {
+				continue
+			}
+			for _, usedUnit := range unitUsage.UsedUnitContainer {
+				eventUnits[unitType] += usedUnitsOf(usedUnit, unitType)
+			}
+		}
+
+		sur := &charging_datatype.ServiceUsageRequest{
+			SessionId:      datatype.UTF8String(strconv.Itoa(int(ue.RateSessionId))),
+			OriginHost:     datatype.DiameterIdentity(self.RatingCfg.OriginHost),
+			OriginRealm:    datatype.DiameterIdentity(self.RatingCfg.OriginRealm),
+			ActualTime:     datatype.Time(time.Now()),
+			SubscriptionId: subscriberIdentifier,
+			UserName:       datatype.OctetString(self.Name),
+		}
+
+		rateTariff(ue, session, rg, chargingData.ServiceSpecificationInfo, sur)
+		price, err := debitPrice(ue, session, rg, sur, eventUnits, nil)
+		if err != nil {
+			logger.ChargingdataPostLog.Errorf("SendServiceUsageRequest err: %+v", err)
+			failures.fail(&unitInformation, err)
+			multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+			continue
+		}
+
+		ccr := newAccountDebitRequest(ue, session, rg, subscriberIdentifier)
+		ccr.CcRequestType = charging_datatype.EVENT_REQUEST
+		ccr.RequestedAction = charging_datatype.DIRECT_DEBITING
+		ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
+			RatingGroup: datatype.Unsigned32(rg),
+			RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
+				CCMoney: price.CCMoney(session.Currency(rg)),
+			},
+		}
+		session.AcctRequestNum[rg]++
+
+		acctDebitRsp, err := sendAccountDebitRequest(ue, ccr)
+		if err != nil {
+			logger.ChargingdataPostLog.Warnf("UE[%s] rating group [%d]: event of price %s is not debited: %+v",
+				chargingData.SubscriberIdentifier, rg, price, err)
+			failures.fail(&unitInformation, err)
+			multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+			continue
+		}
+		recordRemainingBalance(ue, session, rg, acctDebitRsp, &unitInformation)
+
+		grantedUnit := &models.GrantedUnit{}
+		for unitType, units := range eventUnits {
+			setGrantedUnits(grantedUnit, unitType, units)
+		}
+		unitInformation.GrantedUnit = grantedUnit
+		unitInformation.ResultCode = models.ChfConvergedChargingResultCode_SUCCESS
+		logger.ChargingdataPostLog.Infof("UE[%s] rating group [%d]: event charged with price %s",
+			chargingData.SubscriberIdentifier, rg, price)
+
+		multipleUnitInformation = append(multipleUnitInformation, unitInformation)
+	}
+
+	return multipleUnitInformation
+}
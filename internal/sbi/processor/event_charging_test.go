@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+// eventOf is a charging data request of testSupi for service specific units of the rating group
+func eventOf(oneTimeEventType models.OneTimeEventType, rg, units int32) models.ChfConvergedChargingChargingDataRequest {
+	chargingData := chargingDataOf(0, models.ChfConvergedChargingMultipleUnitUsage{
+		RatingGroup:   rg,
+		RequestedUnit: &models.RequestedUnit{ServiceSpecificUnits: units},
+	})
+	chargingData.NfConsumerIdentification.NFName = "SMSF"
+	if oneTimeEventType != "" {
+		chargingData.OneTimeEvent = true
+		chargingData.OneTimeEventType = oneTimeEventType
+	}
+	return chargingData
+}
+
+func TestIsEventChargingWithUnitReservation(t *testing.T) {
+	require.True(t, isEventChargingWithUnitReservation(eventOf("", 2, 1)))
+	require.False(t, isEventChargingWithUnitReservation(chargingDataOf(0, onlineUsage(1, 0, 1000))))
+	require.False(t, isEventChargingWithUnitReservation(chargingDataOf(0, onlineUsage(2, 0, 0))))
+}
+
+func TestImmediateEventCharging(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.New(5, -1), balance: money.FromInt(100)}
+	p := setUpCharging(t, peers)
+
+	response, _, problemDetails := p.ChargingDataCreate(eventOf(models.OneTimeEventType_IEC, 2, 3))
+	require.Nil(t, problemDetails)
+	require.Len(t, response.MultipleUnitInformation, 1)
+	unitInformation := response.MultipleUnitInformation[0]
+	require.Equal(t, models.ChfConvergedChargingResultCode_SUCCESS, unitInformation.ResultCode)
+	require.Equal(t, int32(3), unitInformation.GrantedUnit.ServiceSpecificUnits)
+
+	// The event is priced and debited at once in a single request
+	require.Len(t, peers.debited, 1)
+	ccr := peers.debited[0]
+	require.Equal(t, charging_datatype.EVENT_REQUEST, ccr.CcRequestType)
+	require.Equal(t, charging_datatype.DIRECT_DEBITING, ccr.RequestedAction)
+	price, _ := money.FromCCMoney(ccr.MultipleServicesCreditControl.RequestedServiceUnit.CCMoney)
+	require.Equal(t, "1.5", price.String())
+	require.Equal(t, "98.5", peers.balance.String())
+
+	// No charging data resource is kept for the event
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	require.Empty(t, ue.ChargingSessions)
+}
+
+func TestEventChargingWithUnitReservation(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.New(5, -1), balance: money.FromInt(100)}
+	p := setUpCharging(t, peers)
+
+	// The units of the event are reserved when the charging data resource is created
+	response, location, problemDetails := p.ChargingDataCreate(eventOf("", 2, 4))
+	require.Nil(t, problemDetails)
+	require.Len(t, response.MultipleUnitInformation, 1)
+	require.Equal(t, int32(4), response.MultipleUnitInformation[0].GrantedUnit.ServiceSpecificUnits)
+	require.Len(t, peers.debited, 1)
+	reserved, _ := money.FromCCMoney(peers.debited[0].MultipleServicesCreditControl.RequestedServiceUnit.CCMoney)
+	require.Equal(t, "2", reserved.String())
+
+	// The units delivered are charged against the reservation
+	chargingDataRef := location[strings.LastIndex(location, "/")+1:]
+	unitUsage := onlineUsage(2, 0, 0)
+	unitUsage.RequestedUnit = &models.RequestedUnit{ServiceSpecificUnits: 4}
+	unitUsage.UsedUnitContainer[0].ServiceSpecificUnits = 4
+	response, problemDetails = p.ChargingDataUpdate(chargingDataOf(1, unitUsage), chargingDataRef)
+	require.Nil(t, problemDetails)
+	require.Equal(t, int32(4), response.MultipleUnitInformation[0].GrantedUnit.ServiceSpecificUnits)
+	ccr := peers.debited[len(peers.debited)-1]
+	used, _ := money.FromCCMoney(ccr.MultipleServicesCreditControl.UsedServiceUnit.CCMoney)
+	require.Equal(t, "2", used.String())
+}
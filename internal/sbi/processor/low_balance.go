@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+const lowBalanceNotifyTimeout = 3 * time.Second
+
+// LowBalanceNotification is posted to the operator portal when the balance of a subscriber falls under a threshold
+type LowBalanceNotification struct {
+	Supi             string    `json:"supi"`
+	RatingGroup      int32     `json:"ratingGroup"`
+	ChargingDataRef  string    `json:"chargingDataRef,omitempty"`
+	RemainingBalance string    `json:"remainingBalance"`
+	CurrencyCode     uint32    `json:"currencyCode,omitempty"`
+	Threshold        int64     `json:"threshold"`
+	TimeStamp        time.Time `json:"timeStamp"`
+}
+
+// Record the remaining balance answered by the ABMF and warn the subscriber if it falls under a low balance threshold
+func recordRemainingBalance(
+	ue *chf_context.ChfUe, session *chf_context.ChargingSession, rg int32,
+	acctDebitRsp *charging_datatype.AccountDebitResponse, unitInformation *models.MultipleUnitInformation,
+) {
+	remaining, currencyCode, found := remainingBalanceOf(acctDebitRsp)
+	if !found {
+		return
+	}
+	threshold, crossed := ue.UpdateRemainingBalance(rg, remaining)
+	if !crossed {
+		return
+	}
+	logger.ChargingdataPostLog.Warnf("UE[%s] rating group [%d]: remaining balance %s under threshold %d",
+		ue.Supi, rg, remaining, threshold)
+
+	var announcementIdentifier int32
+	var notifyUri string
+	if lowBalance := factory.ChfConfig.Configuration.LowBalance; lowBalance != nil {
+		announcementIdentifier = lowBalance.AnnouncementIdentifier
+		notifyUri = lowBalance.NotifyUri
+	}
+
+	unitInformation.AnnouncementInformation = &models.AnnouncementInformation{
+		AnnouncementIdentifier: announcementIdentifier,
+		VariableParts: []models.VariablePart{
+			{
+				VariablePartType:  models.VariablePartType_CURRENCY,
+				VariablePartValue: []string{remaining.String()},
+			},
+		},
+		PlayToParty: models.PlayToParty_SERVED,
+	}
+
+	if notifyUri == "" {
+		return
+	}
+	notification := LowBalanceNotification{
+		Supi:             ue.Supi,
+		RatingGroup:      rg,
+		ChargingDataRef:  session.ChargingDataRef,
+		RemainingBalance: remaining.String(),
+		CurrencyCode:     currencyCode,
+		Threshold:        threshold,
+		TimeStamp:        time.Now(),
+	}
+	go sendLowBalanceNotification(notifyUri, notification)
+}
+
+func sendLowBalanceNotification(notifyUri string, notification LowBalanceNotification) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		logger.NotifyEventLog.Errorf("Low balance notification marshal error: %+v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lowBalanceNotifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifyUri, bytes.NewReader(body))
+	if err != nil {
+		logger.NotifyEventLog.Errorf("Low balance notification request error: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	rsp, err := client.Do(req)
+	if err != nil {
+		logger.NotifyEventLog.Warnf("Low balance notification failed[%s]", err.Error())
+		return
+	}
+	defer func() {
+		if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+			logger.NotifyEventLog.Errorf("Low balance notification response body cannot close: %+v", rspCloseErr)
+		}
+	}()
+
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		logger.NotifyEventLog.Warnf("Low balance notification to %s rejected: %s", notifyUri, rsp.Status)
+		return
+	}
+	logger.NotifyEventLog.Tracef("Low balance notification success")
+}
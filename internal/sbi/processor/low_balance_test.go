@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestRecordRemainingBalance(t *testing.T) {
+	factory.ChfConfig = &factory.Config{
+		Configuration: &factory.Configuration{
+			LowBalance: &factory.LowBalance{
+				Thresholds:             []int64{100, 500},
+				AnnouncementIdentifier: 7,
+			},
+		},
+	}
+	ue := &chf_context.ChfUe{
+		Supi:                 "imsi-208930000000001",
+		RemainingBalance:     make(map[int32]money.Money),
+		LowBalanceThresholds: factory.ChfConfig.Configuration.LowBalanceThresholds("imsi-208930000000001"),
+		LowBalanceLevel:      make(map[int32]int),
+	}
+	session := chf_context.NewChargingSession("")
+
+	testCases := []struct {
+		name      string
+		balance   int64
+		announced bool
+	}{
+		{
+			name:    "above thresholds",
+			balance: 1000,
+		},
+		{
+			name:      "first threshold crossed",
+			balance:   400,
+			announced: true,
+		},
+		{
+			name:    "still under first threshold",
+			balance: 300,
+		},
+		{
+			name:      "second threshold crossed",
+			balance:   50,
+			announced: true,
+		},
+		{
+			name:    "recharged",
+			balance: 2000,
+		},
+		{
+			name:      "crossed again after recharge",
+			balance:   80,
+			announced: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			acctDebitRsp := &charging_datatype.AccountDebitResponse{
+				RemainingBalance: &charging_datatype.RemainingBalance{
+					UnitValue: &charging_datatype.UnitValue{
+						ValueDigits: datatype.Integer64(tc.balance),
+					},
+				},
+			}
+			var unitInformation models.MultipleUnitInformation
+			recordRemainingBalance(ue, session, 1, acctDebitRsp, &unitInformation)
+
+			require.Equal(t, money.FromInt(tc.balance), ue.RemainingBalance[1])
+			if !tc.announced {
+				require.Nil(t, unitInformation.AnnouncementInformation)
+				return
+			}
+			require.NotNil(t, unitInformation.AnnouncementInformation)
+			require.Equal(t, int32(7), unitInformation.AnnouncementInformation.AnnouncementIdentifier)
+		})
+	}
+}
+
+func TestSendLowBalanceNotification(t *testing.T) {
+	received := make(chan LowBalanceNotification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var notification LowBalanceNotification
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&notification))
+		received <- notification
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sendLowBalanceNotification(server.URL, LowBalanceNotification{Supi: "imsi-208930000000001", RatingGroup: 1})
+	notification := <-received
+	require.Equal(t, "imsi-208930000000001", notification.Supi)
+	require.Equal(t, int32(1), notification.RatingGroup)
+}
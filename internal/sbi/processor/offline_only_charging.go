@@ -0,0 +1,249 @@
+package processor
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/cdr/cdrType"
+	"github.com/free5gc/chf/internal/cgf"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+func (p *Processor) HandleOfflineChargingdataInitial(
+	c *gin.Context,
+	chargingdata models.ChfOfflineOnlyChargingChargingDataRequest,
+) {
+	logger.ChargingdataPostLog.Infof("HandleOfflineChargingdataInitial")
+	response, locationURI, problemDetails := p.OfflineChargingDataCreate(chargingdata)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Header("Location", locationURI)
+	c.JSON(http.StatusCreated, response)
+}
+
+func (p *Processor) HandleOfflineChargingdataUpdate(
+	c *gin.Context,
+	chargingdata models.ChfOfflineOnlyChargingChargingDataRequest,
+	chargingSessionId string,
+) {
+	logger.ChargingdataPostLog.Infof("HandleOfflineChargingdataUpdate")
+	response, problemDetails := p.OfflineChargingDataUpdate(chargingdata, chargingSessionId)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (p *Processor) HandleOfflineChargingdataRelease(
+	c *gin.Context,
+	chargingdata models.ChfOfflineOnlyChargingChargingDataRequest,
+	chargingSessionId string,
+) {
+	logger.ChargingdataPostLog.Infof("HandleOfflineChargingdataRelease")
+	problemDetails := p.OfflineChargingDataRelease(chargingdata, chargingSessionId)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Offline only charging only records the reported usage into CDRs,
+// neither the rating function nor the ABMF is contacted
+func (p *Processor) OfflineChargingDataCreate(
+	offlineChargingData models.ChfOfflineOnlyChargingChargingDataRequest,
+) (*models.ChfOfflineOnlyChargingChargingDataResponse, string, *models.ProblemDetails) {
+	chargingData, err := convergedChargingDataOf(offlineChargingData)
+	if err != nil {
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+
+	self := chf_context.GetSelf()
+	ueId := chargingData.SubscriberIdentifier
+	ue, err := self.NewCHFUe(ueId)
+	if err != nil {
+		logger.ChargingdataPostLog.Errorf("New CHFUe error %s", err)
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error(), models.InvalidParam{
+			Param:  "/subscriberIdentifier",
+			Reason: "only IMSI based SUPI is supported",
+		}).ProblemDetails()
+	}
+
+	ue.CULock.Lock()
+
+	var consumerId string
+	if chargingData.NfConsumerIdentification != nil {
+		consumerId = chargingData.NfConsumerIdentification.NFName
+	}
+	chargingSessionId := ueId + consumerId + strconv.Itoa(int(self.LocalRecordSequenceNumber))
+	cdr, err := p.OpenCDR(chargingData, ue, chargingSessionId, false)
+	if err != nil {
+		ue.CULock.Unlock()
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+	if err = p.UpdateCDR(cdr, chargingData); err != nil {
+		ue.CULock.Unlock()
+		return nil, "", NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+
+	ue.Cdr[chargingSessionId] = cdr
+	ue.Records = append(ue.Records, cdr)
+
+	session := ue.NewChargingSession(chargingSessionId)
+	session.OfflineOnly = true
+	session.LastInvocationSequenceNumber = chargingData.InvocationSequenceNumber
+	for _, unitUsage := range chargingData.MultipleUnitUsage {
+		session.AddRatingGroup(unitUsage.RatingGroup)
+		countReportedVolume(ueId, unitUsage)
+	}
+	ue.CULock.Unlock()
+
+	if err = cgf.SendCDR(ueId); err != nil {
+		logger.ChargingdataPostLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
+	}
+
+	logger.ChargingdataPostLog.Infof("Open offline only CDR for UE %s", ueId)
+	locationURI := self.Url + factory.OfflineOnlyChargingResUriPrefix + "/offlinechargingdata/" + chargingSessionId
+
+	return offlineChargingDataResponse(chargingData), locationURI, nil
+}
+
+func (p *Processor) OfflineChargingDataUpdate(
+	offlineChargingData models.ChfOfflineOnlyChargingChargingDataRequest, chargingSessionId string,
+) (*models.ChfOfflineOnlyChargingChargingDataResponse, *models.ProblemDetails) {
+	chargingData, err := convergedChargingDataOf(offlineChargingData)
+	if err != nil {
+		return nil, NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+
+	self := chf_context.GetSelf()
+	ueId := chargingData.SubscriberIdentifier
+	ue, ok := self.ChfUeFindBySupi(ueId)
+	if !ok {
+		logger.ChargingdataPostLog.Errorf("CHFUe[%s] not found", ueId)
+		return nil, userUnknown(ueId)
+	}
+
+	ue.CULock.Lock()
+	defer ue.CULock.Unlock()
+
+	session, ok := ue.ChargingSessionFind(chargingSessionId)
+	if !ok || !session.OfflineOnly {
+		logger.ChargingdataPostLog.Errorf("Offline charging session[%s] of CHFUe[%s] not found", chargingSessionId, ueId)
+		return nil, chargingDataRefNotFound(chargingSessionId)
+	}
+
+	// The usage of a retransmitted request is already recorded
+	seqNum := chargingData.InvocationSequenceNumber
+	if seqNum != 0 && seqNum == session.LastInvocationSequenceNumber {
+		logger.ChargingdataPostLog.Warnf("Offline charging session[%s]: duplicate invocation sequence number %d",
+			chargingSessionId, seqNum)
+		return offlineChargingDataResponse(chargingData), nil
+	}
+	if problemDetails := checkInvocationSequenceNumber(session, seqNum); problemDetails != nil {
+		return nil, problemDetails
+	}
+	session.LastActivity = time.Now()
+
+	if _, problemDetails := p.updateSessionCDR(ue, chargingSessionId, chargingData); problemDetails != nil {
+		return nil, problemDetails
+	}
+	for _, unitUsage := range chargingData.MultipleUnitUsage {
+		session.AddRatingGroup(unitUsage.RatingGroup)
+		countReportedVolume(ueId, unitUsage)
+	}
+
+	if err = dumpCdrFile(ueId, ue.Records); err != nil {
+		return nil, NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+	if err = cgf.SendCDR(ueId); err != nil {
+		logger.ChargingdataPostLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
+	}
+	session.LastInvocationSequenceNumber = seqNum
+
+	return offlineChargingDataResponse(chargingData), nil
+}
+
+func (p *Processor) OfflineChargingDataRelease(
+	offlineChargingData models.ChfOfflineOnlyChargingChargingDataRequest, chargingSessionId string,
+) *models.ProblemDetails {
+	chargingData, err := convergedChargingDataOf(offlineChargingData)
+	if err != nil {
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+
+	self := chf_context.GetSelf()
+	ueId := chargingData.SubscriberIdentifier
+	ue, ok := self.ChfUeFindBySupi(ueId)
+	if !ok {
+		logger.ChargingdataPostLog.Errorf("Do not find CHFUe[%s] error", ueId)
+		return userUnknown(ueId)
+	}
+
+	ue.CULock.Lock()
+	defer ue.CULock.Unlock()
+
+	session, ok := ue.ChargingSessionFind(chargingSessionId)
+	if !ok || !session.OfflineOnly {
+		logger.ChargingdataPostLog.Errorf("Offline charging session[%s] of CHFUe[%s] not found", chargingSessionId, ueId)
+		return chargingDataRefNotFound(chargingSessionId)
+	}
+	seqNum := chargingData.InvocationSequenceNumber
+	if problemDetails := checkInvocationSequenceNumber(session, seqNum); problemDetails != nil {
+		return problemDetails
+	}
+	for _, unitUsage := range chargingData.MultipleUnitUsage {
+		countReportedVolume(ueId, unitUsage)
+	}
+
+	cdr := ue.Cdr[chargingSessionId]
+	if err = p.UpdateCDR(cdr, chargingData); err != nil {
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+	if err = p.CloseCDR(cdr, false); err != nil {
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+	if err = dumpCdrFile(ueId, []*cdrType.CHFRecord{cdr}); err != nil {
+		return NewChargingError(CauseChargingFailed, err.Error()).ProblemDetails()
+	}
+
+	ue.DeleteChargingSession(chargingSessionId)
+	delete(ue.Cdr, chargingSessionId)
+
+	return nil
+}
+
+// The offline only charging data shares its attributes with the converged charging data,
+// so that the CDRs are generated the same way
+func convergedChargingDataOf(
+	offlineChargingData models.ChfOfflineOnlyChargingChargingDataRequest,
+) (models.ChfConvergedChargingChargingDataRequest, error) {
+	var chargingData models.ChfConvergedChargingChargingDataRequest
+
+	raw, err := json.Marshal(offlineChargingData)
+	if err != nil {
+		return chargingData, err
+	}
+	err = json.Unmarshal(raw, &chargingData)
+	return chargingData, err
+}
+
+func offlineChargingDataResponse(
+	chargingData models.ChfConvergedChargingChargingDataRequest,
+) *models.ChfOfflineOnlyChargingChargingDataResponse {
+	timeStamp := time.Now()
+	return &models.ChfOfflineOnlyChargingChargingDataResponse{
+		InvocationTimeStamp:      &timeStamp,
+		InvocationSequenceNumber: chargingData.InvocationSequenceNumber,
+	}
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestConvergedChargingDataOf(t *testing.T) {
+	offlineChargingData := models.ChfOfflineOnlyChargingChargingDataRequest{
+		SubscriberIdentifier:     "imsi-208930000000001",
+		InvocationSequenceNumber: 2,
+		NfConsumerIdentification: &models.ChfOfflineOnlyChargingNfIdentification{
+			NFName: "smf",
+		},
+		MultipleUnitUsage: []models.ChfOfflineOnlyChargingMultipleUnitUsage{
+			{
+				RatingGroup: 1,
+				UsedUnitContainer: []models.ChfOfflineOnlyChargingUsedUnitContainer{
+					{TotalVolume: 1000, UplinkVolume: 400, DownlinkVolume: 600},
+				},
+			},
+		},
+	}
+
+	chargingData, err := convergedChargingDataOf(offlineChargingData)
+	require.NoError(t, err)
+	require.Equal(t, "imsi-208930000000001", chargingData.SubscriberIdentifier)
+	require.Equal(t, int32(2), chargingData.InvocationSequenceNumber)
+	require.Equal(t, "smf", chargingData.NfConsumerIdentification.NFName)
+	require.Len(t, chargingData.MultipleUnitUsage, 1)
+	require.Equal(t, int32(1), chargingData.MultipleUnitUsage[0].RatingGroup)
+	require.Equal(t, int32(1000), chargingData.MultipleUnitUsage[0].UsedUnitContainer[0].TotalVolume)
+}
+
+func TestOfflineOnlyCharging(t *testing.T) {
+	peers := &chargingPeers{unitCost: money.FromInt(1), balance: money.FromInt(100000)}
+	p := setUpCharging(t, peers)
+	usage := func(seqNum int32) models.ChfOfflineOnlyChargingChargingDataRequest {
+		return models.ChfOfflineOnlyChargingChargingDataRequest{
+			SubscriberIdentifier:     testSupi,
+			InvocationSequenceNumber: seqNum,
+			NfConsumerIdentification: &models.ChfOfflineOnlyChargingNfIdentification{NFName: "SMF"},
+			MultipleUnitUsage: []models.ChfOfflineOnlyChargingMultipleUnitUsage{
+				{
+					RatingGroup:       1,
+					UsedUnitContainer: []models.ChfOfflineOnlyChargingUsedUnitContainer{{TotalVolume: 1000}},
+				},
+			},
+		}
+	}
+
+	_, location, problemDetails := p.OfflineChargingDataCreate(usage(0))
+	require.Nil(t, problemDetails)
+	chargingDataRef := location[strings.LastIndex(location, "/")+1:]
+
+	_, problemDetails = p.OfflineChargingDataUpdate(usage(1), chargingDataRef)
+	require.Nil(t, problemDetails)
+
+	// The offline only session is not a converged charging session
+	_, problemDetails = p.ChargingDataUpdate(chargingDataOf(2, onlineUsage(1, 1000, 1000)), chargingDataRef)
+	require.NotNil(t, problemDetails)
+	require.Equal(t, CauseChargingDataRefNotFound, problemDetails.Cause)
+
+	problemDetails = p.OfflineChargingDataRelease(usage(2), chargingDataRef)
+	require.Nil(t, problemDetails)
+
+	// The usage is only recorded, neither rated nor debited
+	require.Empty(t, peers.rated)
+	require.Empty(t, peers.debited)
+	ue, ok := chf_context.GetSelf().ChfUeFindBySupi(testSupi)
+	require.True(t, ok)
+	_, found := ue.ChargingSessionFind(chargingDataRef)
+	require.False(t, found)
+}
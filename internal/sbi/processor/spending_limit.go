@@ -0,0 +1,393 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/policycounter"
+	"github.com/free5gc/chf/internal/util"
+	"github.com/free5gc/chf/pkg/factory"
+	Nchf_SpendingLimitControl "github.com/free5gc/openapi/chf/SpendingLimitControl"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/util/mongoapi"
+)
+
+const (
+	spendingLimitSubscriptionsColl = "chf.spendingLimitSubscriptions"
+	policyCountersColl             = "chf.policyCounters"
+)
+
+// Application errors of Nchf_SpendingLimitControl, TS 29.594 5.7.3, and TS 29.500 5.2.7.2
+const (
+	CauseNoAvailablePolicyCounters = "NO_AVAILABLE_POLICY_COUNTERS"
+	CauseUnknownPolicyCounters     = "UNKNOWN_POLICY_COUNTERS"
+	CauseMandatoryIeMissing        = "MANDATORY_IE_MISSING"
+	CauseMandatoryIeIncorrect      = "MANDATORY_IE_INCORRECT"
+	CauseContextNotFound           = "CONTEXT_NOT_FOUND"
+	CauseSystemFailure             = "SYSTEM_FAILURE"
+)
+
+func spendingLimitProblem(status int32, cause, detail string) *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Title:  cause,
+		Status: status,
+		Detail: detail,
+		Cause:  cause,
+	}
+}
+
+func (p *Processor) HandleSpendingLimitSubscriptionCreate(
+	c *gin.Context,
+	spendingLimitContext models.SpendingLimitContext,
+) {
+	logger.SpendingLimitLog.Infof("HandleSpendingLimitSubscriptionCreate")
+	status, locationURI, problemDetails := p.SpendingLimitSubscriptionCreate(spendingLimitContext)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Header("Location", locationURI)
+	c.JSON(http.StatusCreated, status)
+}
+
+func (p *Processor) HandleSpendingLimitSubscriptionUpdate(
+	c *gin.Context,
+	spendingLimitContext models.SpendingLimitContext,
+	subscriptionId string,
+) {
+	logger.SpendingLimitLog.Infof("HandleSpendingLimitSubscriptionUpdate")
+	status, problemDetails := p.SpendingLimitSubscriptionUpdate(spendingLimitContext, subscriptionId)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (p *Processor) HandleSpendingLimitSubscriptionDelete(c *gin.Context, subscriptionId string) {
+	logger.SpendingLimitLog.Infof("HandleSpendingLimitSubscriptionDelete")
+	problemDetails := p.SpendingLimitSubscriptionDelete(subscriptionId)
+	if problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (p *Processor) SpendingLimitSubscriptionCreate(
+	spendingLimitContext models.SpendingLimitContext,
+) (*models.SpendingLimitStatus, string, *models.ProblemDetails) {
+	if spendingLimitContext.NotifUri == "" {
+		return nil, "", spendingLimitProblem(http.StatusBadRequest, CauseMandatoryIeMissing,
+			"notifUri is required to subscribe to the policy counter status")
+	}
+	status, problemDetails := spendingLimitStatusOf(spendingLimitContext)
+	if problemDetails != nil {
+		return nil, "", problemDetails
+	}
+
+	subscription := &chf_context.SpendingLimitSubscription{
+		SubscriptionId: uuid.New().String(),
+		Context:        spendingLimitContext,
+	}
+	if err := storeSpendingLimitSubscription(subscription); err != nil {
+		logger.SpendingLimitLog.Errorf("Store spending limit subscription error: %+v", err)
+		return nil, "", spendingLimitProblem(http.StatusInternalServerError, CauseSystemFailure, err.Error())
+	}
+	self := chf_context.GetSelf()
+	self.AddSpendingLimitSubscription(subscription)
+	logger.SpendingLimitLog.Infof("UE[%s] spending limit subscription[%s] created",
+		spendingLimitContext.Supi, subscription.SubscriptionId)
+
+	locationURI := self.Url + factory.SpendingLimitControlResUriPrefix + "/subscriptions/" + subscription.SubscriptionId
+	return status, locationURI, nil
+}
+
+// The subscription is replaced by the new spending limit context, e.g. to change the subscribed policy counters
+func (p *Processor) SpendingLimitSubscriptionUpdate(
+	spendingLimitContext models.SpendingLimitContext, subscriptionId string,
+) (*models.SpendingLimitStatus, *models.ProblemDetails) {
+	self := chf_context.GetSelf()
+	subscription, ok := self.SpendingLimitSubscriptionFind(subscriptionId)
+	if !ok {
+		return nil, spendingLimitSubscriptionNotFound(subscriptionId)
+	}
+
+	// The subscriber and the notification target are kept if not given again, the subscriber cannot change
+	if spendingLimitContext.Supi == "" {
+		spendingLimitContext.Supi = subscription.Context.Supi
+	}
+	if spendingLimitContext.Supi != subscription.Context.Supi {
+		return nil, spendingLimitProblem(http.StatusBadRequest, CauseMandatoryIeIncorrect,
+			"supi differs from the supi of the subscription "+subscriptionId)
+	}
+	if spendingLimitContext.NotifUri == "" {
+		spendingLimitContext.NotifUri = subscription.Context.NotifUri
+	}
+	status, problemDetails := spendingLimitStatusOf(spendingLimitContext)
+	if problemDetails != nil {
+		return nil, problemDetails
+	}
+
+	updated := &chf_context.SpendingLimitSubscription{
+		SubscriptionId: subscriptionId,
+		Context:        spendingLimitContext,
+	}
+	if err := storeSpendingLimitSubscription(updated); err != nil {
+		logger.SpendingLimitLog.Errorf("Store spending limit subscription error: %+v", err)
+		return nil, spendingLimitProblem(http.StatusInternalServerError, CauseSystemFailure, err.Error())
+	}
+	self.AddSpendingLimitSubscription(updated)
+	logger.SpendingLimitLog.Infof("UE[%s] spending limit subscription[%s] updated",
+		spendingLimitContext.Supi, subscriptionId)
+
+	return status, nil
+}
+
+func (p *Processor) SpendingLimitSubscriptionDelete(subscriptionId string) *models.ProblemDetails {
+	self := chf_context.GetSelf()
+	if _, ok := self.SpendingLimitSubscriptionFind(subscriptionId); !ok {
+		return spendingLimitSubscriptionNotFound(subscriptionId)
+	}
+
+	if err := mongoapi.RestfulAPIDeleteOne(spendingLimitSubscriptionsColl,
+		bson.M{"subscriptionId": subscriptionId}); err != nil {
+		logger.SpendingLimitLog.Errorf("Delete spending limit subscription error: %+v", err)
+		return spendingLimitProblem(http.StatusInternalServerError, CauseSystemFailure, err.Error())
+	}
+	self.DeleteSpendingLimitSubscription(subscriptionId)
+	logger.SpendingLimitLog.Infof("Spending limit subscription[%s] deleted", subscriptionId)
+
+	return nil
+}
+
+// StartSpendingLimitControl reloads the policy counters and the subscriptions stored before the CHF restarted,
+// then persists the policy counters and notifies the subscribed PCFs as they are evaluated
+func (p *Processor) StartSpendingLimitControl() {
+	self := chf_context.GetSelf()
+	self.PolicyCounters.Updated = storePolicyCounter
+	self.PolicyCounters.StatusChanged = p.NotifySpendingLimitStatus
+	self.PolicyCounters.Removed = p.TerminateSpendingLimitSubscriptions
+
+	restorePolicyCounters()
+	restoreSpendingLimitSubscriptions()
+}
+
+func restoreSpendingLimitSubscriptions() {
+	stored, err := mongoapi.RestfulAPIGetMany(spendingLimitSubscriptionsColl, bson.M{})
+	if err != nil {
+		logger.SpendingLimitLog.Errorf("Restore spending limit subscriptions error: %+v", err)
+		return
+	}
+
+	self := chf_context.GetSelf()
+	now := time.Now()
+	for _, data := range stored {
+		var subscription chf_context.SpendingLimitSubscription
+		raw, errMarshal := bson.Marshal(data)
+		if errMarshal != nil {
+			logger.SpendingLimitLog.Errorf("Spending limit subscription marshal error: %+v", errMarshal)
+			continue
+		}
+		if errUnmarshal := bson.Unmarshal(raw, &subscription); errUnmarshal != nil {
+			logger.SpendingLimitLog.Errorf("Spending limit subscription unmarshal error: %+v", errUnmarshal)
+			continue
+		}
+		if expiry := subscription.Context.Expiry; expiry != nil && expiry.Before(now) {
+			logger.SpendingLimitLog.Infof("Spending limit subscription[%s] expired", subscription.SubscriptionId)
+			continue
+		}
+		self.AddSpendingLimitSubscription(&subscription)
+	}
+	logger.SpendingLimitLog.Infof("%d spending limit subscriptions restored", len(stored))
+}
+
+func storeSpendingLimitSubscription(subscription *chf_context.SpendingLimitSubscription) error {
+	raw, err := bson.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+	data := make(bson.M)
+	if err = bson.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	_, err = mongoapi.RestfulAPIPutOne(spendingLimitSubscriptionsColl,
+		bson.M{"subscriptionId": subscription.SubscriptionId}, data)
+	return err
+}
+
+func spendingLimitSubscriptionNotFound(subscriptionId string) *models.ProblemDetails {
+	return spendingLimitProblem(http.StatusNotFound, CauseContextNotFound,
+		"Spending limit subscription "+subscriptionId+" does not exist")
+}
+
+// Status of the policy counters of the spending limit context,
+// every policy counter of the subscriber is reported if none is requested
+func spendingLimitStatusOf(
+	spendingLimitContext models.SpendingLimitContext,
+) (*models.SpendingLimitStatus, *models.ProblemDetails) {
+	supi := spendingLimitContext.Supi
+	if supi == "" {
+		return nil, spendingLimitProblem(http.StatusBadRequest, CauseMandatoryIeMissing,
+			"supi is required to subscribe to the policy counter status")
+	}
+
+	policyCounters := factory.ChfConfig.Configuration.PolicyCounters(supi)
+	if len(policyCounters) == 0 {
+		return nil, spendingLimitProblem(http.StatusBadRequest, CauseNoAvailablePolicyCounters,
+			"No policy counter is maintained for "+supi)
+	}
+
+	self := chf_context.GetSelf()
+	now := time.Now()
+	status := &models.SpendingLimitStatus{
+		Supi:        supi,
+		NotifId:     spendingLimitContext.NotifId,
+		StatusInfos: make(map[string]models.PolicyCounterInfo),
+		Expiry:      spendingLimitContext.Expiry,
+	}
+	for _, policyCounter := range policyCounters {
+		if !isSubscribedPolicyCounter(spendingLimitContext, policyCounter.PolicyCounterId) {
+			continue
+		}
+		status.StatusInfos[policyCounter.PolicyCounterId] = self.PolicyCounters.Info(supi, policyCounter, now)
+	}
+	if len(status.StatusInfos) == 0 {
+		return nil, spendingLimitProblem(http.StatusBadRequest, CauseUnknownPolicyCounters,
+			"None of the requested policy counters is maintained for "+supi)
+	}
+	return status, nil
+}
+
+func isSubscribedPolicyCounter(spendingLimitContext models.SpendingLimitContext, policyCounterId string) bool {
+	if len(spendingLimitContext.PolicyCounterIds) == 0 {
+		return true
+	}
+	for _, subscribed := range spendingLimitContext.PolicyCounterIds {
+		if subscribed == policyCounterId {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifySpendingLimitStatus notifies the PCFs subscribed to the policy counters whose status changed
+func (p *Processor) NotifySpendingLimitStatus(supi string, infos []models.PolicyCounterInfo) {
+	self := chf_context.GetSelf()
+	for _, subscription := range self.SpendingLimitSubscriptionsOf(supi) {
+		status := models.SpendingLimitStatus{
+			Supi:        supi,
+			NotifId:     subscription.Context.NotifId,
+			StatusInfos: make(map[string]models.PolicyCounterInfo),
+		}
+		for _, info := range infos {
+			if isSubscribedPolicyCounter(subscription.Context, info.PolicyCounterId) {
+				status.StatusInfos[info.PolicyCounterId] = info
+			}
+		}
+		if len(status.StatusInfos) == 0 {
+			continue
+		}
+		go sendSpendingLimitNotification(subscription.Context.NotifUri, status)
+	}
+}
+
+func sendSpendingLimitNotification(notifUri string, status models.SpendingLimitStatus) {
+	client := util.GetNchfSpendingLimitNotificationClient()
+	logger.SpendingLimitLog.Infof("Send spending limit notification to PCF: uri: %s", notifUri)
+	request := &Nchf_SpendingLimitControl.NullStatusNotificationPostRequest{}
+	request.SetSpendingLimitStatus(status)
+	_, err := client.DefaultApi.NullStatusNotificationPost(context.Background(), notifUri+"/notify", request)
+	if err != nil {
+		logger.SpendingLimitLog.Warnf("Spending limit notification failed[%s]", err.Error())
+		return
+	}
+	logger.SpendingLimitLog.Tracef("Spending limit notification success")
+}
+
+// TerminateSpendingLimitSubscriptions ends the subscriptions to a removed subscriber
+func (p *Processor) TerminateSpendingLimitSubscriptions(supi string) {
+	if err := mongoapi.RestfulAPIDeleteMany(policyCountersColl, bson.M{"supi": supi}); err != nil {
+		logger.SpendingLimitLog.Errorf("Delete policy counters error: %+v", err)
+	}
+
+	self := chf_context.GetSelf()
+	for _, subscription := range self.SpendingLimitSubscriptionsOf(supi) {
+		subscriptionId := subscription.SubscriptionId
+		if err := mongoapi.RestfulAPIDeleteOne(spendingLimitSubscriptionsColl,
+			bson.M{"subscriptionId": subscriptionId}); err != nil {
+			logger.SpendingLimitLog.Errorf("Delete spending limit subscription error: %+v", err)
+		}
+		self.DeleteSpendingLimitSubscription(subscriptionId)
+		logger.SpendingLimitLog.Infof("UE[%s] removed, spending limit subscription[%s] terminated", supi, subscriptionId)
+
+		terminationInfo := models.SubscriptionTerminationInfo{
+			Supi:      supi,
+			NotifId:   subscription.Context.NotifId,
+			TermCause: models.ChfSpendingLimitControlTerminationCause_REMOVED_SUBSCRIBER,
+		}
+		go sendSpendingLimitTermination(subscription.Context.NotifUri, terminationInfo)
+	}
+}
+
+func sendSpendingLimitTermination(notifUri string, terminationInfo models.SubscriptionTerminationInfo) {
+	client := util.GetNchfSpendingLimitNotificationClient()
+	logger.SpendingLimitLog.Infof("Send spending limit termination to PCF: uri: %s", notifUri)
+	request := &Nchf_SpendingLimitControl.NullSubscriptionTerminationPostRequest{}
+	request.SetSubscriptionTerminationInfo(terminationInfo)
+	_, err := client.DefaultApi.NullSubscriptionTerminationPost(context.Background(), notifUri+"/terminate", request)
+	if err != nil {
+		logger.SpendingLimitLog.Warnf("Spending limit termination failed[%s]", err.Error())
+		return
+	}
+	logger.SpendingLimitLog.Tracef("Spending limit termination success")
+}
+
+func storePolicyCounter(counter policycounter.Counter) {
+	raw, err := bson.Marshal(counter)
+	if err != nil {
+		logger.SpendingLimitLog.Errorf("Policy counter marshal error: %+v", err)
+		return
+	}
+	data := make(bson.M)
+	if err = bson.Unmarshal(raw, &data); err != nil {
+		logger.SpendingLimitLog.Errorf("Policy counter unmarshal error: %+v", err)
+		return
+	}
+	filter := bson.M{"supi": counter.Supi, "policyCounterId": counter.PolicyCounterId}
+	if _, err = mongoapi.RestfulAPIPutOne(policyCountersColl, filter, data); err != nil {
+		logger.SpendingLimitLog.Errorf("Store policy counter error: %+v", err)
+	}
+}
+
+func restorePolicyCounters() {
+	stored, err := mongoapi.RestfulAPIGetMany(policyCountersColl, bson.M{})
+	if err != nil {
+		logger.SpendingLimitLog.Errorf("Restore policy counters error: %+v", err)
+		return
+	}
+
+	var counters []policycounter.Counter
+	for _, data := range stored {
+		var counter policycounter.Counter
+		raw, errMarshal := bson.Marshal(data)
+		if errMarshal != nil {
+			logger.SpendingLimitLog.Errorf("Policy counter marshal error: %+v", errMarshal)
+			continue
+		}
+		if errUnmarshal := bson.Unmarshal(raw, &counter); errUnmarshal != nil {
+			logger.SpendingLimitLog.Errorf("Policy counter unmarshal error: %+v", errUnmarshal)
+			continue
+		}
+		counters = append(counters, counter)
+	}
+	chf_context.GetSelf().PolicyCounters.Restore(counters)
+	logger.SpendingLimitLog.Infof("%d policy counters restored", len(counters))
+}
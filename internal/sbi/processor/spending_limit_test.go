@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/policycounter"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestSpendingLimitStatusOf(t *testing.T) {
+	factory.ChfConfig = &factory.Config{
+		Configuration: &factory.Configuration{
+			SpendingLimitControl: &factory.SpendingLimitControl{
+				PolicyCounters: []*factory.PolicyCounter{
+					{PolicyCounterId: "monthlyVolume"},
+					{PolicyCounterId: "monthlySpend", InitialStatus: "normal"},
+				},
+				Profiles: []*factory.PolicyCounterProfile{
+					{Supi: "imsi-208930000000002", PolicyCounterIds: []string{"monthlySpend"}},
+					{Supi: "imsi-208930000000003"},
+				},
+			},
+		},
+	}
+
+	chf_context.GetSelf().PolicyCounters = policycounter.NewEvaluator()
+
+	testCases := []struct {
+		name     string
+		context  models.SpendingLimitContext
+		statuses map[string]string
+		cause    string
+	}{
+		{
+			name:     "every policy counter",
+			context:  models.SpendingLimitContext{Supi: "imsi-208930000000001"},
+			statuses: map[string]string{"monthlyVolume": "valid", "monthlySpend": "normal"},
+		},
+		{
+			name: "requested policy counters",
+			context: models.SpendingLimitContext{
+				Supi:             "imsi-208930000000001",
+				PolicyCounterIds: []string{"monthlyVolume", "unknown"},
+			},
+			statuses: map[string]string{"monthlyVolume": "valid"},
+		},
+		{
+			name:     "subscriber profile",
+			context:  models.SpendingLimitContext{Supi: "imsi-208930000000002"},
+			statuses: map[string]string{"monthlySpend": "normal"},
+		},
+		{
+			name: "unknown policy counters",
+			context: models.SpendingLimitContext{
+				Supi:             "imsi-208930000000002",
+				PolicyCounterIds: []string{"monthlyVolume"},
+			},
+			cause: CauseUnknownPolicyCounters,
+		},
+		{
+			name:    "no policy counter",
+			context: models.SpendingLimitContext{Supi: "imsi-208930000000003"},
+			cause:   CauseNoAvailablePolicyCounters,
+		},
+		{
+			name:    "missing supi",
+			context: models.SpendingLimitContext{},
+			cause:   CauseMandatoryIeMissing,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, problemDetails := spendingLimitStatusOf(tc.context)
+			if tc.cause != "" {
+				require.NotNil(t, problemDetails)
+				require.Equal(t, tc.cause, problemDetails.Cause)
+				return
+			}
+			require.Nil(t, problemDetails)
+			require.Len(t, status.StatusInfos, len(tc.statuses))
+			for policyCounterId, currentStatus := range tc.statuses {
+				require.Equal(t, currentStatus, status.StatusInfos[policyCounterId].CurrentStatus)
+			}
+		})
+	}
+}
+
+func TestSpendingLimitSubscriptionUpdateOfAnotherSupi(t *testing.T) {
+	self := chf_context.GetSelf()
+	self.AddSpendingLimitSubscription(&chf_context.SpendingLimitSubscription{
+		SubscriptionId: "1",
+		Context:        models.SpendingLimitContext{Supi: "imsi-208930000000001"},
+	})
+	t.Cleanup(func() { self.DeleteSpendingLimitSubscription("1") })
+
+	p, err := NewProcessor(nil)
+	require.NoError(t, err)
+	_, problemDetails := p.SpendingLimitSubscriptionUpdate(
+		models.SpendingLimitContext{Supi: "imsi-208930000000002"}, "1")
+	require.NotNil(t, problemDetails)
+	require.Equal(t, int32(http.StatusBadRequest), problemDetails.Status)
+	require.Equal(t, CauseMandatoryIeIncorrect, problemDetails.Cause)
+
+	// The subscription is kept as it was
+	subscription, ok := self.SpendingLimitSubscriptionFind("1")
+	require.True(t, ok)
+	require.Equal(t, "imsi-208930000000001", subscription.Context.Supi)
+}
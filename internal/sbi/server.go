@@ -96,6 +96,23 @@ func newRouter(s *Server) *gin.Engine {
 		}
 	}
 
+	// The self-care and customer-care APIs are not 3GPP services, they are neither registered to the NRF nor
+	// authorized by OAuth: only the clients presenting a configured token are served. The customer-care API
+	// exposes the balances of the subscribers, the self-care API answers no account data.
+	var tokens []string
+	if customerCare := s.Config().Configuration.CustomerCare; customerCare != nil {
+		tokens = customerCare.Tokens
+	}
+	tokenCheck := util.NewTokenAuthorizationCheck(tokens).Check
+
+	selfCareGroup := router.Group(factory.SelfCareResUriPrefix)
+	selfCareGroup.Use(tokenCheck)
+	applyRoutes(selfCareGroup, s.getSelfCareRoutes())
+
+	customerCareGroup := router.Group(factory.CustomerCareResUriPrefix)
+	customerCareGroup.Use(tokenCheck)
+	applyRoutes(customerCareGroup, s.getCustomerCareRoutes())
+
 	return router
 }
 
@@ -0,0 +1,117 @@
+package tariffcache
+
+import (
+	"sync"
+	"time"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+)
+
+// The expired tariffs of every subscriber are removed at most this often
+const purgeInterval = time.Minute
+
+// Key identifies the tariffs of a rating group of a subscriber in a service context
+type Key struct {
+	Supi        string
+	RatingGroup int32
+	// Service specification of the charging data, the same rating group may be rated differently per service
+	Context string
+}
+
+// Tariff is the rating answer of the rating function for a rating group, reused until it is not valid anymore
+type Tariff struct {
+	UnitCost          map[charging_datatype.CCUnitType]money.Money
+	NextUnitCost      map[charging_datatype.CCUnitType]money.Money
+	CurrencyCode      uint32
+	RatingTime        time.Time
+	TariffSwitch      time.Time
+	RequestedCounters []string
+
+	// The tariff is valid until the expiry time and, if ValidUnits is set,
+	// for this number of units of ValidUnitType
+	ExpiryTime    time.Time
+	ValidUnits    uint64
+	ValidUnitType charging_datatype.CCUnitType
+}
+
+// Valid tells if the tariff still applies at now: neither expired, nor switched nor used up
+func (t *Tariff) Valid(now time.Time) bool {
+	if !now.Before(t.ExpiryTime) {
+		return false
+	}
+	if !t.TariffSwitch.IsZero() && !now.Before(t.TariffSwitch) {
+		return false
+	}
+	return true
+}
+
+// Cache keeps the tariffs answered by the rating function, so that rating groups are not rated
+// again on each request of their charging sessions
+type Cache struct {
+	mu        sync.Mutex
+	tariffs   map[Key]*Tariff
+	lastPurge time.Time
+}
+
+func New() *Cache {
+	return &Cache{
+		tariffs: make(map[Key]*Tariff),
+	}
+}
+
+// Get returns the tariff of the key if it is still valid at now
+func (c *Cache) Get(key Key, now time.Time) (Tariff, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tariff, ok := c.tariffs[key]
+	if !ok {
+		return Tariff{}, false
+	}
+	if !tariff.Valid(now) {
+		delete(c.tariffs, key)
+		return Tariff{}, false
+	}
+	return *tariff, true
+}
+
+// Put caches the tariff, a tariff without expiry time is never valid and is not cached
+func (c *Cache) Put(key Key, tariff Tariff, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPurge) >= purgeInterval {
+		c.purge(now)
+	}
+	if !tariff.Valid(now) {
+		delete(c.tariffs, key)
+		return
+	}
+	c.tariffs[key] = &tariff
+}
+
+// Consume counts the units priced with the tariff of the key, the tariff is invalidated once its valid units are used
+func (c *Cache) Consume(key Key, unitType charging_datatype.CCUnitType, units uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tariff, ok := c.tariffs[key]
+	if !ok || tariff.ValidUnits == 0 || tariff.ValidUnitType != unitType || units == 0 {
+		return
+	}
+	if units >= tariff.ValidUnits {
+		delete(c.tariffs, key)
+		return
+	}
+	tariff.ValidUnits -= units
+}
+
+func (c *Cache) purge(now time.Time) {
+	for key, tariff := range c.tariffs {
+		if !tariff.Valid(now) {
+			delete(c.tariffs, key)
+		}
+	}
+	c.lastPurge = now
+}
@@ -0,0 +1,96 @@
+package tariffcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC)
+	key := Key{Supi: "imsi-208930000000001", RatingGroup: 1}
+	tariff := Tariff{
+		UnitCost:   map[charging_datatype.CCUnitType]money.Money{charging_datatype.TOTALOCTETS: money.New(5, -3)},
+		RatingTime: now,
+		ExpiryTime: now.Add(10 * time.Minute),
+	}
+
+	testCases := []struct {
+		name   string
+		tariff func(Tariff) Tariff
+		at     time.Duration
+		cached bool
+	}{
+		{
+			name:   "valid",
+			tariff: func(t Tariff) Tariff { return t },
+			at:     time.Minute,
+			cached: true,
+		},
+		{
+			name:   "expired",
+			tariff: func(t Tariff) Tariff { return t },
+			at:     10 * time.Minute,
+		},
+		{
+			name: "tariff switch reached",
+			tariff: func(t Tariff) Tariff {
+				t.TariffSwitch = now.Add(5 * time.Minute)
+				return t
+			},
+			at: 5 * time.Minute,
+		},
+		{
+			name:   "without expiry time",
+			tariff: func(t Tariff) Tariff { t.ExpiryTime = time.Time{}; return t },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := New()
+			cache.Put(key, tc.tariff(tariff), now)
+			_, ok := cache.Get(key, now.Add(tc.at))
+			require.Equal(t, tc.cached, ok)
+			// Another context of the rating group is rated on its own
+			_, ok = cache.Get(Key{Supi: key.Supi, RatingGroup: 1, Context: "sms"}, now)
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestCacheValidUnits(t *testing.T) {
+	now := time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC)
+	key := Key{Supi: "imsi-208930000000001", RatingGroup: 1}
+	cache := New()
+	cache.Put(key, Tariff{
+		ExpiryTime:    now.Add(time.Hour),
+		ValidUnits:    1000,
+		ValidUnitType: charging_datatype.TOTALOCTETS,
+	}, now)
+
+	// Units of another unit type do not use the valid units up
+	cache.Consume(key, charging_datatype.TIME, 5000)
+	cache.Consume(key, charging_datatype.TOTALOCTETS, 600)
+	tariff, ok := cache.Get(key, now)
+	require.True(t, ok)
+	require.Equal(t, uint64(400), tariff.ValidUnits)
+
+	cache.Consume(key, charging_datatype.TOTALOCTETS, 400)
+	_, ok = cache.Get(key, now)
+	require.False(t, ok)
+}
+
+func TestCachePurge(t *testing.T) {
+	now := time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC)
+	cache := New()
+	cache.Put(Key{Supi: "imsi-208930000000001"}, Tariff{ExpiryTime: now.Add(time.Minute)}, now)
+	cache.Put(Key{Supi: "imsi-208930000000002"}, Tariff{ExpiryTime: now.Add(time.Hour)}, now)
+
+	cache.Put(Key{Supi: "imsi-208930000000003"}, Tariff{ExpiryTime: now.Add(time.Hour)}, now.Add(2*time.Minute))
+	require.Len(t, cache.tariffs, 2)
+}
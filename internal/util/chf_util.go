@@ -2,6 +2,7 @@ package util
 
 import (
 	Nchf_ConvergedCharging "github.com/free5gc/openapi/chf/ConvergedCharging"
+	Nchf_SpendingLimitControl "github.com/free5gc/openapi/chf/SpendingLimitControl"
 )
 
 func GetNchfChargingNotificationCallbackClient() *Nchf_ConvergedCharging.APIClient {
@@ -9,3 +10,9 @@ func GetNchfChargingNotificationCallbackClient() *Nchf_ConvergedCharging.APIClie
 	client := Nchf_ConvergedCharging.NewAPIClient(configuration)
 	return client
 }
+
+func GetNchfSpendingLimitNotificationClient() *Nchf_SpendingLimitControl.APIClient {
+	configuration := Nchf_SpendingLimitControl.NewConfiguration()
+	client := Nchf_SpendingLimitControl.NewAPIClient(configuration)
+	return client
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+// TokenAuthorizationCheck authorizes the clients of the operator APIs presenting one of the configured bearer tokens
+type TokenAuthorizationCheck struct {
+	tokens []string
+}
+
+func NewTokenAuthorizationCheck(tokens []string) *TokenAuthorizationCheck {
+	return &TokenAuthorizationCheck{
+		tokens: tokens,
+	}
+}
+
+func (tac *TokenAuthorizationCheck) Check(c *gin.Context) {
+	if err := tac.authorize(c.Request.Header.Get("Authorization")); err != nil {
+		logger.UtilLog.Debugf("TokenAuthorizationCheck::Check Unauthorized: %s", err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	}
+
+	logger.UtilLog.Debugf("TokenAuthorizationCheck::Check Authorized")
+}
+
+func (tac *TokenAuthorizationCheck) authorize(authorization string) error {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found || token == "" {
+		return errors.New("missing bearer token")
+	}
+	for _, allowed := range tac.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			return nil
+		}
+	}
+	return errors.New("invalid token")
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAuthorizationCheck_Check(t *testing.T) {
+	tests := []struct {
+		name          string
+		tokens        []string
+		authorization string
+		statusCode    int
+	}{
+		{
+			name:          "Valid Token",
+			tokens:        []string{"first", "second"},
+			authorization: "Bearer second",
+			statusCode:    http.StatusOK,
+		},
+		{
+			name:          "Invalid Token",
+			tokens:        []string{"first"},
+			authorization: "Bearer second",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "Not A Bearer Token",
+			tokens:        []string{"first"},
+			authorization: "first",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			name:          "No Token Configured",
+			authorization: "Bearer ",
+			statusCode:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			var err error
+			c.Request, err = http.NewRequest("GET", "/", nil)
+			require.NoError(t, err)
+			c.Request.Header.Set("Authorization", tt.authorization)
+
+			NewTokenAuthorizationCheck(tt.tokens).Check(c)
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
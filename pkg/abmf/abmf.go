@@ -18,7 +18,7 @@ package abmf
 import (
 	"bytes"
 	"context"
-	"math"
+	"errors"
 	_ "net/http/pprof"
 	"strconv"
 	"sync"
@@ -28,16 +28,25 @@ import (
 	"github.com/fiorix/go-diameter/diam/datatype"
 	"github.com/fiorix/go-diameter/diam/dict"
 	"github.com/fiorix/go-diameter/diam/sm"
-	"go.mongodb.org/mongo-driver/bson"
 
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	charging_dict "github.com/free5gc/chf/ccs_diameter/dict"
+	"github.com/free5gc/chf/internal/account"
+	chf_context "github.com/free5gc/chf/internal/context"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/internal/policycounter"
+	"github.com/free5gc/chf/internal/ratingcounter"
 	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/util/mongoapi"
 )
 
-const chargingDatasColl = "policyData.ues.chargingData"
+// Accounts of the subscribers, set when the server is opened
+var accounts account.Store
+
+// A reservation expires once its charging session made no request for reservationTtl
+var reservationTtl = time.Duration(factory.ChfDefaultReservationTtl) * time.Second
 
 func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	// Load our custom dictionary on top of the default one, which
@@ -51,6 +60,11 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 		logger.InitLog.Errorf("InitpcfContext err: %+v", err)
 		return
 	}
+	accounts = account.NewMongoStore(mongodb.Name)
+
+	var sweepPeriod time.Duration
+	reservationTtl, sweepPeriod = reservationLedgerOf(factory.ChfConfig.Configuration.ReservationLedger)
+	go sweepReservations(ctx, sweepPeriod)
 
 	err := dict.Default.Load(bytes.NewReader([]byte(charging_dict.AbmfDictionary)))
 	if err != nil {
@@ -88,6 +102,61 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	}()
 }
 
+// reservationLedgerOf is the TTL of the reservations and the period of the sweeper configured
+func reservationLedgerOf(ledger *factory.ReservationLedger) (ttl, sweepPeriod time.Duration) {
+	ttl = time.Duration(factory.ChfDefaultReservationTtl) * time.Second
+	sweepPeriod = time.Duration(factory.ChfDefaultReservationSweepPeriod) * time.Second
+	if ledger != nil && ledger.Ttl > 0 {
+		ttl = time.Duration(ledger.Ttl) * time.Second
+	}
+	if ledger != nil && ledger.SweepPeriod > 0 {
+		sweepPeriod = time.Duration(ledger.SweepPeriod) * time.Second
+	}
+	return ttl, sweepPeriod
+}
+
+// sweepReservations returns the reservations of the charging sessions that went silent, e.g. of a CHF that
+// crashed, to the accounts until ctx is done. The returned reservations are kept for a TTL more so that
+// a late request of their session is still settled. The subscribers whose accounts were deleted meanwhile
+// are removed along.
+func sweepReservations(ctx context.Context, sweepPeriod time.Duration) {
+	ticker := time.NewTicker(sweepPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			returned, err := account.Sweep(accounts, now, reservationTtl)
+			if err != nil {
+				logger.AcctLog.Errorf("Sweep reservations err: %+v", err)
+			}
+			for _, reservation := range returned {
+				logger.AcctLog.Infof("UE [%s] Rating group [%d]: session [%s] expired, return %s and %d octets",
+					reservation.UeIds[0], reservation.RatingGroup, reservation.SessionId, reservation.Money,
+					reservation.Octets)
+			}
+			removeSubscribers(accounts, chf_context.GetSelf().PolicyCounters)
+		}
+	}
+}
+
+// removeSubscribers drops the policy counters of the subscribers without account anymore, which ends their
+// spending limit subscriptions
+func removeSubscribers(store account.Store, policyCounters *policycounter.Evaluator) {
+	for _, supi := range policyCounters.Subscribers() {
+		exists, err := store.HasAccount(supi)
+		if err != nil {
+			logger.AcctLog.Errorf("UE [%s] account error: %+v", supi, err)
+			continue
+		}
+		if !exists {
+			logger.AcctLog.Infof("UE [%s] has no account anymore, remove its policy counters", supi)
+			policyCounters.RemoveSubscriber(supi)
+		}
+	}
+}
+
 func printErrors(ec <-chan *diam.ErrorReport) {
 	for err := range ec {
 		logger.AcctLog.Errorf("Diam Error Report: %v", err)
@@ -113,6 +182,7 @@ func handleCCR() diam.HandlerFunc {
 		var cca charging_datatype.AccountDebitResponse
 		var subscriberId string
 		var creditControl *charging_datatype.MultipleServicesCreditControl
+		resultCode := uint32(diam.Success)
 
 		if err := m.Unmarshal(&ccr); err != nil {
 			logger.AcctLog.Errorf("Failed to parse message from %s: %s\n%s",
@@ -120,118 +190,258 @@ func handleCCR() diam.HandlerFunc {
 			return
 		}
 
-		switch ccr.SubscriptionId.SubscriptionIdType {
-		case charging_datatype.END_USER_IMSI:
-			subscriberId = "imsi-" + string(ccr.SubscriptionId.SubscriptionIdData)
+		subscriberIds := subscriberIdsOf(&ccr)
+		if len(subscriberIds) != 0 {
+			subscriberId = subscriberIds[0]
 		}
 
 		mscc := ccr.MultipleServicesCreditControl
 		rg := mscc.RatingGroup
 
-		// Retrieve quota into mongoDB
-		filter := bson.M{"ueId": subscriberId, "ratingGroup": rg}
-		queryStrength := 2
-		chargingInterface, err := mongoapi.RestfulAPIGetOne(chargingDatasColl, filter, queryStrength)
+		cca = charging_datatype.AccountDebitResponse{
+			SessionId:       ccr.SessionId,
+			ResultCode:      datatype.Unsigned32(resultCode),
+			OriginHost:      ccr.DestinationHost,
+			OriginRealm:     ccr.DestinationRealm,
+			CcRequestType:   ccr.CcRequestType,
+			CcRequestNumber: ccr.CcRequestNumber,
+			EventTimestamp:  datatype.Time(time.Now()),
+		}
 
-		if chargingInterface == nil {
-			logger.AcctLog.Errorf("chargingInterface is nil, err: %v", err)
+		stored, err := accounts.Get(subscriberIds, uint32(rg))
+		if err != nil {
+			logger.AcctLog.Errorf("UE [%s] Rating group [%d] account error: %+v", subscriberId, rg, err)
+			cca.ResultCode = diam.UnableToComply
+			writeCCA(c, m, &cca)
+			return
+		}
+		if stored == nil {
+			logger.AcctLog.Errorf("UE [%s] Rating group [%d] has no account", subscriberId, rg)
+			cca.ResultCode = charging_code.UserUnknown
+			writeCCA(c, m, &cca)
 			return
 		}
 
-		quotaStr := chargingInterface["quota"].(string)
-		quota, err := strconv.ParseInt(quotaStr, 10, 64)
+		// Persist the counter impacts answered by the rating function and answer the counters of the subscriber
+		counters, err := ratingcounter.ApplyImpacts(subscriberId, ccr.ImpactOnCounter, time.Now())
 		if err != nil {
-			logger.AcctLog.Errorf("srtconv ParseInt error: %+v", err)
+			logger.AcctLog.Errorf("UE [%s] rating counters error: %+v", subscriberId, err)
+		} else if len(counters) != 0 {
+			cca.ABResponse = &charging_datatype.ABResponse{}
+			for i := range counters {
+				cca.ABResponse.Counter = append(cca.ABResponse.Counter, counters[i].Avp(time.Now()))
+			}
+		}
+
+		// The amounts requested are only debited from or refunded to an account of the same currency
+		currencyCode := stored.CurrencyCode
+		var requestedAmount, usedAmount money.Money
+		var requestedOctets, usedOctets uint64
+		requestedCurrency, usedCurrency := currencyCode, currencyCode
+		if rsu := mscc.RequestedServiceUnit; rsu != nil {
+			if rsu.CCMoney != nil {
+				requestedAmount, requestedCurrency = money.FromCCMoney(rsu.CCMoney)
+			}
+			requestedOctets = uint64(rsu.CCTotalOctets)
+		}
+		if usu := mscc.UsedServiceUnit; usu != nil {
+			if usu.CCMoney != nil {
+				usedAmount, usedCurrency = money.FromCCMoney(usu.CCMoney)
+			}
+			usedOctets = uint64(usu.CCTotalOctets)
+		}
+		if requestedCurrency != currencyCode || usedCurrency != currencyCode {
+			logger.AcctLog.Errorf("UE [%s] Rating group [%d] account in currency %d, requested in %d and %d",
+				subscriberId, rg, currencyCode, requestedCurrency, usedCurrency)
+			cca.ResultCode = charging_code.RatingFailed
+			writeCCA(c, m, &cca)
 			return
 		}
 
+		// The balance is changed atomically, concurrent requests on the account are applied one after the other
+		now := time.Now()
+		var change account.Change
+		var granted account.Units
+		// The units reserved are held for the session until it commits or releases them, or they expire
+		session := account.Session{Id: string(ccr.SessionId), UeIds: subscriberIds, TTL: reservationTtl}
+		used := account.Units{Money: usedAmount, Octets: usedOctets}
 		switch ccr.RequestedAction {
 		case charging_datatype.CHECK_BALANCE:
-			logger.AcctLog.Errorf("CHECK_BALANCE not supported")
+			// The money balance of the account is answered along with each bucket the rating group can consume and
+			// the money its sessions hold, nothing is reserved nor debited
+			cca.RemainingBalance = &charging_datatype.RemainingBalance{
+				UnitValue:    stored.Available(account.UnitTypeMoney, now).UnitValue(),
+				CurrencyCode: datatype.Unsigned32(currencyCode),
+			}
+			if cca.ABResponse == nil {
+				cca.ABResponse = &charging_datatype.ABResponse{}
+			}
+			for i := range stored.Buckets {
+				bucket := &stored.Buckets[i]
+				if !bucket.AppliesTo(uint32(rg), now) {
+					continue
+				}
+				cca.ABResponse.AcctBalance = append(cca.ABResponse.AcctBalance, &charging_datatype.AcctBalance{
+					AcctBalanceId: datatype.Unsigned64(bucket.BucketId),
+					UnitValue:     bucket.Balance.UnitValue(),
+				})
+			}
+			// A barred account still answers its balance, the rating group is denied
+			if stored.Barred {
+				cca.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
+					RatingGroup: rg,
+					ResultCode:  datatype.Unsigned32(charging_code.EndUserServiceDenied),
+				}
+			}
+			reserved, sessions := stored.Reserved()
+			cca.ABResponse.ReservedBalance = &charging_datatype.ReservedBalance{
+				UnitValue:        reserved.UnitValue(),
+				ReservationCount: datatype.Unsigned32(sessions),
+			}
+			writeCCA(c, m, &cca)
+			return
 		case charging_datatype.PRICE_ENQUIRY:
+			// Prices are enquired from the rating function, the account is neither rated nor debited
 			logger.AcctLog.Errorf("Should use rating function for PRICE_ENQUIRY")
+			cca.ResultCode = diam.UnableToComply
+			writeCCA(c, m, &cca)
+			return
 		case charging_datatype.REFUND_ACCOUNT:
 			logger.AcctLog.Infof("Refund Account")
-			refundQuota := int64(mscc.RequestedServiceUnit.CCTotalOctets)
-			quota += refundQuota
+			change = session.Release(account.Units{Money: requestedAmount, Octets: requestedOctets}, now)
 		case charging_datatype.DIRECT_DEBITING:
+			switch ccr.CcRequestType {
+			case charging_datatype.INITIAL_REQUEST, charging_datatype.UPDATE_REQUEST:
+				// The octets requested are granted from the data buckets, the money from the money buckets, and the
+				// units used since the last reservation are settled against the units held
+				requested := account.Units{Money: requestedAmount, Octets: requestedOctets}
+				change = session.Reserve(used, requested, now, &granted)
+			case charging_datatype.TERMINATION_REQUEST:
+				change = session.Commit(used, now)
+			case charging_datatype.EVENT_REQUEST:
+				// Immediate event charging: the whole price of the event is debited at once or not at all
+				change = account.Debit(account.Units{Money: requestedAmount}, now)
+			}
+		}
+		if change == nil {
+			writeCCA(c, m, &cca)
+			return
+		}
+
+		previous, updated, err := account.Update(accounts, subscriberIds, uint32(rg), change)
+		switch {
+		case errors.Is(err, account.ErrBarred):
+			// A barred account is not debited anymore, unused reservations are still refunded to it
+			logger.AcctLog.Warnf("UE [%s] Rating group [%d] account is barred", subscriberId, rg)
+			cca.ResultCode = charging_code.EndUserServiceDenied
+			writeCCA(c, m, &cca)
+			return
+		case errors.Is(err, account.ErrInsufficientFunds):
+			logger.AcctLog.Warnf("UE [%s] Rating group [%d] credit limit reached", subscriberId, rg)
+			resultCode = charging_code.CreditLimitReached
+		case err != nil:
+			logger.AcctLog.Errorf("UE [%s] Rating group [%d] account update error: %+v", subscriberId, rg, err)
+			cca.ResultCode = diam.UnableToComply
+			writeCCA(c, m, &cca)
+			return
+		case updated == nil:
+			cca.ResultCode = charging_code.UserUnknown
+			writeCCA(c, m, &cca)
+			return
+		}
+		// The balance a member of a shared account can draw is capped by its sub-limit
+		quota := updated.Balance(account.UnitTypeMoney, now)
+		available := updated.Available(account.UnitTypeMoney, now)
+
+		if ccr.RequestedAction == charging_datatype.DIRECT_DEBITING {
 			switch ccr.CcRequestType {
 			case charging_datatype.INITIAL_REQUEST, charging_datatype.UPDATE_REQUEST:
 				var finalUnitIndication *charging_datatype.FinalUnitIndication
-				requestQuota := int64(mscc.RequestedServiceUnit.CCTotalOctets)
-				if requestQuota > quota {
+				// The last quota is granted once the money and the data buckets cannot cover the request
+				previousAvailable := previous.Available(account.UnitTypeMoney, now)
+				if requestedAmount.Cmp(previousAvailable) > 0 && (requestedOctets == 0 || granted.Octets < requestedOctets) {
 					finalUnitIndication = &charging_datatype.FinalUnitIndication{
 						FinalUnitAction: charging_datatype.TERMINATE,
 					}
-
-					requestQuota = quota
 				}
-
 				creditControl = &charging_datatype.MultipleServicesCreditControl{
 					RatingGroup: rg,
 					GrantedServiceUnit: &charging_datatype.GrantedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(requestQuota),
+						CCMoney:       granted.Money.CCMoney(currencyCode),
+						CCTotalOctets: datatype.Unsigned64(granted.Octets),
 					},
 					FinalUnitIndication: finalUnitIndication,
 				}
-
-				quota -= requestQuota
-			case charging_datatype.TERMINATION_REQUEST:
-				usedQuota := int64(mscc.UsedServiceUnit.CCTotalOctets)
-				quota -= usedQuota
+			case charging_datatype.EVENT_REQUEST:
+				eventQuota := requestedAmount
+				if resultCode != diam.Success {
+					eventQuota = money.Money{}
+				}
+				creditControl = &charging_datatype.MultipleServicesCreditControl{
+					RatingGroup: rg,
+					GrantedServiceUnit: &charging_datatype.GrantedServiceUnit{
+						CCMoney: eventQuota.CCMoney(currencyCode),
+					},
+					ResultCode: datatype.Unsigned32(resultCode),
+				}
 			}
 
-			// Convert quota into value digits and exponential expression
-			quotaStr = strconv.FormatInt(quota, 10)
-			quotaInt, err1 := strconv.ParseInt(quotaStr, 10, 64)
-			if err1 != nil {
-				logger.AcctLog.Errorf("srtconv ParseInt error: %+v", err1)
-				return
+			cca.ResultCode = datatype.Unsigned32(resultCode)
+			cca.RemainingBalance = &charging_datatype.RemainingBalance{
+				UnitValue:    available.UnitValue(),
+				CurrencyCode: datatype.Unsigned32(currencyCode),
 			}
+			cca.MultipleServicesCreditControl = creditControl
+		}
 
-			quotaLen := len(quotaStr)
-			quotaExp := quotaLen - 1
-			quotaVal := quotaInt / int64(math.Pow10(quotaExp))
-
-			cca = charging_datatype.AccountDebitResponse{
-				SessionId:       ccr.SessionId,
-				OriginHost:      ccr.DestinationHost,
-				OriginRealm:     ccr.DestinationRealm,
-				CcRequestType:   ccr.CcRequestType,
-				CcRequestNumber: ccr.CcRequestNumber,
-				EventTimestamp:  datatype.Time(time.Now()),
-				RemainingBalance: &charging_datatype.RemainingBalance{
-					UnitValue: &charging_datatype.UnitValue{
-						ValueDigits: datatype.Integer64(quotaVal),
-						Exponent:    datatype.Integer32(quotaExp),
-					},
-				},
-				MultipleServicesCreditControl: creditControl,
-			}
+		logger.AcctLog.Infof("UE [%s], Rating group [%d], quota [%s], version [%d]", subscriberId, rg, quota, updated.Version)
+		// The money committed or debited is counted as spend of the subscriber, the money only reserved is not
+		// until the session commits it. The policy counters count whole currency units, the spend is the change
+		// of the whole part of the unspent money so that the fractions of successive debits add up.
+		spend := previous.Unspent(now).Round(0, money.RoundDown).
+			Sub(updated.Unspent(now).Round(0, money.RoundDown)).Int64()
+		if spend != 0 {
+			chf_context.GetSelf().PolicyCounters.AddUsage(subscriberId, policycounter.TypeSpend, int32(rg), spend, time.Now())
 		}
 
-		logger.AcctLog.Infof("UE [%s], Rating group [%d], quota [%d]", subscriberId, rg, quota)
+		writeCCA(c, m, &cca)
+	}
+}
 
-		chargingBsonM := make(bson.M)
-		chargingBsonM["quota"] = strconv.FormatInt(quota, 10)
-		logger.AcctLog.Warnln("quota:", quota)
-		if _, err1 := mongoapi.RestfulAPIPutOne(chargingDatasColl, filter, chargingBsonM); err1 != nil {
-			logger.AcctLog.Errorf("RestfulAPIPutOne err: %+v", err1)
+// subscriberIdsOf are the identities of the subscriber of the request, its SUPI first then e.g. its GPSI
+func subscriberIdsOf(ccr *charging_datatype.AccountDebitRequest) []string {
+	subscriptionIds := ccr.SubscriptionIds
+	if len(subscriptionIds) == 0 && ccr.SubscriptionId != nil {
+		subscriptionIds = []*charging_datatype.SubscriptionId{ccr.SubscriptionId}
+	}
+	var subscriberIds []string
+	for _, subscriptionId := range subscriptionIds {
+		data := string(subscriptionId.SubscriptionIdData)
+		switch subscriptionId.SubscriptionIdType {
+		case charging_datatype.END_USER_IMSI:
+			subscriberIds = append(subscriberIds, "imsi-"+data)
+		case charging_datatype.END_USER_NAI:
+			subscriberIds = append(subscriberIds, "nai-"+data)
+		case charging_datatype.END_USER_E164:
+			subscriberIds = append(subscriberIds, "msisdn-"+data)
 		}
+	}
+	return subscriberIds
+}
 
-		a := m.Answer(diam.Success)
+func writeCCA(c diam.Conn, m *diam.Message, cca *charging_datatype.AccountDebitResponse) {
+	a := m.Answer(uint32(cca.ResultCode))
 
-		err = a.Marshal(&cca)
-		if err != nil {
-			logger.AcctLog.Errorf("Marshal CCA Err: %+v:", err)
-		}
+	err := a.Marshal(cca)
+	if err != nil {
+		logger.AcctLog.Errorf("Marshal CCA Err: %+v:", err)
+	}
 
-		_, err = a.WriteTo(c)
-		if err != nil {
-			logger.AcctLog.Errorf("Failed to write message to %s: %s\n%s\n",
-				c.RemoteAddr(), err, a)
-			return
-		}
+	_, err = a.WriteTo(c)
+	if err != nil {
+		logger.AcctLog.Errorf("Failed to write message to %s: %s\n%s\n",
+			c.RemoteAddr(), err, a)
+		return
 	}
 }
 
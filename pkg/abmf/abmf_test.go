@@ -0,0 +1,68 @@
+package abmf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/account"
+	"github.com/free5gc/chf/internal/policycounter"
+)
+
+func TestSubscriberIdsOf(t *testing.T) {
+	imsi := &charging_datatype.SubscriptionId{
+		SubscriptionIdType: charging_datatype.END_USER_IMSI,
+		SubscriptionIdData: "208930000000001",
+	}
+	gpsi := &charging_datatype.SubscriptionId{
+		SubscriptionIdType: charging_datatype.END_USER_E164,
+		SubscriptionIdData: "886912345678",
+	}
+
+	testCases := []struct {
+		name          string
+		ccr           charging_datatype.AccountDebitRequest
+		subscriberIds []string
+	}{
+		{name: "no subscriber"},
+		{
+			name:          "SUPI only",
+			ccr:           charging_datatype.AccountDebitRequest{SubscriptionId: imsi},
+			subscriberIds: []string{"imsi-208930000000001"},
+		},
+		{
+			name: "SUPI and GPSI",
+			ccr: charging_datatype.AccountDebitRequest{
+				SubscriptionId:  imsi,
+				SubscriptionIds: []*charging_datatype.SubscriptionId{imsi, gpsi},
+			},
+			subscriberIds: []string{"imsi-208930000000001", "msisdn-886912345678"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.subscriberIds, subscriberIdsOf(&tc.ccr))
+		})
+	}
+}
+
+func TestRemoveSubscribers(t *testing.T) {
+	store := account.NewMemoryStore()
+	store.Put(account.Account{UeId: "imsi-208930000000001"})
+	policyCounters := policycounter.NewEvaluator()
+	policyCounters.Restore([]policycounter.Counter{
+		{Supi: "imsi-208930000000001", PolicyCounterId: "spend"},
+		{Supi: "imsi-208930000000002", PolicyCounterId: "spend"},
+	})
+	var removed []string
+	policyCounters.Removed = func(supi string) {
+		removed = append(removed, supi)
+	}
+
+	// The subscriber whose account was deleted is removed without waiting for a request of it
+	removeSubscribers(store, policyCounters)
+	require.Equal(t, []string{"imsi-208930000000002"}, removed)
+	require.Equal(t, []string{"imsi-208930000000001"}, policyCounters.Subscribers())
+}
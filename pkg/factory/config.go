@@ -8,12 +8,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 
 	"github.com/asaskevich/govalidator"
 
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/money"
 )
 
 const (
@@ -29,6 +31,12 @@ const (
 	ConvergedChargingResUriPrefix    = "/nchf-convergedcharging/v3"
 	OfflineOnlyChargingResUriPrefix  = "/nchf-offlineonlycharging/v1"
 	SpendingLimitControlResUriPrefix = "/nchf-spendinglimitcontrol/v1"
+	SelfCareResUriPrefix             = "/chf-selfcare/v1"
+	CustomerCareResUriPrefix         = "/chf-customercare/v1"
+	ChfDefaultOfflineVolumeThreshold = 30000000
+	ChfDefaultTariffReloadInterval   = 10
+	ChfDefaultReservationTtl         = 3600
+	ChfDefaultReservationSweepPeriod = 60
 )
 
 type Config struct {
@@ -55,20 +63,259 @@ type Info struct {
 }
 
 type Configuration struct {
-	ChfName             string    `yaml:"chfName,omitempty" valid:"required, type(string)"`
-	Sbi                 *Sbi      `yaml:"sbi,omitempty" valid:"required"`
-	ServiceNameList     []string  `yaml:"serviceNameList,omitempty" valid:"required"`
-	NrfUri              string    `yaml:"nrfUri,omitempty" valid:"required, url"`
-	NrfCertPem          string    `yaml:"nrfCertPem,omitempty" valid:"optional"`
-	Mongodb             *Mongodb  `yaml:"mongodb" valid:"required"`
-	VolumeLimit         int32     `yaml:"volumeLimit,omitempty" valid:"optional"`
-	VolumeLimitPDU      int32     `yaml:"volumeLimitPDU,omitempty" valid:"optional"`
-	ReserveQuotaRatio   int32     `yaml:"reserveQuotaRatio,omitempty" valid:"optional"`
-	VolumeThresholdRate float32   `yaml:"volumeThresholdRate,omitempty" valid:"optional"`
-	QuotaValidityTime   int32     `yaml:"quotaValidityTime,omitempty" valid:"optional"`
-	RfDiameter          *Diameter `yaml:"rfDiameter,omitempty" valid:"required"`
-	AbmfDiameter        *Diameter `yaml:"abmfDiameter,omitempty" valid:"required"`
-	Cgf                 *Cgf      `yaml:"cgf,omitempty" valid:"required"`
+	ChfName              string                `yaml:"chfName,omitempty" valid:"required, type(string)"`
+	Sbi                  *Sbi                  `yaml:"sbi,omitempty" valid:"required"`
+	ServiceNameList      []string              `yaml:"serviceNameList,omitempty" valid:"required"`
+	NrfUri               string                `yaml:"nrfUri,omitempty" valid:"required, url"`
+	NrfCertPem           string                `yaml:"nrfCertPem,omitempty" valid:"optional"`
+	Mongodb              *Mongodb              `yaml:"mongodb" valid:"required"`
+	VolumeLimit          int32                 `yaml:"volumeLimit,omitempty" valid:"optional"`
+	VolumeLimitPDU       int32                 `yaml:"volumeLimitPDU,omitempty" valid:"optional"`
+	ReserveQuotaRatio    int32                 `yaml:"reserveQuotaRatio,omitempty" valid:"optional"`
+	VolumeThresholdRate  float32               `yaml:"volumeThresholdRate,omitempty" valid:"optional"`
+	TimeThresholdRate    float32               `yaml:"timeThresholdRate,omitempty" valid:"optional"`
+	QuotaValidityTime    int32                 `yaml:"quotaValidityTime,omitempty" valid:"optional"`
+	RfDiameter           *Diameter             `yaml:"rfDiameter,omitempty" valid:"required"`
+	AbmfDiameter         *Diameter             `yaml:"abmfDiameter,omitempty" valid:"required"`
+	Cgf                  *Cgf                  `yaml:"cgf,omitempty" valid:"required"`
+	OfflineCharging      *OfflineCharging      `yaml:"offlineCharging,omitempty" valid:"optional"`
+	ReservationPolicy    *ReservationPolicy    `yaml:"reservationPolicy,omitempty" valid:"optional"`
+	LowBalance           *LowBalance           `yaml:"lowBalance,omitempty" valid:"optional"`
+	SpendingLimitControl *SpendingLimitControl `yaml:"spendingLimitControl,omitempty" valid:"optional"`
+	// Seconds without charging data request after which a charging session is aborted, 0 disables it
+	SessionIdleTimeout int32 `yaml:"sessionIdleTimeout,omitempty" valid:"optional"`
+	// Where the rating function reads the tariffs from, the charging data in MongoDB if not configured
+	Tariffs *TariffSource `yaml:"tariffs,omitempty" valid:"optional"`
+	// Operator API of the customer-care tools, e.g. to read the live balances of the subscribers
+	CustomerCare *CustomerCare `yaml:"customerCare,omitempty" valid:"optional"`
+	// Expiry of the reservations the ABMF holds for the charging sessions
+	ReservationLedger *ReservationLedger `yaml:"reservationLedger,omitempty" valid:"optional"`
+}
+
+// A reservation of a charging session without request for Ttl seconds is returned to the account by the
+// sweeper, which looks for them every SweepPeriod seconds. They default to ChfDefaultReservationTtl and
+// ChfDefaultReservationSweepPeriod if not configured.
+type ReservationLedger struct {
+	Ttl         int32 `yaml:"ttl,omitempty" valid:"optional"`
+	SweepPeriod int32 `yaml:"sweepPeriod,omitempty" valid:"optional"`
+}
+
+// The customer-care and self-care tools present one of the tokens as "Authorization: Bearer <token>",
+// every request is rejected if no token is configured
+type CustomerCare struct {
+	Tokens []string `yaml:"tokens,omitempty" valid:"optional"`
+}
+
+// Source of the tariffs of the rating function: "mongodb" reads the charging data of the subscribers,
+// which either holds the tariff or assigns a plan or a subscriber group, and "file" reads a YAML or JSON
+// tariff catalog reloaded whenever it changes
+type TariffSource struct {
+	Type        string `yaml:"type,omitempty" valid:"optional,in(mongodb|file)"`
+	CatalogPath string `yaml:"catalogPath,omitempty" valid:"optional"`
+	// Seconds between the checks of the catalog file for changes, ChfDefaultTariffReloadInterval if not configured
+	ReloadInterval int32 `yaml:"reloadInterval,omitempty" valid:"optional"`
+}
+
+// Sizing of the quota reserved from the account for a rating group:
+// "requested" reserves the price of the requested units, "fixed" reserves FixedQuota,
+// "balanceRatio" reserves ReserveQuotaRatio percent of the remaining balance and
+// "adaptive" reserves the quota consumed in AdaptiveInterval seconds at the recent consumption rate.
+// The quotas are decimal amounts in the currency of the tariff, e.g. "0.50".
+type ReservationPolicy struct {
+	Type             string                    `yaml:"type,omitempty" valid:"reservationPolicy,optional"`
+	FixedQuota       string                    `yaml:"fixedQuota,omitempty" valid:"optional"`
+	AdaptiveInterval int32                     `yaml:"adaptiveInterval,omitempty" valid:"optional"`
+	RatingGroups     []*RatingGroupReservation `yaml:"ratingGroups,omitempty" valid:"optional"`
+}
+
+// Bounds of the quota reserved at once for a rating group, an empty or 0 bound means unbounded
+type RatingGroupReservation struct {
+	RatingGroup int32  `yaml:"ratingGroup" valid:"required"`
+	MinQuota    string `yaml:"minQuota,omitempty" valid:"optional"`
+	MaxQuota    string `yaml:"maxQuota,omitempty" valid:"optional"`
+}
+
+// Quota reserved by the fixed policy, 0 if not configured. An invalid quota is rejected by Validate.
+func (p *ReservationPolicy) Quota() money.Money {
+	quota, _ := parseQuota(p.FixedQuota)
+	return quota
+}
+
+// Bounds of the quota reserved for the rating group, 0 if not configured. An invalid bound is rejected
+// by Validate.
+func (r *RatingGroupReservation) Bounds() (minQuota, maxQuota money.Money) {
+	minQuota, _ = parseQuota(r.MinQuota)
+	maxQuota, _ = parseQuota(r.MaxQuota)
+	return minQuota, maxQuota
+}
+
+func (p *ReservationPolicy) validate() error {
+	if _, err := parseQuota(p.FixedQuota); err != nil {
+		return fmt.Errorf("Invalid reservationPolicy fixedQuota: %w", err)
+	}
+	for index, bounds := range p.RatingGroups {
+		minQuota, err := parseQuota(bounds.MinQuota)
+		if err != nil {
+			return fmt.Errorf("Invalid reservationPolicy ratingGroups[%d] minQuota: %w", index, err)
+		}
+		maxQuota, err := parseQuota(bounds.MaxQuota)
+		if err != nil {
+			return fmt.Errorf("Invalid reservationPolicy ratingGroups[%d] maxQuota: %w", index, err)
+		}
+		if !maxQuota.IsZero() && minQuota.Cmp(maxQuota) > 0 {
+			return fmt.Errorf("Invalid reservationPolicy ratingGroups[%d]: minQuota %s exceeds maxQuota %s",
+				index, minQuota, maxQuota)
+		}
+	}
+	return nil
+}
+
+// A quota is a non-negative decimal amount, 0 if empty
+func parseQuota(quota string) (money.Money, error) {
+	if quota == "" {
+		return money.Money{}, nil
+	}
+	amount, err := money.Parse(quota)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if amount.Sign() < 0 {
+		return money.Money{}, fmt.Errorf("negative amount %q", quota)
+	}
+	return amount, nil
+}
+
+// Reporting thresholds of rating groups without quota management
+type OfflineCharging struct {
+	VolumeThreshold int32              `yaml:"volumeThreshold,omitempty" valid:"optional"`
+	TimeThreshold   int32              `yaml:"timeThreshold,omitempty" valid:"optional"`
+	Profiles        []*ChargingProfile `yaml:"profiles,omitempty" valid:"optional"`
+}
+
+// Per-subscriber charging profile overriding the default offline thresholds
+type ChargingProfile struct {
+	Supi                   string `yaml:"supi" valid:"required"`
+	OfflineVolumeThreshold int32  `yaml:"offlineVolumeThreshold,omitempty" valid:"optional"`
+	OfflineTimeThreshold   int32  `yaml:"offlineTimeThreshold,omitempty" valid:"optional"`
+}
+
+// Offline reporting thresholds of the subscriber, the volume threshold defaults to ChfDefaultOfflineVolumeThreshold
+func (c *Configuration) OfflineThresholds(supi string) (volumeThreshold, timeThreshold int32) {
+	volumeThreshold = ChfDefaultOfflineVolumeThreshold
+	offline := c.OfflineCharging
+	if offline == nil {
+		return volumeThreshold, timeThreshold
+	}
+
+	if offline.VolumeThreshold != 0 {
+		volumeThreshold = offline.VolumeThreshold
+	}
+	timeThreshold = offline.TimeThreshold
+	for _, profile := range offline.Profiles {
+		if profile.Supi != supi {
+			continue
+		}
+		if profile.OfflineVolumeThreshold != 0 {
+			volumeThreshold = profile.OfflineVolumeThreshold
+		}
+		if profile.OfflineTimeThreshold != 0 {
+			timeThreshold = profile.OfflineTimeThreshold
+		}
+	}
+	return volumeThreshold, timeThreshold
+}
+
+// Remaining balances under which the subscriber is warned that the account runs low
+type LowBalance struct {
+	Thresholds []int64 `yaml:"thresholds,omitempty" valid:"optional"`
+	// Operator portal notified of each crossed threshold
+	NotifyUri string `yaml:"notifyUri,omitempty" valid:"optional"`
+	// Announcement played to the subscriber, reported in the charging data response
+	AnnouncementIdentifier int32                `yaml:"announcementIdentifier,omitempty" valid:"optional"`
+	Profiles               []*LowBalanceProfile `yaml:"profiles,omitempty" valid:"optional"`
+}
+
+// Per-subscriber low balance thresholds overriding the default ones
+type LowBalanceProfile struct {
+	Supi       string  `yaml:"supi" valid:"required"`
+	Thresholds []int64 `yaml:"thresholds,omitempty" valid:"optional"`
+}
+
+// Low balance thresholds of the subscriber, from the highest to the lowest
+func (c *Configuration) LowBalanceThresholds(supi string) []int64 {
+	lowBalance := c.LowBalance
+	if lowBalance == nil {
+		return nil
+	}
+
+	thresholds := lowBalance.Thresholds
+	for _, profile := range lowBalance.Profiles {
+		if profile.Supi == supi && len(profile.Thresholds) != 0 {
+			thresholds = profile.Thresholds
+		}
+	}
+
+	sorted := make([]int64, len(thresholds))
+	copy(sorted, thresholds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	return sorted
+}
+
+// Policy counters maintained by the CHF for the spending limit control of the PCF, TS 29.594
+type SpendingLimitControl struct {
+	PolicyCounters []*PolicyCounter        `yaml:"policyCounters,omitempty" valid:"optional"`
+	Profiles       []*PolicyCounterProfile `yaml:"profiles,omitempty" valid:"optional"`
+}
+
+// Policy counter accumulated over a billing cycle: "volume" counts the octets reported by the NF consumers,
+// "spend" counts the monetary units debited from the account of the subscriber
+type PolicyCounter struct {
+	PolicyCounterId string `yaml:"policyCounterId" valid:"required"`
+	Type            string `yaml:"type,omitempty" valid:"optional,in(volume|spend)"`
+	// Rating groups counted, every rating group if none
+	RatingGroups []int32 `yaml:"ratingGroups,omitempty" valid:"optional"`
+	// Day of the month the billing cycle starts on, the 1st if not configured
+	BillingCycleDay int `yaml:"billingCycleDay,omitempty" valid:"optional"`
+	// Status of the policy counter below every threshold, "valid" if not configured;
+	// it is also the pending status of the next billing cycle
+	InitialStatus string                    `yaml:"initialStatus,omitempty" valid:"optional"`
+	Thresholds    []*PolicyCounterThreshold `yaml:"thresholds,omitempty" valid:"optional"`
+}
+
+// Status of a policy counter once its value reaches the threshold
+type PolicyCounterThreshold struct {
+	Value  int64  `yaml:"value" valid:"optional"`
+	Status string `yaml:"status" valid:"required"`
+}
+
+// Per-subscriber policy counters, subscribers without profile are given every policy counter
+type PolicyCounterProfile struct {
+	Supi             string   `yaml:"supi" valid:"required"`
+	PolicyCounterIds []string `yaml:"policyCounterIds,omitempty" valid:"optional"`
+}
+
+// Policy counters maintained for the subscriber
+func (c *Configuration) PolicyCounters(supi string) []*PolicyCounter {
+	spendingLimit := c.SpendingLimitControl
+	if spendingLimit == nil {
+		return nil
+	}
+
+	for _, profile := range spendingLimit.Profiles {
+		if profile.Supi != supi {
+			continue
+		}
+		var policyCounters []*PolicyCounter
+		for _, policyCounter := range spendingLimit.PolicyCounters {
+			for _, policyCounterId := range profile.PolicyCounterIds {
+				if policyCounter.PolicyCounterId == policyCounterId {
+					policyCounters = append(policyCounters, policyCounter)
+				}
+			}
+		}
+		return policyCounters
+	}
+	return spendingLimit.PolicyCounters
 }
 
 type Logger struct {
@@ -78,6 +325,10 @@ type Logger struct {
 }
 
 func (c *Configuration) validate() (bool, error) {
+	govalidator.TagMap["reservationPolicy"] = govalidator.Validator(func(str string) bool {
+		return str == "requested" || str == "fixed" || str == "balanceRatio" || str == "adaptive"
+	})
+
 	if sbi := c.Sbi; sbi != nil {
 		if result, err := sbi.validate(); err != nil {
 			return result, err
@@ -96,6 +347,16 @@ func (c *Configuration) validate() (bool, error) {
 		}
 	}
 
+	if tariffs := c.Tariffs; tariffs != nil && tariffs.Type == "file" && tariffs.CatalogPath == "" {
+		return false, errors.New("Invalid tariffs: catalogPath is required by the file tariff source")
+	}
+
+	if reservationPolicy := c.ReservationPolicy; reservationPolicy != nil {
+		if err := reservationPolicy.validate(); err != nil {
+			return false, err
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(c)
 	return result, appendInvalid(err)
 }
@@ -0,0 +1,50 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservationPolicyValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy ReservationPolicy
+		valid  bool
+	}{
+		{
+			name:   "decimal quotas",
+			policy: ReservationPolicy{FixedQuota: "0.50", RatingGroups: []*RatingGroupReservation{{MaxQuota: "2.5"}}},
+			valid:  true,
+		},
+		{
+			name:   "invalid fixed quota",
+			policy: ReservationPolicy{FixedQuota: "ten"},
+		},
+		{
+			name:   "negative bound",
+			policy: ReservationPolicy{RatingGroups: []*RatingGroupReservation{{MinQuota: "-1"}}},
+		},
+		{
+			name:   "minimum above maximum",
+			policy: ReservationPolicy{RatingGroups: []*RatingGroupReservation{{MinQuota: "10", MaxQuota: "1.5"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.validate()
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+
+	policy := ReservationPolicy{FixedQuota: "0.50", RatingGroups: []*RatingGroupReservation{{MaxQuota: "2.5"}}}
+	require.Equal(t, "0.5", policy.Quota().String())
+	minQuota, maxQuota := policy.RatingGroups[0].Bounds()
+	require.True(t, minQuota.IsZero())
+	require.Equal(t, "2.5", maxQuota.String())
+}
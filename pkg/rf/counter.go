@@ -0,0 +1,224 @@
+package rf
+
+import (
+	"math"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+	"github.com/free5gc/chf/internal/policycounter"
+)
+
+// tariffCounter prices the units of its unit type by the tiers of the counter accumulating them,
+// e.g. an included bundle is a free first tier and a stepped discount is a cheaper next tier
+type tariffCounter struct {
+	CounterId string `bson:"counterId"`
+	// "volume", "time" or "serviceSpecificUnits"
+	UnitType string `bson:"unitType"`
+	// The counter starts over at this day of each month (1 to 28), it never expires if not set
+	BillingCycleDay int          `bson:"billingCycleDay"`
+	Tiers           []tariffTier `bson:"tiers"`
+}
+
+// tariffTier applies its unit cost until the counter reaches UpTo, the last tier applies without bound
+type tariffTier struct {
+	UpTo     int64  `bson:"upTo"`
+	UnitCost string `bson:"unitCost"`
+}
+
+func counterUnitTypeOf(unitType string) (charging_datatype.CCUnitType, bool) {
+	switch unitType {
+	case "volume":
+		return charging_datatype.TOTALOCTETS, true
+	case "time":
+		return charging_datatype.TIME, true
+	case "serviceSpecificUnits":
+		return charging_datatype.SERVICESPECIFICUNITS, true
+	}
+	return 0, false
+}
+
+// counterOf returns the counter pricing the unit type, if any
+func (p *tariffPlan) counterOf(unitType charging_datatype.CCUnitType) *tariffCounter {
+	for i := range p.Counters {
+		if counted, ok := counterUnitTypeOf(p.Counters[i].UnitType); ok && counted == unitType {
+			return &p.Counters[i]
+		}
+	}
+	return nil
+}
+
+// monetaryTariffAt is the tariff applying at t, the rate element of a counted unit type carries the tier
+// of the value of its counter sent by the CHF, the first tier if it is not sent
+func (p *tariffPlan) monetaryTariffAt(
+	t time.Time, counters []*charging_datatype.Counter,
+) *charging_datatype.MonetaryTariff {
+	monetaryTariff := buildTaffif(p.costsAt(t), p.CurrencyCode)
+	for i := range p.Counters {
+		counter := &p.Counters[i]
+		unitType, ok := counterUnitTypeOf(counter.UnitType)
+		if !ok || len(counter.Tiers) == 0 {
+			continue
+		}
+		tier := counter.Tiers[counter.tierOf(counter.valueOf(counters, t))]
+		rateElement := buildRateElement(unitType, costOf(tier.UnitCost))
+		if existing := findRateElement(monetaryTariff.RateElement, unitType); existing != nil {
+			*existing = *rateElement
+			continue
+		}
+		monetaryTariff.RateElement = append(monetaryTariff.RateElement, rateElement)
+	}
+	return monetaryTariff
+}
+
+// counterRating sets the counters the rating group is priced with and their tariffs into the rating answer
+func (p *tariffPlan) counterRating(serviceRating *charging_datatype.ServiceRating) {
+	if len(p.Counters) == 0 {
+		return
+	}
+	serviceRating.RequestedCounters = &charging_datatype.RequestedCounters{}
+	for i := range p.Counters {
+		counter := &p.Counters[i]
+		serviceRating.RequestedCounters.CounterId = append(serviceRating.RequestedCounters.CounterId,
+			datatype.UTF8String(counter.CounterId))
+		serviceRating.CounterTariff = append(serviceRating.CounterTariff, counter.tariff())
+	}
+}
+
+func (c *tariffCounter) tariff() *charging_datatype.CounterTariff {
+	unitType, _ := counterUnitTypeOf(c.UnitType)
+	counterTariff := &charging_datatype.CounterTariff{
+		CounterId: datatype.UTF8String(c.CounterId),
+	}
+	for i, tier := range c.Tiers {
+		counterTier := &charging_datatype.CounterTier{
+			RateElement: buildRateElement(unitType, costOf(tier.UnitCost)),
+		}
+		if i < len(c.Tiers)-1 {
+			counterTier.CounterThreshold = datatype.Unsigned64(tier.UpTo)
+		}
+		counterTariff.CounterTier = append(counterTariff.CounterTier, counterTier)
+	}
+	return counterTariff
+}
+
+func (c *tariffCounter) unitCosts() []money.Money {
+	unitCosts := make([]money.Money, len(c.Tiers))
+	for i, tier := range c.Tiers {
+		unitCosts[i] = costOf(tier.UnitCost)
+	}
+	return unitCosts
+}
+
+// valueOf is the value of the counter sent by the CHF, an expired or unknown counter counts from 0
+func (c *tariffCounter) valueOf(counters []*charging_datatype.Counter, ratingTime time.Time) int64 {
+	for _, counter := range counters {
+		if string(counter.CounterId) != c.CounterId {
+			continue
+		}
+		if counter.CounterExpiryDate != nil && !ratingTime.Before(time.Time(*counter.CounterExpiryDate)) {
+			return 0
+		}
+		return int64(counter.CounterValue)
+	}
+	return 0
+}
+
+// expiryDate is the end of the billing cycle of the counter containing the rating time
+func (c *tariffCounter) expiryDate(ratingTime time.Time) *datatype.Time {
+	if c.BillingCycleDay == 0 {
+		return nil
+	}
+	expiryDate := datatype.Time(policycounter.CycleStart(c.BillingCycleDay, ratingTime).AddDate(0, 1, 0))
+	return &expiryDate
+}
+
+// tierOf is the index of the tier applying to the next unit counted from the value of the counter
+func (c *tariffCounter) tierOf(value int64) int {
+	for i, tier := range c.Tiers {
+		if i == len(c.Tiers)-1 || value < tier.UpTo {
+			return i
+		}
+	}
+	return 0
+}
+
+// validUnits is the number of units counted from the value of the counter before the next tier applies,
+// 0 within the last tier which applies without bound
+func (c *tariffCounter) validUnits(value int64) uint32 {
+	i := c.tierOf(value)
+	if i == len(c.Tiers)-1 {
+		return 0
+	}
+	return uint32(min(c.Tiers[i].UpTo-value, math.MaxUint32))
+}
+
+// priceOf prices the units counted from the value of the counter across its tiers
+func (c *tariffCounter) priceOf(value, units int64) money.Money {
+	var price money.Money
+	unitCosts := c.unitCosts()
+	for i, tier := range c.Tiers {
+		if units <= 0 {
+			break
+		}
+		last := i == len(c.Tiers)-1
+		if !last && value >= tier.UpTo {
+			continue
+		}
+		tierUnits := units
+		if !last {
+			tierUnits = min(units, tier.UpTo-value)
+		}
+		price = price.Add(unitCosts[i].Mul(uint64(tierUnits)))
+		value += tierUnits
+		units -= tierUnits
+	}
+	return price
+}
+
+// allowedUnits is the number of units counted from the value of the counter the monetary quota pays for
+func (c *tariffCounter) allowedUnits(value int64, monetaryQuota money.Money) uint64 {
+	var allowed uint64
+	unitCosts := c.unitCosts()
+	for i, tier := range c.Tiers {
+		last := i == len(c.Tiers)-1
+		if !last && value >= tier.UpTo {
+			continue
+		}
+		if unitCosts[i].IsZero() {
+			if last {
+				// Free of charge, any amount of units is allowed
+				return math.MaxUint32
+			}
+			allowed += uint64(tier.UpTo - value)
+			value = tier.UpTo
+			continue
+		}
+
+		tierUnits := min(monetaryQuota.Units(unitCosts[i]), math.MaxUint32)
+		if !last {
+			tierUnits = min(tierUnits, uint64(tier.UpTo-value))
+		}
+		allowed += tierUnits
+		monetaryQuota = monetaryQuota.Sub(unitCosts[i].Mul(tierUnits))
+		value += int64(tierUnits)
+		if monetaryQuota.Cmp(unitCosts[i]) < 0 && (last || value < tier.UpTo) {
+			break
+		}
+	}
+	return min(allowed, math.MaxUint32)
+}
+
+// adviceOfCharge prices the requested units at the rating time without impacting the counter, the units of a
+// counted unit type are priced from the value of its counter
+func (p *tariffPlan) adviceOfCharge(
+	unitType charging_datatype.CCUnitType, units uint32, counters []*charging_datatype.Counter, ratingTime time.Time,
+) money.Money {
+	if counter := p.counterOf(unitType); counter != nil {
+		return counter.priceOf(counter.valueOf(counters, ratingTime), int64(units))
+	}
+	rateElement := findRateElement(p.monetaryTariffAt(ratingTime, counters).RateElement, unitType)
+	return unitCostOf(rateElement).Mul(uint64(units))
+}
@@ -0,0 +1,173 @@
+package rf
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestTariffCounter(t *testing.T) {
+	// 1000 octets included, then 2 per octet up to 3000, then 1 per octet
+	counter := &tariffCounter{
+		CounterId: "monthlyVolume",
+		UnitType:  "volume",
+		Tiers: []tariffTier{
+			{UpTo: 1000, UnitCost: "0"},
+			{UpTo: 3000, UnitCost: "2"},
+			{UnitCost: "1"},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		value         int64
+		units         int64
+		price         int64
+		monetaryQuota int64
+		allowedUnits  uint64
+	}{
+		{
+			name:          "within the included bundle",
+			value:         0,
+			units:         500,
+			price:         0,
+			monetaryQuota: 100,
+			allowedUnits:  1050,
+		},
+		{
+			name:          "across the end of the included bundle",
+			value:         800,
+			units:         500,
+			price:         600,
+			monetaryQuota: 600,
+			allowedUnits:  500,
+		},
+		{
+			name:          "across the stepped discount",
+			value:         2500,
+			units:         1000,
+			price:         1500,
+			monetaryQuota: 1500,
+			allowedUnits:  1000,
+		},
+		{
+			name:          "beyond every threshold",
+			value:         5000,
+			units:         100,
+			price:         100,
+			monetaryQuota: 100,
+			allowedUnits:  100,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, money.FromInt(tc.price), counter.priceOf(tc.value, tc.units))
+			require.Equal(t, tc.allowedUnits, counter.allowedUnits(tc.value, money.FromInt(tc.monetaryQuota)))
+		})
+	}
+}
+
+func TestTariffCounterFreeOfCharge(t *testing.T) {
+	counter := &tariffCounter{
+		CounterId: "unlimited",
+		UnitType:  "time",
+		Tiers:     []tariffTier{{UpTo: 60, UnitCost: "1"}, {UnitCost: "0"}},
+	}
+
+	require.Equal(t, money.Money{}, counter.priceOf(100, 30))
+	require.Equal(t, uint64(math.MaxUint32), counter.allowedUnits(100, money.Money{}))
+	require.Equal(t, uint64(math.MaxUint32), counter.allowedUnits(0, money.FromInt(60)))
+	require.Equal(t, uint64(10), counter.allowedUnits(0, money.FromInt(10)))
+}
+
+func TestTariffCounterFractionalCost(t *testing.T) {
+	counter := &tariffCounter{
+		CounterId: "monthlyVolume",
+		UnitType:  "volume",
+		Tiers:     []tariffTier{{UpTo: 1000, UnitCost: "0.005"}, {UnitCost: "0.0025"}},
+	}
+
+	// 800 x 0.005 + 400 x 0.0025
+	require.Equal(t, money.New(5, 0), counter.priceOf(200, 1200))
+	require.Equal(t, uint64(1200), counter.allowedUnits(200, money.New(5, 0)))
+	require.Equal(t, uint64(1199), counter.allowedUnits(200, money.New(49999, -4)))
+}
+
+func TestAdviceOfCharge(t *testing.T) {
+	plan, err := tariffPlanOf(map[string]interface{}{
+		"unitCost": "0.005",
+		"timeZone": "UTC",
+		"counters": []interface{}{
+			map[string]interface{}{
+				"counterId": "monthlyTime",
+				"unitType":  "time",
+				"tiers": []interface{}{
+					map[string]interface{}{"upTo": 60, "unitCost": "0"},
+					map[string]interface{}{"unitCost": "0.5"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	ratingTime := time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC)
+	counters := []*charging_datatype.Counter{{CounterId: "monthlyTime", CounterValue: 40}}
+
+	require.Equal(t, money.New(5, 0), plan.adviceOfCharge(charging_datatype.TOTALOCTETS, 1000, counters, ratingTime))
+	// 20 seconds left in the included bundle
+	require.Equal(t, money.New(20, 0), plan.adviceOfCharge(charging_datatype.TIME, 60, counters, ratingTime))
+	require.Equal(t, money.New(0, 0), plan.adviceOfCharge(charging_datatype.TIME, 60, nil, ratingTime))
+
+	// An expired counter counts from 0
+	expiryDate := datatype.Time(ratingTime)
+	counters[0].CounterExpiryDate = &expiryDate
+	require.Equal(t, money.New(15, 0), plan.adviceOfCharge(charging_datatype.TIME, 90, counters, ratingTime))
+	require.Equal(t, money.Money{}, plan.adviceOfCharge(charging_datatype.SERVICESPECIFICUNITS, 3, nil, ratingTime))
+}
+
+func TestTariffCounterValidUnits(t *testing.T) {
+	plan, err := tariffPlanOf(map[string]interface{}{
+		"timeZone": "UTC",
+		"counters": []interface{}{
+			map[string]interface{}{
+				"counterId": "monthlyVolume",
+				"unitType":  "volume",
+				"tiers": []interface{}{
+					map[string]interface{}{"upTo": 1000, "unitCost": "0"},
+					map[string]interface{}{"upTo": 3000, "unitCost": "2"},
+					map[string]interface{}{"unitCost": "1"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	counter := plan.counterOf(charging_datatype.TOTALOCTETS)
+	ratingTime := time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		value      uint64
+		unitCost   money.Money
+		validUnits uint32
+	}{
+		{name: "included bundle", value: 200, unitCost: money.Money{}, validUnits: 800},
+		{name: "at the threshold", value: 1000, unitCost: money.FromInt(2), validUnits: 2000},
+		{name: "last tier", value: 5000, unitCost: money.FromInt(1), validUnits: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			counters := []*charging_datatype.Counter{{CounterId: "monthlyVolume", CounterValue: datatype.Unsigned64(tc.value)}}
+			rateElement := findRateElement(plan.monetaryTariffAt(ratingTime, counters).RateElement,
+				charging_datatype.TOTALOCTETS)
+			require.Equal(t, tc.unitCost, unitCostOf(rateElement))
+			require.Equal(t, tc.validUnits, counter.validUnits(counter.valueOf(counters, ratingTime)))
+		})
+	}
+}
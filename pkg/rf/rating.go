@@ -22,7 +22,6 @@ import (
 	"math"
 	_ "net/http/pprof"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -30,16 +29,19 @@ import (
 	"github.com/fiorix/go-diameter/diam/datatype"
 	"github.com/fiorix/go-diameter/diam/dict"
 	"github.com/fiorix/go-diameter/diam/sm"
-	"go.mongodb.org/mongo-driver/bson"
 
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	charging_dict "github.com/free5gc/chf/ccs_diameter/dict"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/money"
 	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/util/mongoapi"
 )
 
-const chargingDatasColl = "policyData.ues.chargingData"
+// A rating session reuses the tariff it read for this validity, as the CHF reuses the tariffs it is answered.
+// Tariffs updated in the tariff repository apply to the CHF at the latest after twice this validity.
+const tariffValidity = 10 * time.Minute
 
 func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	// Load our custom dictionary on top of the default one, which
@@ -51,8 +53,14 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 		logger.InitLog.Errorf("InitpcfContext err: %+v", err)
 		return
 	}
+	repository, err := NewTariffRepository(ctx, factory.ChfConfig.Configuration.Tariffs)
+	if err != nil {
+		logger.InitLog.Errorf("Open tariff repository err: %+v", err)
+		return
+	}
+	tariffRepository = repository
 
-	err := dict.Default.Load(bytes.NewReader([]byte(charging_dict.RateDictionary)))
+	err = dict.Default.Load(bytes.NewReader([]byte(charging_dict.RateDictionary)))
 	if err != nil {
 		logger.RatingLog.Error(err)
 	}
@@ -106,41 +114,62 @@ func printErrors(ec <-chan *diam.ErrorReport) {
 // 	return diam.ListenAndServe(addr, handler, nil)
 // }
 
-func buildTaffif(unitCostStr string) *charging_datatype.MonetaryTariff {
-	// unitCost
-	unitCost := &charging_datatype.UnitCost{}
-
-	dotPos := strings.Index(unitCostStr, ".")
-	if dotPos == -1 {
-		unitCost.Exponent = datatype.Integer32(0)
-		if digit, err := strconv.Atoi(unitCostStr); err == nil {
-			unitCost.ValueDigits = datatype.Integer64(digit)
-		}
-	} else {
-		if digit, err := strconv.Atoi(strings.ReplaceAll(unitCostStr, ".", "")); err == nil {
-			unitCost.ValueDigits = datatype.Integer64(digit)
-		}
-		unitCost.Exponent = datatype.Integer32(len(unitCostStr) - dotPos - 1)
+func buildRateElement(unitType charging_datatype.CCUnitType, unitCost money.Money) *charging_datatype.RateElement {
+	return &charging_datatype.RateElement{
+		CCUnitType: unitType,
+		UnitCost:   unitCost.UnitCost(),
 	}
+}
 
-	return &charging_datatype.MonetaryTariff{
-		CurrencyCode: datatype.Unsigned32(901),
+// The tariff of a rating group carries one rate element for each unit type the rating group is charged by:
+// "unitCost" is the price per octet, "timeUnitCost" is the price per second and
+// "serviceSpecificUnitCost" is the price per event (e.g. per SMS or per API call).
+func buildTaffif(costs tariffCosts, currencyCode uint32) *charging_datatype.MonetaryTariff {
+	monetaryTariff := &charging_datatype.MonetaryTariff{
+		CurrencyCode: datatype.Unsigned32(currencyCode),
 		ScaleFactor: &charging_datatype.ScaleFactor{
 			ValueDigits: datatype.Integer64(0),
 			Exponent:    datatype.Integer32(0),
 		},
-		RateElement: &charging_datatype.RateElement{
-			CCUnitType: charging_datatype.MONEY,
-			UnitCost:   unitCost,
-		},
 	}
+
+	if costs.UnitCost != "" {
+		monetaryTariff.RateElement = append(monetaryTariff.RateElement,
+			buildRateElement(charging_datatype.TOTALOCTETS, costOf(costs.UnitCost)))
+	}
+	if costs.TimeUnitCost != "" {
+		monetaryTariff.RateElement = append(monetaryTariff.RateElement,
+			buildRateElement(charging_datatype.TIME, costOf(costs.TimeUnitCost)))
+	}
+	if costs.ServiceSpecificUnitCost != "" {
+		monetaryTariff.RateElement = append(monetaryTariff.RateElement,
+			buildRateElement(charging_datatype.SERVICESPECIFICUNITS, costOf(costs.ServiceSpecificUnitCost)))
+	}
+
+	return monetaryTariff
+}
+
+func findRateElement(
+	rateElements []*charging_datatype.RateElement, unitType charging_datatype.CCUnitType,
+) *charging_datatype.RateElement {
+	for _, rateElement := range rateElements {
+		if rateElement.CCUnitType == unitType {
+			return rateElement
+		}
+	}
+	return nil
+}
+
+func unitCostOf(rateElement *charging_datatype.RateElement) money.Money {
+	if rateElement == nil {
+		return money.Money{}
+	}
+	return money.FromUnitCost(rateElement.UnitCost)
 }
 
 func handleSUR() diam.HandlerFunc {
 	return func(c diam.Conn, m *diam.Message) {
 		var sur charging_datatype.ServiceUsageRequest
-		var monetaryCost datatype.Unsigned32
-		var subscriberId string
 
 		if err := m.Unmarshal(&sur); err != nil {
 			logger.RatingLog.Errorf("Failed to parse message from %s: %s\n%s",
@@ -148,65 +177,189 @@ func handleSUR() diam.HandlerFunc {
 			return
 		}
 
-		sr := sur.ServiceRating
-		rg := uint32(sr.ServiceIdentifier)
+		writeSUA(c, m, rateServiceUsage(&sur))
+	}
+}
 
-		switch sur.SubscriptionId.SubscriptionIdType {
-		case charging_datatype.END_USER_IMSI:
-			subscriberId = "imsi-" + string(sur.SubscriptionId.SubscriptionIdData)
+// rateServiceUsage answers the rating request of a rating group of a subscriber
+func rateServiceUsage(sur *charging_datatype.ServiceUsageRequest) *charging_datatype.ServiceUsageResponse {
+	sr := sur.ServiceRating
+	rg := uint32(sr.ServiceIdentifier)
+
+	subscriberId := subscriberIdOf(sur.SubscriptionId)
+	// Without a sub type the request would be rated as none of them
+	if sr.RequestSubType == 0 {
+		logger.RatingLog.Errorf("UE [%s] rating group [%d]: RequestSubType is missing", subscriberId, rg)
+		return &charging_datatype.ServiceUsageResponse{
+			SessionId:      sur.SessionId,
+			ResultCode:     diam.MissingAVP,
+			EventTimestamp: datatype.Time(time.Now()),
+		}
+	}
+	// The final usage is debited before the release, which only ends the rating of the session
+	key := ratingSessionKey{sessionId: string(sur.SessionId), subscriberId: subscriberId, ratingGroup: rg}
+	if sr.RequestSubType == charging_datatype.REQ_SUBTYPE_RELEASE {
+		ratingSessions.release(key)
+		logger.RatingLog.Debugf("UE [%s] rating group [%d] unit type [%d] released", subscriberId, rg, sr.CCUnitType)
+		return &charging_datatype.ServiceUsageResponse{
+			SessionId:      sur.SessionId,
+			ResultCode:     diam.Success,
+			EventTimestamp: datatype.Time(time.Now()),
+			ServiceRating: &charging_datatype.ServiceRating{
+				ServiceIdentifier: sr.ServiceIdentifier,
+				CCUnitType:        sr.CCUnitType,
+			},
 		}
-		// // Retrieve tarrif information from database
+	}
 
-		filter := bson.M{"ueId": subscriberId, "ratingGroup": rg}
-		chargingInterface, err := mongoapi.RestfulAPIGetOne(chargingDatasColl, filter)
+	now := time.Now()
+	plan, ok := ratingSessions.plan(key, now)
+	if !ok {
+		chargingInterface, err := tariffRepository.Tariff(subscriberId, rg)
 		if err != nil {
 			logger.ChargingdataPostLog.Errorf("Get tarrif error: %+v", err)
 		}
 		if chargingInterface == nil {
 			logger.ChargingdataPostLog.Warningf(
 				"No ChargingData found for UE:[%+v] for RG:[%+v]", subscriberId, rg)
-			return
+			return &charging_datatype.ServiceUsageResponse{
+				SessionId:      sur.SessionId,
+				ResultCode:     charging_code.UserUnknown,
+				EventTimestamp: datatype.Time(time.Now()),
+			}
 		}
-		unitCostStr := chargingInterface["unitCost"].(string)
-		monetaryTariff := buildTaffif(unitCostStr)
-		unitCost := datatype.Unsigned32(monetaryTariff.RateElement.UnitCost.ValueDigits) *
-			datatype.Unsigned32(math.Pow10(int(monetaryTariff.RateElement.UnitCost.Exponent)))
-		sua := charging_datatype.ServiceUsageResponse{
+		if plan, err = tariffPlanOf(chargingInterface); err != nil {
+			logger.RatingLog.Errorf("Invalid tariff of UE:[%+v] for RG:[%+v]: %+v", subscriberId, rg, err)
+			return &charging_datatype.ServiceUsageResponse{
+				SessionId:      sur.SessionId,
+				ResultCode:     diam.UnableToComply,
+				EventTimestamp: datatype.Time(time.Now()),
+			}
+		}
+		ratingSessions.setPlan(key, plan, now)
+	}
+
+	// Rate at the time given by the CHF, so that the usage is priced with the tariffs it was granted with
+	ratingTime := time.Time(sur.ActualTime)
+	if ratingTime.IsZero() {
+		ratingTime = now
+	}
+	counters := ratingSessions.counters(key, sr.Counter, ratingTime)
+	monetaryTariff := plan.monetaryTariffAt(ratingTime, counters)
+	sua := charging_datatype.ServiceUsageResponse{
+		SessionId:      sur.SessionId,
+		ResultCode:     diam.Success,
+		EventTimestamp: datatype.Time(time.Now()),
+		ServiceRating: &charging_datatype.ServiceRating{
+			ServiceIdentifier: sr.ServiceIdentifier,
+			CCUnitType:        sr.CCUnitType,
+			MonetaryTariff:    monetaryTariff,
+		},
+	}
+
+	// The monetary quota is only rated in the currency of the tariff
+	monetaryQuota, currencyCode := money.FromCCMoney(sr.MonetaryQuota)
+	if sr.MonetaryQuota != nil && currencyCode != plan.CurrencyCode {
+		logger.RatingLog.Errorf("UE [%s] rating group [%d]: monetary quota in currency %d, tariff in currency %d",
+			subscriberId, rg, currencyCode, plan.CurrencyCode)
+		return &charging_datatype.ServiceUsageResponse{
 			SessionId:      sur.SessionId,
+			ResultCode:     charging_code.RatingFailed,
 			EventTimestamp: datatype.Time(time.Now()),
-			ServiceRating: &charging_datatype.ServiceRating{
-				MonetaryTariff: monetaryTariff,
-			},
 		}
+	}
 
-		switch sr.RequestSubType {
-		// price for the consumed units
-		case charging_datatype.REQ_SUBTYPE_DEBIT:
-			monetaryCost = sr.ConsumedUnits * unitCost
-			sua.ServiceRating.AllowedUnits = datatype.Unsigned32(0)
-			sua.ServiceRating.Price = monetaryCost
-		// price for the reserved units
-		case charging_datatype.REQ_SUBTYPE_RESERVE:
-			sua.ServiceRating.AllowedUnits = sr.MonetaryQuota / unitCost
-			sua.ServiceRating.Price = sua.ServiceRating.AllowedUnits * unitCost
-		default:
-			logger.RatingLog.Warnf("Unknow request type")
-			sua.ServiceRating.AllowedUnits = datatype.Unsigned32(0)
-			sua.ServiceRating.Price = datatype.Unsigned32(0)
-		}
+	rateElement := findRateElement(monetaryTariff.RateElement, sr.CCUnitType)
+	unitCost := unitCostOf(rateElement)
 
-		a := m.Answer(diam.Success)
-		err = a.Marshal(&sua)
-		if err != nil {
-			logger.RatingLog.Errorf("Marshal SUA Err: %+v:", err)
+	// The units consumed after the tariff switch are priced with the next tariff.
+	// The CHF may reuse the tariff until it expires, at the latest at the tariff switch.
+	nextUnitCost := unitCost
+	expiryTime := ratingTime.Add(tariffValidity)
+	if tariffSwitch, ok := plan.nextSwitch(ratingTime); ok {
+		nextMonetaryTariff := charging_datatype.NextMonetaryTariff(*plan.monetaryTariffAt(tariffSwitch, counters))
+		sua.ServiceRating.NextMonetaryTariff = &nextMonetaryTariff
+		sua.ServiceRating.TariffSwitchTime = datatype.Unsigned32(tariffSwitch.Sub(ratingTime).Seconds())
+		nextUnitCost = unitCostOf(findRateElement(nextMonetaryTariff.RateElement, sr.CCUnitType))
+		if tariffSwitch.Before(expiryTime) {
+			expiryTime = tariffSwitch
 		}
+	}
+	sua.ServiceRating.ExpiryTime = (*datatype.Time)(&expiryTime)
 
-		_, err = a.WriteTo(c)
-		if err != nil {
-			logger.RatingLog.Errorf("Failed to write message to %s: %s\n%s\n",
-				c.RemoteAddr(), err, a)
-			return
+	plan.counterRating(sua.ServiceRating)
+	counter := plan.counterOf(sr.CCUnitType)
+	// The tariff of a counted unit type is valid until the next tier of its counter
+	if counter != nil {
+		sua.ServiceRating.ValidUnits = datatype.Unsigned32(counter.validUnits(counter.valueOf(counters, ratingTime)))
+	}
+
+	var price money.Money
+	switch {
+	// price for the requested units, neither reserved nor counted
+	case sr.RequestSubType == charging_datatype.REQ_SUBTYPE_AOC:
+		price = plan.adviceOfCharge(sr.CCUnitType, uint32(sr.RequestedUnits), counters, ratingTime)
+	// price by the tiers of the counter, whatever the tariff switch
+	case counter != nil && sr.RequestSubType == charging_datatype.REQ_SUBTYPE_DEBIT:
+		value := counter.valueOf(counters, ratingTime)
+		units := int64(sr.ConsumedUnits) + int64(sr.ConsumedUnitsAfterTariffSwitch)
+		price = counter.priceOf(value, units)
+		sua.ServiceRating.CounterPrice = []*charging_datatype.CounterPrice{
+			{CounterId: datatype.UTF8String(counter.CounterId), Price: price.CCMoney(plan.CurrencyCode)},
 		}
+		// The ABMF persists the counter impacts
+		if units != 0 {
+			impact := &charging_datatype.ImpactOnCounter{
+				CounterId:          datatype.UTF8String(counter.CounterId),
+				CounterValueChange: datatype.Integer64(units),
+				CounterValue:       datatype.Unsigned64(value + units),
+				CounterExpiryDate:  counter.expiryDate(ratingTime),
+			}
+			sua.ServiceRating.ImpactOnCounter = []*charging_datatype.ImpactOnCounter{impact}
+			ratingSessions.count(key, impact)
+		}
+	case counter != nil && sr.RequestSubType == charging_datatype.REQ_SUBTYPE_RESERVE:
+		value := counter.valueOf(counters, ratingTime)
+		allowedUnits := counter.allowedUnits(value, monetaryQuota)
+		sua.ServiceRating.AllowedUnits = datatype.Unsigned32(allowedUnits)
+		price = counter.priceOf(value, int64(allowedUnits))
+	case rateElement == nil:
+		logger.RatingLog.Warnf("UE [%s] rating group [%d] is not charged by unit type [%d]",
+			subscriberId, rg, sr.CCUnitType)
+	// price for the consumed units
+	case sr.RequestSubType == charging_datatype.REQ_SUBTYPE_DEBIT:
+		price = unitCost.Mul(uint64(sr.ConsumedUnits)).Add(
+			nextUnitCost.Mul(uint64(sr.ConsumedUnitsAfterTariffSwitch)))
+	// price for the reserved units, the units the quota cannot pay entirely are not allowed
+	case sr.RequestSubType == charging_datatype.REQ_SUBTYPE_RESERVE:
+		if unitCost.IsZero() {
+			// Free of charge, any amount of units is allowed
+			sua.ServiceRating.AllowedUnits = datatype.Unsigned32(math.MaxUint32)
+			break
+		}
+		allowedUnits := min(monetaryQuota.Units(unitCost), math.MaxUint32)
+		sua.ServiceRating.AllowedUnits = datatype.Unsigned32(allowedUnits)
+		price = unitCost.Mul(allowedUnits)
+	default:
+		logger.RatingLog.Warnf("Unknow request type")
+	}
+	sua.ServiceRating.Price = price.CCMoney(plan.CurrencyCode)
+
+	return &sua
+}
+
+func writeSUA(c diam.Conn, m *diam.Message, sua *charging_datatype.ServiceUsageResponse) {
+	a := m.Answer(uint32(sua.ResultCode))
+	err := a.Marshal(sua)
+	if err != nil {
+		logger.RatingLog.Errorf("Marshal SUA Err: %+v:", err)
+	}
+
+	_, err = a.WriteTo(c)
+	if err != nil {
+		logger.RatingLog.Errorf("Failed to write message to %s: %s\n%s\n",
+			c.RemoteAddr(), err, a)
+		return
 	}
 }
 
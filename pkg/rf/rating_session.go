@@ -0,0 +1,141 @@
+package rf
+
+import (
+	"sync"
+	"time"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+)
+
+const (
+	// Rating sessions the CHF never released, e.g. as it restarted, are forgotten once idle for this long
+	ratingSessionIdleTimeout = time.Hour
+	// The idle rating sessions are removed at most this often
+	ratingSessionPurgeInterval = time.Minute
+)
+
+// ratingSessionKey identifies a rating group of a rating session of a subscriber
+type ratingSessionKey struct {
+	sessionId    string
+	subscriberId string
+	ratingGroup  uint32
+}
+
+// ratingSession is the rating state of a rating group of a session until the CHF releases it: the tariff plan
+// it is rated with, reused for the tariff validity, and the values its debits brought the counters to, so that
+// units are not priced again from a counter the CHF sends before it applied their impacts
+type ratingSession struct {
+	plan       *tariffPlan
+	planExpiry time.Time
+	counters   map[string]*charging_datatype.Counter
+	lastUsed   time.Time
+}
+
+type ratingSessionStore struct {
+	mu        sync.Mutex
+	sessions  map[ratingSessionKey]*ratingSession
+	lastPurge time.Time
+}
+
+// Rating state of the sessions rated by the rating function
+var ratingSessions = newRatingSessionStore()
+
+func newRatingSessionStore() *ratingSessionStore {
+	return &ratingSessionStore{
+		sessions: make(map[ratingSessionKey]*ratingSession),
+	}
+}
+
+// session returns the rating session of the key, created if unknown, the caller shall hold mu
+func (s *ratingSessionStore) session(key ratingSessionKey, now time.Time) *ratingSession {
+	if now.Sub(s.lastPurge) >= ratingSessionPurgeInterval {
+		s.purge(now)
+	}
+	session, ok := s.sessions[key]
+	if !ok {
+		session = &ratingSession{counters: make(map[string]*charging_datatype.Counter)}
+		s.sessions[key] = session
+	}
+	session.lastUsed = now
+	return session
+}
+
+// plan returns the tariff plan the session was rated with if it is still valid at now
+func (s *ratingSessionStore) plan(key ratingSessionKey, now time.Time) (*tariffPlan, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[key]
+	if !ok || session.plan == nil || !now.Before(session.planExpiry) {
+		return nil, false
+	}
+	session.lastUsed = now
+	return session.plan, true
+}
+
+// setPlan keeps the tariff plan read from the tariff repository for the tariff validity
+func (s *ratingSessionStore) setPlan(key ratingSessionKey, plan *tariffPlan, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.session(key, now)
+	session.plan = plan
+	session.planExpiry = now.Add(tariffValidity)
+}
+
+// counters are the counters sent by the CHF, each raised to the value the debits of the session brought it to
+// if higher and not expired at the rating time
+func (s *ratingSessionStore) counters(
+	key ratingSessionKey, sent []*charging_datatype.Counter, ratingTime time.Time,
+) []*charging_datatype.Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.session(key, time.Now())
+	counters := make([]*charging_datatype.Counter, 0, len(sent)+len(session.counters))
+	seen := make(map[string]bool)
+	for _, counter := range sent {
+		counterId := string(counter.CounterId)
+		seen[counterId] = true
+		if counted, ok := session.counters[counterId]; ok && counted.CounterValue > counter.CounterValue &&
+			!expired(counted, ratingTime) {
+			counter = counted
+		}
+		counters = append(counters, counter)
+	}
+	for counterId, counted := range session.counters {
+		if !seen[counterId] && !expired(counted, ratingTime) {
+			counters = append(counters, counted)
+		}
+	}
+	return counters
+}
+
+// count keeps the value a debit of the session brought the counter to
+func (s *ratingSessionStore) count(key ratingSessionKey, impact *charging_datatype.ImpactOnCounter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.session(key, time.Now())
+	session.counters[string(impact.CounterId)] = &charging_datatype.Counter{
+		CounterId:         impact.CounterId,
+		CounterValue:      impact.CounterValue,
+		CounterExpiryDate: impact.CounterExpiryDate,
+	}
+}
+
+// release forgets the rating state of the session
+func (s *ratingSessionStore) release(key ratingSessionKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, key)
+}
+
+func (s *ratingSessionStore) purge(now time.Time) {
+	for key, session := range s.sessions {
+		if now.Sub(session.lastUsed) >= ratingSessionIdleTimeout {
+			delete(s.sessions, key)
+		}
+	}
+	s.lastPurge = now
+}
+
+func expired(counter *charging_datatype.Counter, ratingTime time.Time) bool {
+	return counter.CounterExpiryDate != nil && !ratingTime.Before(time.Time(*counter.CounterExpiryDate))
+}
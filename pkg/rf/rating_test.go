@@ -0,0 +1,80 @@
+package rf
+
+import (
+	"testing"
+
+	"github.com/fiorix/go-diameter/diam"
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestRatingSession(t *testing.T) {
+	// 1000 octets included, then 1 per octet
+	repository, err := NewMemoryTariffRepository(&TariffCatalog{
+		Plans: []*Plan{{
+			PlanId: "bundle",
+			Tariffs: []*RatingGroupTariff{{RatingGroup: 1, Tariff: map[string]interface{}{
+				"unitCost": "1",
+				"counters": []interface{}{
+					map[string]interface{}{
+						"counterId": "monthlyVolume",
+						"unitType":  "volume",
+						"tiers": []interface{}{
+							map[string]interface{}{"upTo": 1000, "unitCost": "0"},
+							map[string]interface{}{"unitCost": "1"},
+						},
+					},
+				},
+			}}},
+		}},
+		DefaultPlanId: "bundle",
+	})
+	require.NoError(t, err)
+	previousRepository, previousSessions := tariffRepository, ratingSessions
+	tariffRepository, ratingSessions = repository, newRatingSessionStore()
+	t.Cleanup(func() {
+		tariffRepository, ratingSessions = previousRepository, previousSessions
+	})
+
+	surOf := func(requestSubType charging_datatype.RequestSubType, units uint32) *charging_datatype.ServiceUsageRequest {
+		return &charging_datatype.ServiceUsageRequest{
+			SessionId: "1",
+			SubscriptionId: &charging_datatype.SubscriptionId{
+				SubscriptionIdType: charging_datatype.END_USER_IMSI,
+				SubscriptionIdData: "208930000000001",
+			},
+			ServiceRating: &charging_datatype.ServiceRating{
+				ServiceIdentifier: 1,
+				CCUnitType:        charging_datatype.TOTALOCTETS,
+				RequestSubType:    requestSubType,
+				ConsumedUnits:     datatype.Unsigned32(units),
+				// The CHF has not applied the impacts of the debits yet
+				Counter: []*charging_datatype.Counter{{CounterId: "monthlyVolume"}},
+			},
+		}
+	}
+	key := ratingSessionKey{sessionId: "1", subscriberId: "imsi-208930000000001", ratingGroup: 1}
+
+	// The second debit is priced from the value the first one brought the counter to
+	for _, expected := range []string{"0", "600"} {
+		sua := rateServiceUsage(surOf(charging_datatype.REQ_SUBTYPE_DEBIT, 800))
+		require.Equal(t, datatype.Unsigned32(diam.Success), sua.ResultCode)
+		price, _ := money.FromCCMoney(sua.ServiceRating.Price)
+		require.Equal(t, expected, price.String())
+	}
+	require.Contains(t, ratingSessions.sessions, key)
+	require.Equal(t, datatype.Unsigned64(1600), ratingSessions.sessions[key].counters["monthlyVolume"].CounterValue)
+
+	// The release frees the tariff and the counters of the session
+	sua := rateServiceUsage(surOf(charging_datatype.REQ_SUBTYPE_RELEASE, 0))
+	require.Equal(t, datatype.Unsigned32(diam.Success), sua.ResultCode)
+	require.NotContains(t, ratingSessions.sessions, key)
+
+	// A request without sub type is not rated
+	sua = rateServiceUsage(surOf(0, 800))
+	require.Equal(t, datatype.Unsigned32(diam.MissingAVP), sua.ResultCode)
+	require.Empty(t, ratingSessions.sessions)
+}
@@ -0,0 +1,197 @@
+package rf
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/chf/internal/money"
+)
+
+// Look ahead for the next tariff switch at most one week, after which the windows repeat
+const tariffSwitchLookahead = 7 * 24 * time.Hour
+
+// tariffCosts are the decimal unit costs of a tariff, e.g. "0.005",
+// an empty cost means the unit type is not charged
+type tariffCosts struct {
+	UnitCost                string `bson:"unitCost"`
+	TimeUnitCost            string `bson:"timeUnitCost"`
+	ServiceSpecificUnitCost string `bson:"serviceSpecificUnitCost"`
+}
+
+// tariffWindow applies its costs on the given days (0 is Sunday, every day if empty) from StartTime to EndTime,
+// both formatted as "15:04". A window ending before it starts spans midnight.
+type tariffWindow struct {
+	Costs     tariffCosts `bson:",inline"`
+	Name      string      `bson:"name"`
+	Days      []int       `bson:"days"`
+	StartTime string      `bson:"startTime"`
+	EndTime   string      `bson:"endTime"`
+
+	start, end time.Duration
+}
+
+// tariffPlan is the charging data of a rating group: the costs outside of every window,
+// the calendar windows, e.g. peak/off-peak and weekend, the first matching window applies,
+// and the counters pricing their unit type by tiers whatever the window
+type tariffPlan struct {
+	Costs        tariffCosts     `bson:",inline"`
+	CurrencyCode uint32          `bson:"currencyCode"`
+	TimeZone     string          `bson:"timeZone"`
+	Windows      []tariffWindow  `bson:"tariffWindows"`
+	Counters     []tariffCounter `bson:"counters"`
+
+	location *time.Location
+}
+
+func tariffPlanOf(chargingInterface map[string]interface{}) (*tariffPlan, error) {
+	raw, err := bson.Marshal(chargingInterface)
+	if err != nil {
+		return nil, err
+	}
+	plan := &tariffPlan{}
+	if err = bson.Unmarshal(raw, plan); err != nil {
+		return nil, err
+	}
+
+	if plan.CurrencyCode == 0 {
+		plan.CurrencyCode = money.DefaultCurrencyCode
+	}
+	if err = plan.Costs.validate(); err != nil {
+		return nil, err
+	}
+	plan.location = time.Local
+	if plan.TimeZone != "" {
+		if plan.location, err = time.LoadLocation(plan.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	for i := range plan.Counters {
+		if _, ok := counterUnitTypeOf(plan.Counters[i].UnitType); !ok {
+			return nil, fmt.Errorf("counter %q: unknown unit type %q", plan.Counters[i].CounterId, plan.Counters[i].UnitType)
+		}
+		if len(plan.Counters[i].Tiers) == 0 {
+			return nil, fmt.Errorf("counter %q: no tier", plan.Counters[i].CounterId)
+		}
+		for _, tier := range plan.Counters[i].Tiers {
+			if _, err = money.Parse(tier.UnitCost); err != nil {
+				return nil, fmt.Errorf("counter %q: %w", plan.Counters[i].CounterId, err)
+			}
+		}
+	}
+	for i := range plan.Windows {
+		window := &plan.Windows[i]
+		if window.start, err = timeOfDay(window.StartTime); err != nil {
+			return nil, fmt.Errorf("tariff window %q: %w", window.Name, err)
+		}
+		if window.end, err = timeOfDay(window.EndTime); err != nil {
+			return nil, fmt.Errorf("tariff window %q: %w", window.Name, err)
+		}
+		if err = window.Costs.validate(); err != nil {
+			return nil, fmt.Errorf("tariff window %q: %w", window.Name, err)
+		}
+	}
+	return plan, nil
+}
+
+func (c tariffCosts) validate() error {
+	for _, cost := range []string{c.UnitCost, c.TimeUnitCost, c.ServiceSpecificUnitCost} {
+		if cost == "" {
+			continue
+		}
+		if _, err := money.Parse(cost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// costOf is the unit cost of a validated tariff
+func costOf(cost string) money.Money {
+	unitCost, err := money.Parse(cost)
+	if err != nil {
+		return money.Money{}
+	}
+	return unitCost
+}
+
+func timeOfDay(clock string) (time.Duration, error) {
+	if clock == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// costsAt returns the costs of the tariff applying at t
+func (p *tariffPlan) costsAt(t time.Time) tariffCosts {
+	t = t.In(p.location)
+	for i := range p.Windows {
+		if p.Windows[i].contains(t) {
+			return p.Windows[i].Costs
+		}
+	}
+	return p.Costs
+}
+
+// nextSwitch returns the first time after t at which another tariff applies
+func (p *tariffPlan) nextSwitch(t time.Time) (time.Time, bool) {
+	t = t.In(p.location)
+	current := p.costsAt(t)
+
+	// The tariff can only change at the bounds of the windows
+	var bounds []time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.location)
+	for day := 0; day <= int(tariffSwitchLookahead/(24*time.Hour)); day++ {
+		date := midnight.AddDate(0, 0, day)
+		for i := range p.Windows {
+			for _, offset := range []time.Duration{p.Windows[i].start, p.Windows[i].end} {
+				bound := date.Add(offset)
+				if bound.After(t) && !bound.After(t.Add(tariffSwitchLookahead)) {
+					bounds = append(bounds, bound)
+				}
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+
+	for _, bound := range bounds {
+		if p.costsAt(bound) != current {
+			return bound, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w *tariffWindow) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	weekday := int(t.Weekday())
+
+	switch {
+	case w.start == w.end:
+		return w.onDay(weekday)
+	case w.start < w.end:
+		return w.onDay(weekday) && offset >= w.start && offset < w.end
+	default:
+		// The window spans midnight, it belongs to the day it starts
+		return (w.onDay(weekday) && offset >= w.start) || (w.onDay((weekday+6)%7) && offset < w.end)
+	}
+}
+
+func (w *tariffWindow) onDay(weekday int) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if day == weekday {
+			return true
+		}
+	}
+	return false
+}
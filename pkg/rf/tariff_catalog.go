@@ -0,0 +1,88 @@
+package rf
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+// FileTariffRepository resolves the tariffs of a YAML or JSON tariff catalog file,
+// the catalog is reloaded whenever the file changes
+type FileTariffRepository struct {
+	MemoryTariffRepository
+	path    string
+	modTime time.Time
+}
+
+func NewFileTariffRepository(path string) (*FileTariffRepository, error) {
+	r := &FileTariffRepository{path: path}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Watch reloads the catalog every interval if the file changed, until ctx is done.
+// A catalog that cannot be loaded is reported and the previous one stays in use.
+func (r *FileTariffRepository) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.reload(); err != nil {
+				logger.RatingLog.Errorf("Reload tariff catalog %s error: %+v", r.path, err)
+			}
+		}
+	}
+}
+
+// reload loads the catalog if the file changed since it was last loaded
+func (r *FileTariffRepository) reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(r.modTime) {
+		return false, nil
+	}
+
+	catalog, err := readTariffCatalog(r.path)
+	if err != nil {
+		return false, err
+	}
+	if err = r.Update(catalog); err != nil {
+		return false, err
+	}
+	r.modTime = info.ModTime()
+	logger.RatingLog.Infof("Tariff catalog %s loaded: %d plans, %d subscriber groups, %d subscribers",
+		r.path, len(catalog.Plans), len(catalog.Groups), len(catalog.Subscribers))
+	return true, nil
+}
+
+func readTariffCatalog(path string) (*TariffCatalog, error) {
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &TariffCatalog{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, catalog)
+	} else {
+		err = yaml.Unmarshal(content, catalog)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
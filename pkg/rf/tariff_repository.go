@@ -0,0 +1,252 @@
+package rf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/util/mongoapi"
+)
+
+const (
+	chargingDatasColl    = "policyData.ues.chargingData"
+	tariffPlansColl      = "chf.tariffPlans"
+	subscriberGroupsColl = "chf.subscriberGroups"
+)
+
+// TariffRepository is where the rating function reads the tariffs from
+type TariffRepository interface {
+	// Tariff returns the charging data document of the rating group of the subscriber,
+	// nil if the subscriber is not rated for the rating group
+	Tariff(ueId string, ratingGroup uint32) (map[string]interface{}, error)
+}
+
+// Tariff repository of the rating function, set when the server is opened
+var tariffRepository TariffRepository = &MongoTariffRepository{}
+
+// NewTariffRepository opens the tariff repository configured, a file repository is reloaded until ctx is done
+func NewTariffRepository(ctx context.Context, source *factory.TariffSource) (TariffRepository, error) {
+	if source == nil || source.Type == "" || source.Type == "mongodb" {
+		return &MongoTariffRepository{}, nil
+	}
+
+	repository, err := NewFileTariffRepository(source.CatalogPath)
+	if err != nil {
+		return nil, err
+	}
+	reloadInterval := source.ReloadInterval
+	if reloadInterval == 0 {
+		reloadInterval = factory.ChfDefaultTariffReloadInterval
+	}
+	go repository.Watch(ctx, time.Duration(reloadInterval)*time.Second)
+	return repository, nil
+}
+
+// subscriberIdOf is the identifier of the subscriber the tariffs are assigned to, e.g. "imsi-208930000000001"
+func subscriberIdOf(subscriptionId *charging_datatype.SubscriptionId) string {
+	if subscriptionId == nil {
+		return ""
+	}
+	data := string(subscriptionId.SubscriptionIdData)
+	switch subscriptionId.SubscriptionIdType {
+	case charging_datatype.END_USER_IMSI:
+		return "imsi-" + data
+	case charging_datatype.END_USER_NAI:
+		return "nai-" + data
+	case charging_datatype.END_USER_E164:
+		return "msisdn-" + data
+	}
+	return data
+}
+
+// MongoTariffRepository reads the charging data of the subscribers: the document of a rating group either holds
+// the tariff itself, or assigns the subscriber a plan ("planId") or a subscriber group ("groupId") whose plan
+// tariffs are stored once in the tariff plans collection
+type MongoTariffRepository struct{}
+
+func (r *MongoTariffRepository) Tariff(ueId string, ratingGroup uint32) (map[string]interface{}, error) {
+	chargingData, err := mongoapi.RestfulAPIGetOne(chargingDatasColl, bson.M{"ueId": ueId, "ratingGroup": ratingGroup})
+	if err != nil || chargingData == nil {
+		return nil, err
+	}
+
+	planId, _ := chargingData["planId"].(string)
+	if groupId, ok := chargingData["groupId"].(string); ok && planId == "" {
+		group, errGroup := mongoapi.RestfulAPIGetOne(subscriberGroupsColl, bson.M{"groupId": groupId})
+		if errGroup != nil {
+			return nil, errGroup
+		}
+		if group == nil {
+			return nil, fmt.Errorf("unknown subscriber group %q", groupId)
+		}
+		planId, _ = group["planId"].(string)
+	}
+	if planId == "" {
+		return chargingData, nil
+	}
+	return mongoapi.RestfulAPIGetOne(tariffPlansColl, bson.M{"planId": planId, "ratingGroup": ratingGroup})
+}
+
+// TariffCatalog assigns the tariffs of plans (products) to the subscribers,
+// either one by one or through the subscriber groups they are members of
+type TariffCatalog struct {
+	Plans       []*Plan            `yaml:"plans" json:"plans"`
+	Groups      []*SubscriberGroup `yaml:"groups" json:"groups"`
+	Subscribers []*Subscriber      `yaml:"subscribers" json:"subscribers"`
+	// Plan of the subscribers assigned none, the subscribers are not rated if not set
+	DefaultPlanId string `yaml:"defaultPlanId" json:"defaultPlanId"`
+}
+
+// Plan is a product offered to the subscribers with the tariff of each rating group it rates
+type Plan struct {
+	PlanId  string               `yaml:"planId" json:"planId"`
+	Tariffs []*RatingGroupTariff `yaml:"tariffs" json:"tariffs"`
+}
+
+// RatingGroupTariff holds the tariff of a rating group in the format of the charging data documents,
+// e.g. {"unitCost": "0.005", "tariffWindows": [...]}
+type RatingGroupTariff struct {
+	RatingGroup uint32                 `yaml:"ratingGroup" json:"ratingGroup"`
+	Tariff      map[string]interface{} `yaml:"tariff" json:"tariff"`
+}
+
+// SubscriberGroup assigns the same plan to all of its members, e.g. the lines of a corporate customer
+type SubscriberGroup struct {
+	GroupId string   `yaml:"groupId" json:"groupId"`
+	PlanId  string   `yaml:"planId" json:"planId"`
+	Members []string `yaml:"members" json:"members"`
+}
+
+// Subscriber is assigned a plan of its own, whatever the groups it is a member of
+type Subscriber struct {
+	UeId   string `yaml:"ueId" json:"ueId"`
+	PlanId string `yaml:"planId" json:"planId"`
+}
+
+// tariffIndex resolves the tariffs of a validated catalog
+type tariffIndex struct {
+	tariffs       map[string]map[uint32]map[string]interface{}
+	planOfUe      map[string]string
+	defaultPlanId string
+}
+
+func newTariffIndex(catalog *TariffCatalog) (*tariffIndex, error) {
+	index := &tariffIndex{
+		tariffs:       make(map[string]map[uint32]map[string]interface{}),
+		planOfUe:      make(map[string]string),
+		defaultPlanId: catalog.DefaultPlanId,
+	}
+
+	for _, plan := range catalog.Plans {
+		if _, ok := index.tariffs[plan.PlanId]; ok {
+			return nil, fmt.Errorf("plan %q: defined twice", plan.PlanId)
+		}
+		tariffs := make(map[uint32]map[string]interface{})
+		for _, rgTariff := range plan.Tariffs {
+			tariff, _ := stringKeys(rgTariff.Tariff).(map[string]interface{})
+			if _, err := tariffPlanOf(tariff); err != nil {
+				return nil, fmt.Errorf("plan %q rating group %d: %w", plan.PlanId, rgTariff.RatingGroup, err)
+			}
+			tariffs[rgTariff.RatingGroup] = tariff
+		}
+		index.tariffs[plan.PlanId] = tariffs
+	}
+
+	if err := index.checkPlan("default plan", catalog.DefaultPlanId); err != nil {
+		return nil, err
+	}
+	for _, group := range catalog.Groups {
+		if err := index.checkPlan(fmt.Sprintf("subscriber group %q", group.GroupId), group.PlanId); err != nil {
+			return nil, err
+		}
+		for _, member := range group.Members {
+			index.planOfUe[member] = group.PlanId
+		}
+	}
+	// The plan of a subscriber overrides the plan of its groups
+	for _, subscriber := range catalog.Subscribers {
+		if err := index.checkPlan(fmt.Sprintf("subscriber %q", subscriber.UeId), subscriber.PlanId); err != nil {
+			return nil, err
+		}
+		index.planOfUe[subscriber.UeId] = subscriber.PlanId
+	}
+	return index, nil
+}
+
+func (i *tariffIndex) checkPlan(assignee, planId string) error {
+	if planId == "" {
+		return nil
+	}
+	if _, ok := i.tariffs[planId]; !ok {
+		return fmt.Errorf("%s: unknown plan %q", assignee, planId)
+	}
+	return nil
+}
+
+func (i *tariffIndex) tariff(ueId string, ratingGroup uint32) map[string]interface{} {
+	planId, ok := i.planOfUe[ueId]
+	if !ok || planId == "" {
+		planId = i.defaultPlanId
+	}
+	return i.tariffs[planId][ratingGroup]
+}
+
+// stringKeys converts the maps decoded from YAML to string keyed maps, as the documents read from MongoDB
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = stringKeys(item)
+		}
+		return v
+	case []interface{}:
+		for j := range v {
+			v[j] = stringKeys(v[j])
+		}
+		return v
+	}
+	return value
+}
+
+// MemoryTariffRepository resolves the tariffs of a catalog held in memory, e.g. for tests
+type MemoryTariffRepository struct {
+	mu    sync.RWMutex
+	index *tariffIndex
+}
+
+func NewMemoryTariffRepository(catalog *TariffCatalog) (*MemoryTariffRepository, error) {
+	r := &MemoryTariffRepository{}
+	if err := r.Update(catalog); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update replaces the catalog, the current one is kept if the new one is not valid
+func (r *MemoryTariffRepository) Update(catalog *TariffCatalog) error {
+	index, err := newTariffIndex(catalog)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index = index
+	return nil
+}
+
+func (r *MemoryTariffRepository) Tariff(ueId string, ratingGroup uint32) (map[string]interface{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.index.tariff(ueId, ratingGroup), nil
+}
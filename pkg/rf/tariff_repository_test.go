@@ -0,0 +1,219 @@
+package rf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+)
+
+func TestMemoryTariffRepository(t *testing.T) {
+	repository, err := NewMemoryTariffRepository(&TariffCatalog{
+		Plans: []*Plan{planOf("basic", "3"), planOf("corporate", "2"), planOf("premium", "1")},
+		Groups: []*SubscriberGroup{
+			{GroupId: "acme", PlanId: "corporate", Members: []string{"imsi-208930000000002", "imsi-208930000000003"}},
+		},
+		Subscribers:   []*Subscriber{{UeId: "imsi-208930000000003", PlanId: "premium"}},
+		DefaultPlanId: "basic",
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		ueId        string
+		ratingGroup uint32
+		unitCost    interface{}
+	}{
+		{name: "default plan", ueId: "imsi-208930000000001", ratingGroup: 1, unitCost: "3"},
+		{name: "group plan", ueId: "imsi-208930000000002", ratingGroup: 1, unitCost: "2"},
+		{name: "subscriber plan over group plan", ueId: "imsi-208930000000003", ratingGroup: 1, unitCost: "1"},
+		{name: "rating group not rated", ueId: "imsi-208930000000002", ratingGroup: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tariff, errTariff := repository.Tariff(tc.ueId, tc.ratingGroup)
+			require.NoError(t, errTariff)
+			if tc.unitCost == nil {
+				require.Nil(t, tariff)
+				return
+			}
+			require.Equal(t, tc.unitCost, tariff["unitCost"])
+		})
+	}
+}
+
+func TestMemoryTariffRepositoryInvalidCatalog(t *testing.T) {
+	basic := planOf("basic", "3")
+	repository, err := NewMemoryTariffRepository(&TariffCatalog{Plans: []*Plan{basic}, DefaultPlanId: "basic"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		catalog *TariffCatalog
+	}{
+		{
+			name:    "invalid tariff",
+			catalog: &TariffCatalog{Plans: []*Plan{planOf("basic", "0,5")}},
+		},
+		{
+			name: "unknown plan of a group",
+			catalog: &TariffCatalog{
+				Plans:  []*Plan{basic},
+				Groups: []*SubscriberGroup{{GroupId: "acme", PlanId: "corporate"}},
+			},
+		},
+		{
+			name:    "plan defined twice",
+			catalog: &TariffCatalog{Plans: []*Plan{basic, basic}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Error(t, repository.Update(tc.catalog))
+			// The current catalog stays in use
+			tariff, errTariff := repository.Tariff("imsi-208930000000001", 1)
+			require.NoError(t, errTariff)
+			require.Equal(t, "3", tariff["unitCost"])
+		})
+	}
+}
+
+// planOf is a plan rating the rating group 1 by volume
+func planOf(planId, unitCost string) *Plan {
+	return &Plan{
+		PlanId:  planId,
+		Tariffs: []*RatingGroupTariff{{RatingGroup: 1, Tariff: map[string]interface{}{"unitCost": unitCost}}},
+	}
+}
+
+func TestFileTariffRepository(t *testing.T) {
+	testCases := []struct {
+		name    string
+		file    string
+		catalog string
+		updated string
+	}{
+		{
+			name: "yaml",
+			file: "tariffs.yaml",
+			catalog: `
+plans:
+  - planId: basic
+    tariffs:
+      - ratingGroup: 1
+        tariff:
+          unitCost: "3"
+          tariffWindows:
+            - name: weekend
+              days: [0, 6]
+              unitCost: "1"
+defaultPlanId: basic
+`,
+			updated: `
+plans:
+  - planId: basic
+    tariffs:
+      - ratingGroup: 1
+        tariff:
+          unitCost: "4"
+defaultPlanId: basic
+`,
+		},
+		{
+			name: "json",
+			file: "tariffs.json",
+			catalog: `{"plans": [{"planId": "basic", "tariffs": [{"ratingGroup": 1, "tariff": {"unitCost": "3",
+				"tariffWindows": [{"name": "weekend", "days": [0, 6], "unitCost": "1"}]}}]}],
+				"defaultPlanId": "basic"}`,
+			updated: `{"plans": [{"planId": "basic", "tariffs": [{"ratingGroup": 1, "tariff": {"unitCost": "4"}}]}],
+				"defaultPlanId": "basic"}`,
+		},
+	}
+
+	monday := time.Date(2026, 3, 9, 12, 0, 0, 0, time.Local)
+	sunday := time.Date(2026, 3, 8, 12, 0, 0, 0, time.Local)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			require.NoError(t, os.WriteFile(path, []byte(tc.catalog), 0o600))
+			repository, err := NewFileTariffRepository(path)
+			require.NoError(t, err)
+
+			tariff, err := repository.Tariff("imsi-208930000000001", 1)
+			require.NoError(t, err)
+			plan, err := tariffPlanOf(tariff)
+			require.NoError(t, err)
+			require.Equal(t, "3", plan.costsAt(monday).UnitCost)
+			require.Equal(t, "1", plan.costsAt(sunday).UnitCost)
+
+			// Unchanged file
+			reloaded, err := repository.reload()
+			require.NoError(t, err)
+			require.False(t, reloaded)
+
+			// An invalid catalog is not loaded
+			require.NoError(t, os.WriteFile(path, []byte("plans: ["), 0o600))
+			require.NoError(t, os.Chtimes(path, monday, monday))
+			_, err = repository.reload()
+			require.Error(t, err)
+			tariff, err = repository.Tariff("imsi-208930000000001", 1)
+			require.NoError(t, err)
+			require.Equal(t, "3", tariff["unitCost"])
+
+			require.NoError(t, os.WriteFile(path, []byte(tc.updated), 0o600))
+			require.NoError(t, os.Chtimes(path, sunday, sunday))
+			reloaded, err = repository.reload()
+			require.NoError(t, err)
+			require.True(t, reloaded)
+			tariff, err = repository.Tariff("imsi-208930000000001", 1)
+			require.NoError(t, err)
+			require.Equal(t, "4", tariff["unitCost"])
+		})
+	}
+}
+
+func TestSubscriberIdOf(t *testing.T) {
+	testCases := []struct {
+		name           string
+		subscriptionId *charging_datatype.SubscriptionId
+		subscriberId   string
+	}{
+		{
+			name: "imsi",
+			subscriptionId: &charging_datatype.SubscriptionId{
+				SubscriptionIdType: charging_datatype.END_USER_IMSI,
+				SubscriptionIdData: datatype.UTF8String("208930000000001"),
+			},
+			subscriberId: "imsi-208930000000001",
+		},
+		{
+			name: "nai",
+			subscriptionId: &charging_datatype.SubscriptionId{
+				SubscriptionIdType: charging_datatype.END_USER_NAI,
+				SubscriptionIdData: datatype.UTF8String("user@example.com"),
+			},
+			subscriberId: "nai-user@example.com",
+		},
+		{
+			name: "msisdn",
+			subscriptionId: &charging_datatype.SubscriptionId{
+				SubscriptionIdType: charging_datatype.END_USER_E164,
+				SubscriptionIdData: datatype.UTF8String("886912345678"),
+			},
+			subscriberId: "msisdn-886912345678",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.subscriberId, subscriberIdOf(tc.subscriptionId))
+		})
+	}
+}
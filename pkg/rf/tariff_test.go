@@ -0,0 +1,103 @@
+package rf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/money"
+)
+
+func TestTariffPlan(t *testing.T) {
+	plan, err := tariffPlanOf(map[string]interface{}{
+		"ueId":        "imsi-208930000000001",
+		"ratingGroup": 1,
+		"unitCost":    "3",
+		"timeZone":    "UTC",
+		"tariffWindows": []interface{}{
+			map[string]interface{}{
+				"name":     "weekend",
+				"days":     []interface{}{0, 6},
+				"unitCost": "1",
+			},
+			map[string]interface{}{
+				"name":      "offPeak",
+				"startTime": "22:00",
+				"endTime":   "07:00",
+				"unitCost":  "2",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		ratingTime   time.Time
+		unitCost     string
+		tariffSwitch time.Time
+	}{
+		{
+			name:         "peak on a weekday",
+			ratingTime:   time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC),
+			unitCost:     "3",
+			tariffSwitch: time.Date(2026, 3, 11, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:         "off-peak spanning midnight",
+			ratingTime:   time.Date(2026, 3, 12, 2, 0, 0, 0, time.UTC),
+			unitCost:     "2",
+			tariffSwitch: time.Date(2026, 3, 12, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:         "off-peak before the weekend",
+			ratingTime:   time.Date(2026, 3, 13, 23, 0, 0, 0, time.UTC),
+			unitCost:     "2",
+			tariffSwitch: time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:         "weekend",
+			ratingTime:   time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC),
+			unitCost:     "1",
+			tariffSwitch: time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.unitCost, plan.costsAt(tc.ratingTime).UnitCost)
+			tariffSwitch, ok := plan.nextSwitch(tc.ratingTime)
+			require.True(t, ok)
+			require.Equal(t, tc.tariffSwitch, tariffSwitch.UTC())
+		})
+	}
+}
+
+func TestTariffPlanWithoutWindows(t *testing.T) {
+	plan, err := tariffPlanOf(map[string]interface{}{"unitCost": "1"})
+	require.NoError(t, err)
+
+	_, ok := plan.nextSwitch(time.Now())
+	require.False(t, ok)
+}
+
+func TestTariffPlanCosts(t *testing.T) {
+	plan, err := tariffPlanOf(map[string]interface{}{"unitCost": "0.005", "timeUnitCost": "2"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(money.DefaultCurrencyCode), plan.CurrencyCode)
+
+	monetaryTariff := plan.monetaryTariffAt(time.Now(), nil)
+	require.Equal(t, datatype.Unsigned32(money.DefaultCurrencyCode), monetaryTariff.CurrencyCode)
+	require.Equal(t, money.New(5, -3),
+		unitCostOf(findRateElement(monetaryTariff.RateElement, charging_datatype.TOTALOCTETS)))
+	require.Equal(t, money.FromInt(2), unitCostOf(findRateElement(monetaryTariff.RateElement, charging_datatype.TIME)))
+
+	plan, err = tariffPlanOf(map[string]interface{}{"unitCost": "1", "currencyCode": 978})
+	require.NoError(t, err)
+	require.Equal(t, uint32(978), plan.CurrencyCode)
+
+	_, err = tariffPlanOf(map[string]interface{}{"unitCost": "0,005"})
+	require.Error(t, err)
+}
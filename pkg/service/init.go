@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -146,6 +147,12 @@ func (a *ChfApp) Start() {
 
 	a.wg.Add(1)
 	abmf.OpenServer(a.ctx, &a.wg)
+	// Policy counters and subscriptions are stored in the database connected by the ABMF
+	a.processor.StartSpendingLimitControl()
+
+	a.wg.Add(1)
+	idleTimeout := time.Duration(a.cfg.Configuration.SessionIdleTimeout) * time.Second
+	go a.processor.SweepChargingSessions(a.ctx, &a.wg, idleTimeout)
 
 	a.wg.Add(1)
 	go a.listenShutdownEvent()